@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"strings"
+
+	"k8s.io/client-go/tools/metrics"
+)
+
+// attributeUserAgent appends the attribution label of the context of req to
+// its User-Agent, if the client is configured to.
+func (r *Request) attributeUserAgent(req *http.Request) {
+	if !r.c.userAgentAttribution {
+		return
+	}
+	label := sanitizeAttribution(metrics.AttributionFrom(req.Context()))
+	if len(label) == 0 {
+		return
+	}
+	userAgent := req.Header.Get("User-Agent")
+	if len(userAgent) == 0 {
+		userAgent = r.c.userAgent
+	}
+	if len(userAgent) == 0 {
+		userAgent = DefaultKubernetesUserAgent()
+	}
+	// the headers are shared with the Request, so copy them.
+	header := req.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("User-Agent", userAgent+" ("+label+")")
+	req.Header = header
+}
+
+// sanitizeAttribution drops the characters of label that are not allowed in
+// a comment of a User-Agent.
+func sanitizeAttribution(label string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '(' || r == ')' || r == '\\' {
+			return -1
+		}
+		return r
+	}, label)
+}
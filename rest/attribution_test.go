@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/metrics"
+)
+
+type attributionMetric struct {
+	lock   sync.Mutex
+	labels []string
+}
+
+func (m *attributionMetric) observe(ctx context.Context) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.labels = append(m.labels, metrics.AttributionFrom(ctx))
+}
+
+func (m *attributionMetric) Observe(ctx context.Context, verb string, u url.URL, latency time.Duration) {
+	m.observe(ctx)
+}
+
+func (m *attributionMetric) Increment(ctx context.Context, code string, method string, host string) {
+	m.observe(ctx)
+}
+
+func TestAttribution(t *testing.T) {
+	var lock sync.Mutex
+	var userAgents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		userAgents = append(userAgents, r.UserAgent())
+	}))
+	defer server.Close()
+
+	// the metrics can only be registered once, so swap them directly.
+	latency, result := &attributionMetric{}, &attributionMetric{}
+	defer func(l metrics.LatencyMetric, r metrics.ResultMetric) {
+		metrics.RequestLatency, metrics.RequestResult = l, r
+	}(metrics.RequestLatency, metrics.RequestResult)
+	metrics.RequestLatency, metrics.RequestResult = latency, result
+
+	for _, attribution := range []bool{false, true} {
+		client, err := RESTClientFor(&Config{
+			Host:                 server.URL,
+			APIPath:              "/api",
+			UserAgent:            "operator/v1",
+			UserAgentAttribution: attribution,
+			ContentConfig: ContentConfig{
+				GroupVersion:         &v1.SchemeGroupVersion,
+				NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := metrics.WithAttribution(context.Background(), "pod-controller\n(shard-1)")
+		if err := client.Get().Resource("pods").Do(ctx).Error(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	expectedUserAgents := []string{"operator/v1", "operator/v1 (pod-controllershard-1)"}
+	if len(userAgents) != 2 || userAgents[0] != expectedUserAgents[0] || userAgents[1] != expectedUserAgents[1] {
+		t.Errorf("expected user agents %q, got %q", expectedUserAgents, userAgents)
+	}
+	for name, m := range map[string]*attributionMetric{"latency": latency, "result": result} {
+		if len(m.labels) != 2 || m.labels[0] != "pod-controller\n(shard-1)" || m.labels[1] != m.labels[0] {
+			t.Errorf("%s: expected the attribution in every observation, got %q", name, m.labels)
+		}
+	}
+}
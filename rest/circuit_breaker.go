@@ -0,0 +1,316 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/metrics"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+)
+
+// States of a circuit, as reported by metrics.CircuitBreakerState.
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker is a BackoffManager that stops sending requests to a host and
+// resource which keeps failing. It learns the outcome of requests through
+// UpdateBackoff, like any BackoffManager, delays them while they fail, and
+// makes them fail fast with a *CircuitOpenError once the circuit is open.
+type CircuitBreaker interface {
+	BackoffManager
+	// Allow returns a *CircuitOpenError if requests to actualUrl must not be
+	// sent. Every allowed request is followed by a call to UpdateBackoff with
+	// its outcome.
+	Allow(actualUrl *url.URL) error
+}
+
+// CircuitOpenError is returned for requests that were not sent because the
+// circuit breaker for their host and resource is open.
+type CircuitOpenError struct {
+	// Host is the host the request was for.
+	Host string
+	// Resource is the group/resource the request was for, if any.
+	Resource schema.GroupResource
+	// RetryAfter is the time until the next request is allowed to probe
+	// whether the server recovered.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	target := e.Host
+	if len(e.Resource.Resource) > 0 {
+		target = fmt.Sprintf("%s for %s", e.Host, e.Resource.String())
+	}
+	return fmt.Sprintf("circuit breaker is open for %s, retry after %s", target, e.RetryAfter)
+}
+
+// IsCircuitOpen returns true if err is a *CircuitOpenError.
+func IsCircuitOpen(err error) bool {
+	var circuitErr *CircuitOpenError
+	return errors.As(err, &circuitErr)
+}
+
+// NewCircuitBreaker returns a CircuitBreaker that opens the circuit of a host
+// and group/resource after failureThreshold consecutive requests to it failed
+// with a transport error, a timeout or a 5xx response. Until then, requests to
+// a failing host and group/resource are delayed by backoff, like URLBackoff
+// does for hosts; a nil backoff does not delay them. After cooldown, a single
+// request is let through to probe the server; the circuit closes again if it
+// succeeds, and stays open for another cooldown otherwise. A 429 response
+// neither closes the circuit nor counts as a failure.
+//
+// The circuit breaker can be shared by several clients.
+func NewCircuitBreaker(failureThreshold int, cooldown time.Duration, backoff *flowcontrol.Backoff) CircuitBreaker {
+	return newCircuitBreaker(failureThreshold, cooldown, backoff)
+}
+
+func newCircuitBreaker(failureThreshold int, cooldown time.Duration, backoff *flowcontrol.Backoff) *circuitBreaker {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	if backoff == nil {
+		backoff = flowcontrol.NewBackOff(0, 0)
+	}
+	return &circuitBreaker{
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+		backoff:          backoff,
+		circuits:         map[circuitKey]*circuit{},
+	}
+}
+
+type circuitBreaker struct {
+	failureThreshold int
+	cooldown         time.Duration
+	// backoff delays the requests to failing hosts and resources, keyed by
+	// circuitKey.String(). Its clock is the clock of the circuit breaker.
+	backoff *flowcontrol.Backoff
+
+	lock     sync.Mutex
+	circuits map[circuitKey]*circuit
+}
+
+type circuitKey struct {
+	host     string
+	resource schema.GroupResource
+}
+
+func (k circuitKey) String() string {
+	return k.host + "/" + k.resource.String()
+}
+
+type circuit struct {
+	state    string
+	failures int
+	openedAt time.Time
+	// probing is set while the single request allowed in the half-open state
+	// is in flight.
+	probing bool
+}
+
+var _ CircuitBreaker = &circuitBreaker{}
+
+func (b *circuitBreaker) Allow(actualUrl *url.URL) error {
+	key := circuitKeyFor(actualUrl)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	c, ok := b.circuits[key]
+	if !ok {
+		return nil
+	}
+	switch c.state {
+	case circuitOpen:
+		if elapsed := b.backoff.Clock.Since(c.openedAt); elapsed < b.cooldown {
+			metrics.CircuitBreakerRejected.Increment(key.host, key.resource.String())
+			return &CircuitOpenError{Host: key.host, Resource: key.resource, RetryAfter: b.cooldown - elapsed}
+		}
+		b.setState(key, c, circuitHalfOpen)
+		c.probing = true
+	case circuitHalfOpen:
+		if c.probing {
+			metrics.CircuitBreakerRejected.Increment(key.host, key.resource.String())
+			return &CircuitOpenError{Host: key.host, Resource: key.resource}
+		}
+		c.probing = true
+	}
+	return nil
+}
+
+// UpdateBackoff records the outcome of a request to actualUrl: the error
+// sending it, or the status code of its response.
+func (b *circuitBreaker) UpdateBackoff(actualUrl *url.URL, err error, responseCode int) {
+	key := circuitKeyFor(actualUrl)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	c, ok := b.circuits[key]
+	switch {
+	case errors.Is(err, context.Canceled):
+		// the caller gave up, which says nothing about the server.
+		if ok {
+			c.probing = false
+		}
+		return
+	case err == nil && serverIsOverloadedSet.Has(responseCode):
+		// the server is up but overloaded: back off without counting a
+		// failure, and keep the circuit open if this was the probe.
+		b.backoff.Next(key.String(), b.backoff.Clock.Now())
+		if ok && c.state == circuitHalfOpen {
+			c.probing = false
+			c.openedAt = b.backoff.Clock.Now()
+			b.setState(key, c, circuitOpen)
+		}
+		return
+	case err == nil && responseCode <= maxResponseCode:
+		b.backoff.Reset(key.String())
+		if ok {
+			if c.state != circuitClosed {
+				klog.V(2).Infof("Closing the circuit breaker for %s %s", key.host, key.resource.String())
+			}
+			delete(b.circuits, key)
+			metrics.CircuitBreakerState.Set(key.host, key.resource.String(), circuitClosed)
+		}
+		return
+	}
+
+	b.backoff.Next(key.String(), b.backoff.Clock.Now())
+	if !ok {
+		c = &circuit{state: circuitClosed}
+		b.circuits[key] = c
+	}
+	c.failures++
+	c.probing = false
+	if c.state == circuitHalfOpen || (c.state == circuitClosed && c.failures >= b.failureThreshold) {
+		klog.V(2).Infof("Opening the circuit breaker for %s %s after %d failures: code %d, error %v", key.host, key.resource.String(), c.failures, responseCode, err)
+		c.openedAt = b.backoff.Clock.Now()
+		b.setState(key, c, circuitOpen)
+	}
+}
+
+// CalculateBackoff returns the delay of requests to a failing host and
+// resource. Requests are not delayed while the circuit is open, Allow makes
+// them fail fast instead.
+func (b *circuitBreaker) CalculateBackoff(actualUrl *url.URL) time.Duration {
+	key := circuitKeyFor(actualUrl)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if c, ok := b.circuits[key]; ok && c.state != circuitClosed {
+		return 0
+	}
+	return b.backoff.Get(key.String())
+}
+
+func (b *circuitBreaker) Sleep(d time.Duration) {
+	b.backoff.Clock.Sleep(d)
+}
+
+func (b *circuitBreaker) setState(key circuitKey, c *circuit, state string) {
+	c.state = state
+	metrics.CircuitBreakerState.Set(key.host, key.resource.String(), state)
+}
+
+// circuitKeyFor returns the host and group/resource of a request URL. Paths
+// that are not API resource paths are keyed by host only.
+func circuitKeyFor(u *url.URL) circuitKey {
+	key := circuitKey{host: u.Host}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i, segment := range segments {
+		var rest []string
+		switch segment {
+		case "api":
+			if len(segments) < i+3 {
+				return key
+			}
+			// api/<version>/...
+			rest = segments[i+2:]
+		case "apis":
+			if len(segments) < i+4 {
+				return key
+			}
+			// apis/<group>/<version>/...
+			key.resource.Group = segments[i+1]
+			rest = segments[i+3:]
+		default:
+			continue
+		}
+		if rest[0] == "watch" && len(rest) > 1 {
+			rest = rest[1:]
+		}
+		if rest[0] == "namespaces" && len(rest) > 2 {
+			rest = rest[2:]
+		}
+		key.resource.Resource = rest[0]
+		return key
+	}
+	return key
+}
+
+// circuitBreakerBackoff is the backoff manager of a request sent through a
+// circuit breaker. The outcome of the request is reported to both, and it is
+// delayed by the longest of their backoffs.
+type circuitBreakerBackoff struct {
+	BackoffManager
+	breaker CircuitBreaker
+}
+
+// withCircuitBreaker returns backoff, combined with breaker if it is set.
+func withCircuitBreaker(backoff BackoffManager, breaker CircuitBreaker) BackoffManager {
+	if breaker == nil {
+		return backoff
+	}
+	return &circuitBreakerBackoff{BackoffManager: backoff, breaker: breaker}
+}
+
+func (b *circuitBreakerBackoff) Allow(actualUrl *url.URL) error {
+	return b.breaker.Allow(actualUrl)
+}
+
+func (b *circuitBreakerBackoff) UpdateBackoff(actualUrl *url.URL, err error, responseCode int) {
+	b.breaker.UpdateBackoff(actualUrl, err, responseCode)
+	b.BackoffManager.UpdateBackoff(actualUrl, err, responseCode)
+}
+
+func (b *circuitBreakerBackoff) CalculateBackoff(actualUrl *url.URL) time.Duration {
+	d := b.BackoffManager.CalculateBackoff(actualUrl)
+	if breakerDelay := b.breaker.CalculateBackoff(actualUrl); breakerDelay > d {
+		return breakerDelay
+	}
+	return d
+}
+
+var _ CircuitBreaker = &circuitBreakerBackoff{}
+
+// checkCircuitBreaker returns a *CircuitOpenError if the circuit breaker of
+// the request does not allow it to be sent.
+func (r *Request) checkCircuitBreaker() error {
+	breaker, ok := r.backoff.(CircuitBreaker)
+	if !ok {
+		return nil
+	}
+	return breaker.Allow(r.URL())
+}
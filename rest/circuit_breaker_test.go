@@ -0,0 +1,261 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	b := newCircuitBreaker(3, 10*time.Second, flowcontrol.NewFakeBackOff(time.Second, time.Minute, fakeClock))
+	metricsURL, _ := url.Parse("https://server/apis/metrics.k8s.io/v1beta1/namespaces/default/pods")
+	podsURL, _ := url.Parse("https://server/api/v1/namespaces/default/pods")
+	failure := errors.New("connection refused")
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(metricsURL); err != nil {
+			t.Fatalf("unexpected error before the threshold: %v", err)
+		}
+		b.UpdateBackoff(metricsURL, nil, http.StatusServiceUnavailable)
+	}
+	// requests are delayed while they fail, like with URLBackoff.
+	if d := b.CalculateBackoff(metricsURL); d != 2*time.Second {
+		t.Errorf("expected a backoff of 2s, got %s", d)
+	}
+	if d := b.CalculateBackoff(podsURL); d != 0 {
+		t.Errorf("other resources must not be delayed, got %s", d)
+	}
+	// a success resets the count of consecutive failures.
+	b.UpdateBackoff(metricsURL, nil, http.StatusOK)
+	if d := b.CalculateBackoff(metricsURL); d != 0 {
+		t.Errorf("expected no backoff after a success, got %s", d)
+	}
+	for i := 0; i < 3; i++ {
+		if err := b.Allow(metricsURL); err != nil {
+			t.Fatalf("unexpected error before the threshold: %v", err)
+		}
+		b.UpdateBackoff(metricsURL, failure, 0)
+	}
+
+	err := b.Allow(metricsURL)
+	if !IsCircuitOpen(err) {
+		t.Fatalf("expected the circuit to be open, got %v", err)
+	}
+	expected := &CircuitOpenError{
+		Host:       "server",
+		Resource:   schema.GroupResource{Group: "metrics.k8s.io", Resource: "pods"},
+		RetryAfter: 10 * time.Second,
+	}
+	if *err.(*CircuitOpenError) != *expected {
+		t.Errorf("expected %#v, got %#v", expected, err)
+	}
+	if err := b.Allow(podsURL); err != nil {
+		t.Errorf("other resources must not be affected: %v", err)
+	}
+	if d := b.CalculateBackoff(metricsURL); d != 0 {
+		t.Errorf("expected requests to fail fast instead of being delayed, got %s", d)
+	}
+
+	// after the cooldown a single probe is allowed, and a failed probe opens
+	// the circuit again.
+	fakeClock.Step(10 * time.Second)
+	if err := b.Allow(metricsURL); err != nil {
+		t.Fatalf("expected a probe to be allowed: %v", err)
+	}
+	if err := b.Allow(metricsURL); !IsCircuitOpen(err) {
+		t.Fatalf("expected a single probe, got %v", err)
+	}
+	b.UpdateBackoff(metricsURL, nil, http.StatusGatewayTimeout)
+	if err := b.Allow(metricsURL); !IsCircuitOpen(err) {
+		t.Fatalf("expected the circuit to open again, got %v", err)
+	}
+
+	// a canceled probe allows another one.
+	fakeClock.Step(10 * time.Second)
+	if err := b.Allow(metricsURL); err != nil {
+		t.Fatalf("expected a probe to be allowed: %v", err)
+	}
+	b.UpdateBackoff(metricsURL, &url.Error{Op: "Get", URL: metricsURL.String(), Err: context.Canceled}, 0)
+	if err := b.Allow(metricsURL); err != nil {
+		t.Fatalf("expected another probe to be allowed: %v", err)
+	}
+
+	// a successful probe closes the circuit.
+	b.UpdateBackoff(metricsURL, nil, http.StatusNotFound)
+	for i := 0; i < 3; i++ {
+		if err := b.Allow(metricsURL); err != nil {
+			t.Fatalf("expected the circuit to be closed: %v", err)
+		}
+	}
+}
+
+func TestCircuitBreakerTooManyRequests(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	b := newCircuitBreaker(2, 10*time.Second, flowcontrol.NewFakeBackOff(time.Second, time.Minute, fakeClock))
+	podsURL, _ := url.Parse("https://server/api/v1/namespaces/default/pods")
+
+	// 429 responses are not failures, they don't open the circuit.
+	for i := 0; i < 3; i++ {
+		if err := b.Allow(podsURL); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b.UpdateBackoff(podsURL, nil, http.StatusTooManyRequests)
+	}
+	if d := b.CalculateBackoff(podsURL); d != 4*time.Second {
+		t.Errorf("expected a backoff of 4s, got %s", d)
+	}
+
+	for i := 0; i < 2; i++ {
+		b.UpdateBackoff(podsURL, nil, http.StatusInternalServerError)
+	}
+	if err := b.Allow(podsURL); !IsCircuitOpen(err) {
+		t.Fatalf("expected the circuit to be open, got %v", err)
+	}
+
+	// nor are they successes: a probe answered with 429 keeps the circuit
+	// open for another cooldown.
+	fakeClock.Step(10 * time.Second)
+	if err := b.Allow(podsURL); err != nil {
+		t.Fatalf("expected a probe to be allowed: %v", err)
+	}
+	b.UpdateBackoff(podsURL, nil, http.StatusTooManyRequests)
+	err := b.Allow(podsURL)
+	if !IsCircuitOpen(err) {
+		t.Fatalf("expected the circuit to stay open, got %v", err)
+	}
+	if retryAfter := err.(*CircuitOpenError).RetryAfter; retryAfter != 10*time.Second {
+		t.Errorf("expected to retry after 10s, got %s", retryAfter)
+	}
+
+	fakeClock.Step(10 * time.Second)
+	if err := b.Allow(podsURL); err != nil {
+		t.Fatalf("expected a probe to be allowed: %v", err)
+	}
+	b.UpdateBackoff(podsURL, nil, http.StatusOK)
+	if err := b.Allow(podsURL); err != nil {
+		t.Errorf("expected the circuit to be closed: %v", err)
+	}
+}
+
+func TestCircuitKeyFor(t *testing.T) {
+	testCases := map[string]circuitKey{
+		"https://server/api/v1/pods":                                         {host: "server", resource: schema.GroupResource{Resource: "pods"}},
+		"https://server/api/v1/namespaces/default/pods/foo/log":              {host: "server", resource: schema.GroupResource{Resource: "pods"}},
+		"https://server/api/v1/namespaces/default":                           {host: "server", resource: schema.GroupResource{Resource: "namespaces"}},
+		"https://server/api/v1/watch/namespaces/default/pods":                {host: "server", resource: schema.GroupResource{Resource: "pods"}},
+		"https://server/apis/apps/v1/namespaces/default/deployments/foo":     {host: "server", resource: schema.GroupResource{Group: "apps", Resource: "deployments"}},
+		"https://server:6443/prefix/apis/example.com/v1/widgets":             {host: "server:6443", resource: schema.GroupResource{Group: "example.com", Resource: "widgets"}},
+		"https://server/apis/apps/v1":                                        {host: "server"},
+		"https://server/api":                                                 {host: "server"},
+		"https://server/version":                                             {host: "server"},
+		"https://server/apis/metrics.k8s.io/v1beta1/namespaces/default/pods": {host: "server", resource: schema.GroupResource{Group: "metrics.k8s.io", Resource: "pods"}},
+	}
+	for rawURL, expected := range testCases {
+		u, _ := url.Parse(rawURL)
+		if key := circuitKeyFor(u); key != expected {
+			t.Errorf("%s: expected %#v, got %#v", rawURL, expected, key)
+		}
+	}
+}
+
+func TestCircuitBreakerRequests(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if strings.HasPrefix(r.URL.Path, "/apis/metrics.k8s.io/") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","items":[]}`))
+	}))
+	defer server.Close()
+
+	config := &Config{
+		Host:           server.URL,
+		APIPath:        "/apis/metrics.k8s.io",
+		CircuitBreaker: NewCircuitBreaker(2, time.Hour, nil),
+		ContentConfig: ContentConfig{
+			GroupVersion:         &schema.GroupVersion{Group: "metrics.k8s.io", Version: "v1beta1"},
+			NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		},
+	}
+	metricsClient, err := RESTClientFor(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coreConfig := CopyConfig(config)
+	coreConfig.APIPath = "/api"
+	coreConfig.GroupVersion = &v1.SchemeGroupVersion
+	coreClient, err := RESTClientFor(coreConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err := metricsClient.Get().Namespace("default").Resource("pods").Do(context.Background()).Error()
+		if err == nil || IsCircuitOpen(err) {
+			t.Fatalf("expected the server error, got %v", err)
+		}
+	}
+	for _, call := range []func() error{
+		func() error { return metricsClient.Get().Resource("pods").Do(context.Background()).Error() },
+		func() error {
+			return metricsClient.Get().Resource("pods").BackOff(&NoBackoff{}).Do(context.Background()).Error()
+		},
+		func() error {
+			_, err := metricsClient.Get().Resource("pods").Watch(context.Background())
+			return err
+		},
+		func() error {
+			_, err := metricsClient.Get().Resource("pods").Stream(context.Background())
+			return err
+		},
+	} {
+		if err := call(); !IsCircuitOpen(err) {
+			t.Errorf("expected the circuit to be open, got %v", err)
+		}
+	}
+	if requests := atomic.LoadInt32(&requests); requests != 2 {
+		t.Errorf("expected 2 requests to reach the server, got %d", requests)
+	}
+
+	// the circuit breaker is shared by the copy of the config, but other
+	// resources are not affected.
+	if err := coreClient.Get().Resource("pods").Do(context.Background()).Error(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !strings.Contains(fmt.Sprint(metricsClient.Get().Resource("pods").Do(context.Background()).Error()), "metrics.k8s.io") {
+		t.Errorf("expected the error to name the resource")
+	}
+}
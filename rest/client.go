@@ -27,6 +27,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/transport"
 	"k8s.io/client-go/util/flowcontrol"
 )
 
@@ -94,10 +95,44 @@ type RESTClient struct {
 	// overridden.
 	rateLimiter flowcontrol.RateLimiter
 
+	// rateLimiterPolicy selects the rate limiter of requests created by this client.
+	// If not set, or if it selects no rate limiter, rateLimiter is used.
+	rateLimiterPolicy RateLimiterPolicy
+
 	// warningHandler is shared among all requests created by this client.
 	// If not set, defaultWarningHandler is used.
 	warningHandler WarningHandler
 
+	// tracer starts a span for every call to Do, Watch or Stream of requests
+	// created by this client. If not set, requests are not traced.
+	tracer Tracer
+
+	// requestLogger writes a record of every request created by this client.
+	// If not set, requests are not logged.
+	requestLogger *RequestLogger
+
+	// maxResponseBytes limits the size of the responses read into memory by
+	// requests created by this client. If zero, there is no limit.
+	maxResponseBytes int64
+
+	// circuitBreaker is shared among all requests created by this client.
+	// If not set, requests are always sent.
+	circuitBreaker CircuitBreaker
+
+	// userAgentAttribution appends the attribution label of the context of
+	// requests created by this client to userAgent, or to the default
+	// User-Agent if it is empty.
+	userAgentAttribution bool
+	userAgent            string
+
+	// impersonate is the impersonation of the config, the impersonation set
+	// on the context of a request must not conflict with it.
+	impersonate transport.ImpersonationConfig
+
+	// retryPolicy is shared among all requests created by this client.
+	// If not set, only retries requested by the server are performed.
+	retryPolicy RetryPolicy
+
 	// Set specific behavior of the client.  If not set http.DefaultClient will be used.
 	Client *http.Client
 }
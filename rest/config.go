@@ -56,6 +56,14 @@ type Config struct {
 	// be appended to all request URIs used to access the apiserver. This allows a frontend
 	// proxy to easily relocate all of the apiserver endpoints.
 	Host string
+	// FailoverHosts are further hosts of the same API server, in the format
+	// of Host, that requests are sent to in order when Host cannot be reached.
+	// Requests stick to the endpoint they were last sent to successfully, and
+	// unreachable endpoints are avoided for a growing backoff period. Watches
+	// that break are re-established on another endpoint. All endpoints must
+	// present certificates signed by the same certificate authority, valid for
+	// ServerName or their own hostname.
+	FailoverHosts []string
 	// APIPath is a sub-path that points to an API root.
 	APIPath string
 
@@ -95,10 +103,21 @@ type Config struct {
 	// UserAgent is an optional field that specifies the caller of this request.
 	UserAgent string
 
+	// UserAgentAttribution appends the attribution label of the context of a
+	// request, set with metrics.WithAttribution(), to its User-Agent.
+	UserAgentAttribution bool
+
 	// DisableCompression bypasses automatic GZip compression requests to the
 	// server.
 	DisableCompression bool
 
+	// RequestCompressionThreshold, if greater than zero, is the size in bytes
+	// from which the bodies of create, update and patch requests are sent
+	// gzipped. Servers that reject compressed bodies with a 415 response are
+	// detected, and are sent uncompressed bodies from then on. Only set it for
+	// servers known to accept compressed bodies, kube-apiserver does not.
+	RequestCompressionThreshold int
+
 	// Transport may be used for custom HTTP behavior. This attribute may not
 	// be specified with the TLS client certificate options. Use WrapTransport
 	// to provide additional per-server middleware behavior.
@@ -121,26 +140,84 @@ type Config struct {
 	Burst int
 
 	// Rate limiter for limiting connections to the master from this client. If present overwrites QPS/Burst
+	// If it implements flowcontrol.AdaptiveRateLimiter, it observes the response of every request,
+	// see flowcontrol.NewAdaptiveRateLimiter().
 	RateLimiter flowcontrol.RateLimiter
 
+	// RateLimiterPolicy selects a separate rate limiter for requests based on their
+	// verb, resource, and whether they are watches or mutations. Requests it assigns
+	// no rate limiter to use RateLimiter, or QPS/Burst. Watches are only throttled if
+	// the policy assigns a rate limiter to them. See RateLimiterRules.
+	RateLimiterPolicy RateLimiterPolicy
+
+	// InFlightLimiter, if set, limits the number of requests of the client that
+	// are in flight at the same time, separately for long-running and short
+	// requests. It may be shared by copies of the config to limit all of their
+	// clients together. See flowcontrol.NewMaxInFlightLimiter().
+	InFlightLimiter flowcontrol.InFlightLimiter
+
 	// WarningHandler handles warnings in server responses.
 	// If not set, the default warning handler is used.
 	// See documentation for SetDefaultWarningHandler() for details.
 	WarningHandler WarningHandler
 
+	// Tracer, if set, starts a span for every call to Do, DoRaw, Watch or Stream, and the
+	// W3C 'traceparent' header of the span is sent with the request.
+	Tracer Tracer
+
+	// RequestLogger, if set, writes a structured record of the requests it samples for auditing.
+	RequestLogger *RequestLogger
+
+	// RetryPolicy decides whether requests that failed with a transient
+	// error are retried. If not set, requests are only retried when the
+	// server asks for it with a 'Retry-After' response header.
+	// See NewDefaultRetryPolicy() for a policy that retries idempotent requests.
+	RetryPolicy RetryPolicy
+
+	// CircuitBreaker, if set, makes requests fail fast with a *CircuitOpenError
+	// after repeated failures of a host and resource, until the server recovers.
+	// It is combined with the BackoffManager of every request, and learns their
+	// outcome through it. See NewCircuitBreaker(). It may be shared by copies
+	// of the config.
+	CircuitBreaker CircuitBreaker
+
 	// The maximum length of time to wait before giving up on a server request. A value of zero means no timeout.
 	Timeout time.Duration
 
+	// MaxResponseBytes is the maximum size of a response body that is read into
+	// memory. Larger responses fail with a *ResponseTooLargeError. A value of zero
+	// means no limit. Streamed responses are not limited.
+	MaxResponseBytes int64
+
 	// Dial specifies the dial function for creating unencrypted TCP connections.
 	Dial func(ctx context.Context, network, address string) (net.Conn, error)
 
 	// Proxy is the proxy func to be used for all requests made by this
 	// transport. If Proxy is nil, http.ProxyFromEnvironment is used. If Proxy
 	// returns a nil *URL, no proxy is used.
-	//
-	// socks5 proxying does not currently support spdy streaming endpoints.
 	Proxy func(*http.Request) (*url.URL, error)
 
+	// HTTP2ReadIdleTimeout is the time after which a health check using a
+	// ping frame is carried out if no frame is received on an HTTP/2
+	// connection, so that connections silently dropped by the network are
+	// detected and closed. If zero, the value of the
+	// HTTP2_READ_IDLE_TIMEOUT_SECONDS environment variable is used, which
+	// defaults to 30 seconds. A negative value disables the health check.
+	HTTP2ReadIdleTimeout time.Duration
+
+	// HTTP2PingTimeout is the time after which an HTTP/2 connection is
+	// closed if no response to a health check ping is received. If zero,
+	// the value of the HTTP2_PING_TIMEOUT_SECONDS environment variable is
+	// used, which defaults to 15 seconds.
+	HTTP2PingTimeout time.Duration
+
+	// CoalesceRequests enables coalescing of concurrent identical GET
+	// requests into a single request to the server, whose response is
+	// copied for every caller. Watches, followed logs, upgrades and
+	// requests with a body are never coalesced. Responses larger than
+	// MaxResponseBytes, or 10MiB if it is zero, are not shared.
+	CoalesceRequests bool
+
 	// Version forces a specific version to be used (if registered)
 	// Do we need this?
 	// Version string
@@ -202,6 +279,8 @@ func (c *Config) String() string {
 type ImpersonationConfig struct {
 	// UserName is the username to impersonate on each request.
 	UserName string
+	// UID is a unique value that identifies the user.
+	UID string
 	// Groups are the groups to impersonate on each request.
 	Groups []string
 	// Extra is a free-form field which can be used to link some authentication information
@@ -241,6 +320,11 @@ type TLSClientConfig struct {
 	// To indicate to the server http/1.1 is preferred over http/2, set to ["http/1.1", "h2"] (though the server is free to ignore that preference).
 	// To use only http/1.1, set to ["http/1.1"].
 	NextProtos []string
+
+	// ReloadCAFile makes the client reload CAFile when it changes, so that
+	// the CAs can be rotated without restarting the client. The CAs are then
+	// always read from CAFile, and CAData is ignored.
+	ReloadCAFile bool
 }
 
 var _ fmt.Stringer = TLSClientConfig{}
@@ -267,6 +351,8 @@ func (c TLSClientConfig) String() string {
 		KeyData:    c.KeyData,
 		CAData:     c.CAData,
 		NextProtos: c.NextProtos,
+
+		ReloadCAFile: c.ReloadCAFile,
 	}
 	// Explicitly mark non-empty credential fields as redacted.
 	if len(cc.CertData) != 0 {
@@ -359,6 +445,31 @@ func RESTClientFor(config *Config) (*RESTClient, error) {
 	if err == nil && config.WarningHandler != nil {
 		restClient.warningHandler = config.WarningHandler
 	}
+	if err == nil && config.RetryPolicy != nil {
+		restClient.retryPolicy = config.RetryPolicy
+	}
+	if err == nil && config.Tracer != nil {
+		restClient.tracer = config.Tracer
+	}
+	if err == nil && config.CircuitBreaker != nil {
+		restClient.circuitBreaker = config.CircuitBreaker
+	}
+	if err == nil && config.UserAgentAttribution {
+		restClient.userAgentAttribution = true
+		restClient.userAgent = config.UserAgent
+	}
+	if err == nil {
+		restClient.impersonate = transportImpersonation(config.Impersonate)
+	}
+	if err == nil && config.RequestLogger != nil {
+		restClient.requestLogger = config.RequestLogger
+	}
+	if err == nil && config.MaxResponseBytes > 0 {
+		restClient.maxResponseBytes = config.MaxResponseBytes
+	}
+	if err == nil && config.RateLimiterPolicy != nil {
+		restClient.rateLimiterPolicy = config.RateLimiterPolicy
+	}
 	return restClient, err
 }
 
@@ -417,6 +528,31 @@ func UnversionedRESTClientFor(config *Config) (*RESTClient, error) {
 	if err == nil && config.WarningHandler != nil {
 		restClient.warningHandler = config.WarningHandler
 	}
+	if err == nil && config.RetryPolicy != nil {
+		restClient.retryPolicy = config.RetryPolicy
+	}
+	if err == nil && config.Tracer != nil {
+		restClient.tracer = config.Tracer
+	}
+	if err == nil && config.CircuitBreaker != nil {
+		restClient.circuitBreaker = config.CircuitBreaker
+	}
+	if err == nil && config.UserAgentAttribution {
+		restClient.userAgentAttribution = true
+		restClient.userAgent = config.UserAgent
+	}
+	if err == nil {
+		restClient.impersonate = transportImpersonation(config.Impersonate)
+	}
+	if err == nil && config.RequestLogger != nil {
+		restClient.requestLogger = config.RequestLogger
+	}
+	if err == nil && config.MaxResponseBytes > 0 {
+		restClient.maxResponseBytes = config.MaxResponseBytes
+	}
+	if err == nil && config.RateLimiterPolicy != nil {
+		restClient.rateLimiterPolicy = config.RateLimiterPolicy
+	}
 	return restClient, err
 }
 
@@ -576,6 +712,7 @@ func AnonymousClientConfig(config *Config) *Config {
 	// copy only known safe fields
 	return &Config{
 		Host:          config.Host,
+		FailoverHosts: config.FailoverHosts,
 		APIPath:       config.APIPath,
 		ContentConfig: config.ContentConfig,
 		TLSClientConfig: TLSClientConfig{
@@ -584,16 +721,30 @@ func AnonymousClientConfig(config *Config) *Config {
 			CAFile:     config.TLSClientConfig.CAFile,
 			CAData:     config.TLSClientConfig.CAData,
 			NextProtos: config.TLSClientConfig.NextProtos,
+
+			ReloadCAFile: config.TLSClientConfig.ReloadCAFile,
 		},
-		RateLimiter:        config.RateLimiter,
-		WarningHandler:     config.WarningHandler,
-		UserAgent:          config.UserAgent,
-		DisableCompression: config.DisableCompression,
-		QPS:                config.QPS,
-		Burst:              config.Burst,
-		Timeout:            config.Timeout,
-		Dial:               config.Dial,
-		Proxy:              config.Proxy,
+		RateLimiter:                 config.RateLimiter,
+		RateLimiterPolicy:           config.RateLimiterPolicy,
+		InFlightLimiter:             config.InFlightLimiter,
+		WarningHandler:              config.WarningHandler,
+		RetryPolicy:                 config.RetryPolicy,
+		CircuitBreaker:              config.CircuitBreaker,
+		Tracer:                      config.Tracer,
+		RequestLogger:               config.RequestLogger,
+		UserAgent:                   config.UserAgent,
+		UserAgentAttribution:        config.UserAgentAttribution,
+		DisableCompression:          config.DisableCompression,
+		RequestCompressionThreshold: config.RequestCompressionThreshold,
+		QPS:                         config.QPS,
+		Burst:                       config.Burst,
+		Timeout:                     config.Timeout,
+		MaxResponseBytes:            config.MaxResponseBytes,
+		Dial:                        config.Dial,
+		Proxy:                       config.Proxy,
+		HTTP2ReadIdleTimeout:        config.HTTP2ReadIdleTimeout,
+		HTTP2PingTimeout:            config.HTTP2PingTimeout,
+		CoalesceRequests:            config.CoalesceRequests,
 	}
 }
 
@@ -601,6 +752,7 @@ func AnonymousClientConfig(config *Config) *Config {
 func CopyConfig(config *Config) *Config {
 	c := &Config{
 		Host:            config.Host,
+		FailoverHosts:   config.FailoverHosts,
 		APIPath:         config.APIPath,
 		ContentConfig:   config.ContentConfig,
 		Username:        config.Username,
@@ -611,6 +763,7 @@ func CopyConfig(config *Config) *Config {
 			Groups:   config.Impersonate.Groups,
 			Extra:    config.Impersonate.Extra,
 			UserName: config.Impersonate.UserName,
+			UID:      config.Impersonate.UID,
 		},
 		AuthProvider:        config.AuthProvider,
 		AuthConfigPersister: config.AuthConfigPersister,
@@ -625,18 +778,32 @@ func CopyConfig(config *Config) *Config {
 			KeyData:    config.TLSClientConfig.KeyData,
 			CAData:     config.TLSClientConfig.CAData,
 			NextProtos: config.TLSClientConfig.NextProtos,
+
+			ReloadCAFile: config.TLSClientConfig.ReloadCAFile,
 		},
-		UserAgent:          config.UserAgent,
-		DisableCompression: config.DisableCompression,
-		Transport:          config.Transport,
-		WrapTransport:      config.WrapTransport,
-		QPS:                config.QPS,
-		Burst:              config.Burst,
-		RateLimiter:        config.RateLimiter,
-		WarningHandler:     config.WarningHandler,
-		Timeout:            config.Timeout,
-		Dial:               config.Dial,
-		Proxy:              config.Proxy,
+		UserAgent:                   config.UserAgent,
+		UserAgentAttribution:        config.UserAgentAttribution,
+		DisableCompression:          config.DisableCompression,
+		RequestCompressionThreshold: config.RequestCompressionThreshold,
+		Transport:                   config.Transport,
+		WrapTransport:               config.WrapTransport,
+		QPS:                         config.QPS,
+		Burst:                       config.Burst,
+		RateLimiter:                 config.RateLimiter,
+		RateLimiterPolicy:           config.RateLimiterPolicy,
+		InFlightLimiter:             config.InFlightLimiter,
+		WarningHandler:              config.WarningHandler,
+		RetryPolicy:                 config.RetryPolicy,
+		CircuitBreaker:              config.CircuitBreaker,
+		Tracer:                      config.Tracer,
+		RequestLogger:               config.RequestLogger,
+		Timeout:                     config.Timeout,
+		MaxResponseBytes:            config.MaxResponseBytes,
+		Dial:                        config.Dial,
+		Proxy:                       config.Proxy,
+		HTTP2ReadIdleTimeout:        config.HTTP2ReadIdleTimeout,
+		HTTP2PingTimeout:            config.HTTP2PingTimeout,
+		CoalesceRequests:            config.CoalesceRequests,
 	}
 	if config.ExecProvider != nil && config.ExecProvider.Config != nil {
 		c.ExecProvider.Config = config.ExecProvider.Config.DeepCopyObject()
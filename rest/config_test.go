@@ -262,6 +262,36 @@ type fakeWarningHandler struct{}
 
 func (f fakeWarningHandler) HandleWarningHeader(code int, agent string, message string) {}
 
+type fakeTracer struct{}
+
+func (f fakeTracer) StartSpan(ctx context.Context, attributes SpanAttributes) (context.Context, Span) {
+	return ctx, nil
+}
+
+type fakeInFlightLimiter struct{}
+
+func (f fakeInFlightLimiter) Acquire(context.Context, bool) (func(), error) {
+	return func() {}, nil
+}
+
+func (f fakeInFlightLimiter) InFlight() (int, int) {
+	return 0, 0
+}
+
+type fakeCircuitBreaker struct {
+	NoBackoff
+}
+
+func (f fakeCircuitBreaker) Allow(*url.URL) error {
+	return nil
+}
+
+type fakeRetryPolicy struct{}
+
+func (f fakeRetryPolicy) ShouldRetry(*http.Request, *http.Response, error, int, time.Duration) (time.Duration, bool) {
+	return 0, false
+}
+
 type fakeNegotiatedSerializer struct{}
 
 func (n *fakeNegotiatedSerializer) SupportedMediaTypes() []runtime.SerializerInfo {
@@ -326,6 +356,24 @@ func TestAnonymousConfig(t *testing.T) {
 		func(h *WarningHandler, f fuzz.Continue) {
 			*h = &fakeWarningHandler{}
 		},
+		func(p *RetryPolicy, f fuzz.Continue) {
+			*p = &fakeRetryPolicy{}
+		},
+		func(t *Tracer, f fuzz.Continue) {
+			*t = &fakeTracer{}
+		},
+		func(b *CircuitBreaker, f fuzz.Continue) {
+			*b = &fakeCircuitBreaker{}
+		},
+		func(l *flowcontrol.InFlightLimiter, f fuzz.Continue) {
+			*l = &fakeInFlightLimiter{}
+		},
+		func(l **RequestLogger, f fuzz.Continue) {
+			*l = &RequestLogger{SampleRatio: f.Float64()}
+		},
+		func(p *RateLimiterPolicy, f fuzz.Continue) {
+			*p = RateLimiterRules{{Name: "fake", RateLimiter: &fakeLimiter{}}}
+		},
 		// Authentication does not require fuzzer
 		func(r *AuthProviderConfigPersister, f fuzz.Continue) {},
 		func(r *clientcmdapi.AuthProviderConfig, f fuzz.Continue) {
@@ -424,6 +472,24 @@ func TestCopyConfig(t *testing.T) {
 		func(h *WarningHandler, f fuzz.Continue) {
 			*h = &fakeWarningHandler{}
 		},
+		func(p *RetryPolicy, f fuzz.Continue) {
+			*p = &fakeRetryPolicy{}
+		},
+		func(t *Tracer, f fuzz.Continue) {
+			*t = &fakeTracer{}
+		},
+		func(b *CircuitBreaker, f fuzz.Continue) {
+			*b = &fakeCircuitBreaker{}
+		},
+		func(l *flowcontrol.InFlightLimiter, f fuzz.Continue) {
+			*l = &fakeInFlightLimiter{}
+		},
+		func(l **RequestLogger, f fuzz.Continue) {
+			*l = &RequestLogger{SampleRatio: f.Float64()}
+		},
+		func(p *RateLimiterPolicy, f fuzz.Continue) {
+			*p = RateLimiterRules{{Name: "fake", RateLimiter: &fakeLimiter{}}}
+		},
 		func(r *AuthProviderConfigPersister, f fuzz.Continue) {
 			*r = fakeAuthProviderConfigPersister{}
 		},
@@ -626,7 +692,7 @@ func TestConfigSprint(t *testing.T) {
 		Proxy:          fakeProxyFunc,
 	}
 	want := fmt.Sprintf(
-		`&rest.Config{Host:"localhost:8080", APIPath:"v1", ContentConfig:rest.ContentConfig{AcceptContentTypes:"application/json", ContentType:"application/json", GroupVersion:(*schema.GroupVersion)(nil), NegotiatedSerializer:runtime.NegotiatedSerializer(nil)}, Username:"gopher", Password:"--- REDACTED ---", BearerToken:"--- REDACTED ---", BearerTokenFile:"", Impersonate:rest.ImpersonationConfig{UserName:"gopher2", Groups:[]string(nil), Extra:map[string][]string(nil)}, AuthProvider:api.AuthProviderConfig{Name: "gopher", Config: map[string]string{--- REDACTED ---}}, AuthConfigPersister:rest.AuthProviderConfigPersister(--- REDACTED ---), ExecProvider:api.ExecConfig{Command: "sudo", Args: []string{"--- REDACTED ---"}, Env: []ExecEnvVar{--- REDACTED ---}, APIVersion: "", ProvideClusterInfo: true, Config: runtime.Object(--- REDACTED ---), StdinUnavailable: false}, TLSClientConfig:rest.sanitizedTLSClientConfig{Insecure:false, ServerName:"", CertFile:"a.crt", KeyFile:"a.key", CAFile:"", CertData:[]uint8{0x2d, 0x2d, 0x2d, 0x20, 0x54, 0x52, 0x55, 0x4e, 0x43, 0x41, 0x54, 0x45, 0x44, 0x20, 0x2d, 0x2d, 0x2d}, KeyData:[]uint8{0x2d, 0x2d, 0x2d, 0x20, 0x52, 0x45, 0x44, 0x41, 0x43, 0x54, 0x45, 0x44, 0x20, 0x2d, 0x2d, 0x2d}, CAData:[]uint8(nil), NextProtos:[]string{"h2", "http/1.1"}}, UserAgent:"gobot", DisableCompression:false, Transport:(*rest.fakeRoundTripper)(%p), WrapTransport:(transport.WrapperFunc)(%p), QPS:1, Burst:2, RateLimiter:(*rest.fakeLimiter)(%p), WarningHandler:rest.fakeWarningHandler{}, Timeout:3000000000, Dial:(func(context.Context, string, string) (net.Conn, error))(%p), Proxy:(func(*http.Request) (*url.URL, error))(%p)}`,
+		`&rest.Config{Host:"localhost:8080", FailoverHosts:[]string(nil), APIPath:"v1", ContentConfig:rest.ContentConfig{AcceptContentTypes:"application/json", ContentType:"application/json", GroupVersion:(*schema.GroupVersion)(nil), NegotiatedSerializer:runtime.NegotiatedSerializer(nil)}, Username:"gopher", Password:"--- REDACTED ---", BearerToken:"--- REDACTED ---", BearerTokenFile:"", Impersonate:rest.ImpersonationConfig{UserName:"gopher2", UID:"", Groups:[]string(nil), Extra:map[string][]string(nil)}, AuthProvider:api.AuthProviderConfig{Name: "gopher", Config: map[string]string{--- REDACTED ---}}, AuthConfigPersister:rest.AuthProviderConfigPersister(--- REDACTED ---), ExecProvider:api.ExecConfig{Command: "sudo", Args: []string{"--- REDACTED ---"}, Env: []ExecEnvVar{--- REDACTED ---}, APIVersion: "", ProvideClusterInfo: true, Config: runtime.Object(--- REDACTED ---), StdinUnavailable: false}, TLSClientConfig:rest.sanitizedTLSClientConfig{Insecure:false, ServerName:"", CertFile:"a.crt", KeyFile:"a.key", CAFile:"", CertData:[]uint8{0x2d, 0x2d, 0x2d, 0x20, 0x54, 0x52, 0x55, 0x4e, 0x43, 0x41, 0x54, 0x45, 0x44, 0x20, 0x2d, 0x2d, 0x2d}, KeyData:[]uint8{0x2d, 0x2d, 0x2d, 0x20, 0x52, 0x45, 0x44, 0x41, 0x43, 0x54, 0x45, 0x44, 0x20, 0x2d, 0x2d, 0x2d}, CAData:[]uint8(nil), NextProtos:[]string{"h2", "http/1.1"}, ReloadCAFile:false}, UserAgent:"gobot", UserAgentAttribution:false, DisableCompression:false, RequestCompressionThreshold:0, Transport:(*rest.fakeRoundTripper)(%p), WrapTransport:(transport.WrapperFunc)(%p), QPS:1, Burst:2, RateLimiter:(*rest.fakeLimiter)(%p), RateLimiterPolicy:rest.RateLimiterPolicy(nil), InFlightLimiter:flowcontrol.InFlightLimiter(nil), WarningHandler:rest.fakeWarningHandler{}, Tracer:rest.Tracer(nil), RequestLogger:(*rest.RequestLogger)(nil), RetryPolicy:rest.RetryPolicy(nil), CircuitBreaker:rest.CircuitBreaker(nil), Timeout:3000000000, MaxResponseBytes:0, Dial:(func(context.Context, string, string) (net.Conn, error))(%p), Proxy:(func(*http.Request) (*url.URL, error))(%p), HTTP2ReadIdleTimeout:0, HTTP2PingTimeout:0, CoalesceRequests:false}`,
 		c.Transport, fakeWrapperFunc, c.RateLimiter, fakeDialFunc, fakeProxyFunc,
 	)
 
@@ -243,6 +243,24 @@ func TestConfigToExecClusterRoundtrip(t *testing.T) {
 		func(h *WarningHandler, f fuzz.Continue) {
 			*h = &fakeWarningHandler{}
 		},
+		func(p *RetryPolicy, f fuzz.Continue) {
+			*p = &fakeRetryPolicy{}
+		},
+		func(t *Tracer, f fuzz.Continue) {
+			*t = &fakeTracer{}
+		},
+		func(b *CircuitBreaker, f fuzz.Continue) {
+			*b = &fakeCircuitBreaker{}
+		},
+		func(l *flowcontrol.InFlightLimiter, f fuzz.Continue) {
+			*l = &fakeInFlightLimiter{}
+		},
+		func(l **RequestLogger, f fuzz.Continue) {
+			*l = &RequestLogger{SampleRatio: f.Float64()}
+		},
+		func(p *RateLimiterPolicy, f fuzz.Continue) {
+			*p = RateLimiterRules{{Name: "fake", RateLimiter: &fakeLimiter{}}}
+		},
 		// Authentication does not require fuzzer
 		func(r *AuthProviderConfigPersister, f fuzz.Continue) {},
 		func(r *clientcmdapi.AuthProviderConfig, f fuzz.Continue) {
@@ -266,6 +284,7 @@ func TestConfigToExecClusterRoundtrip(t *testing.T) {
 
 		// This is the list of known fields that this roundtrip doesn't care about. We should add new
 		// fields to this list if we don't want to roundtrip them on exec cluster conversion.
+		expected.FailoverHosts = nil
 		expected.APIPath = ""
 		expected.ContentConfig = ContentConfig{}
 		expected.Username = ""
@@ -282,16 +301,29 @@ func TestConfigToExecClusterRoundtrip(t *testing.T) {
 		expected.TLSClientConfig.CertData = nil
 		expected.TLSClientConfig.KeyData = nil
 		expected.TLSClientConfig.NextProtos = nil
+		expected.TLSClientConfig.ReloadCAFile = false
 		expected.UserAgent = ""
+		expected.UserAgentAttribution = false
 		expected.DisableCompression = false
+		expected.RequestCompressionThreshold = 0
 		expected.Transport = nil
 		expected.WrapTransport = nil
 		expected.QPS = 0.0
 		expected.Burst = 0
 		expected.RateLimiter = nil
+		expected.RateLimiterPolicy = nil
+		expected.InFlightLimiter = nil
 		expected.WarningHandler = nil
+		expected.RetryPolicy = nil
+		expected.CircuitBreaker = nil
+		expected.Tracer = nil
+		expected.RequestLogger = nil
 		expected.Timeout = 0
+		expected.MaxResponseBytes = 0
 		expected.Dial = nil
+		expected.HTTP2ReadIdleTimeout = 0
+		expected.HTTP2PingTimeout = 0
+		expected.CoalesceRequests = false
 
 		// Manually set URLs so we don't get an error when parsing these during the roundtrip.
 		if expected.Host != "" {
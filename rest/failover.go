@@ -0,0 +1,261 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// failoverInitialBackoff and failoverMaxBackoff bound the time an
+	// unreachable endpoint is avoided for.
+	failoverInitialBackoff = 1 * time.Second
+	failoverMaxBackoff     = 1 * time.Minute
+)
+
+// failoverRoundTripper sends the requests for Host of a Config to the first
+// endpoint of Host and FailoverHosts that can be reached. It sticks to an
+// endpoint as long as it is healthy, and avoids endpoints that could not be
+// reached for a growing backoff period, tracked per endpoint host.
+type failoverRoundTripper struct {
+	// endpoints are Host followed by FailoverHosts.
+	endpoints []*url.URL
+	backoff   *URLBackoff
+	delegate  http.RoundTripper
+
+	lock sync.Mutex
+	// current is the index of the endpoint requests are sent to first.
+	current int
+}
+
+var _ utilnet.RoundTripperWrapper = &failoverRoundTripper{}
+
+// newFailoverRoundTripper returns rt wrapped so that it fails over between
+// the Host and FailoverHosts of config.
+func newFailoverRoundTripper(config *Config, rt http.RoundTripper) (http.RoundTripper, error) {
+	hosts := append([]string{config.Host}, config.FailoverHosts...)
+	endpoints := make([]*url.URL, 0, len(hosts))
+	for _, host := range hosts {
+		endpointConfig := *config
+		endpointConfig.Host = host
+		endpoint, _, err := defaultServerUrlFor(&endpointConfig)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return &failoverRoundTripper{
+		endpoints: endpoints,
+		backoff:   &URLBackoff{Backoff: flowcontrol.NewBackOff(failoverInitialBackoff, failoverMaxBackoff)},
+		delegate:  rt,
+	}, nil
+}
+
+func (rt *failoverRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	primary := rt.endpoints[0]
+	if req.URL.Scheme != primary.Scheme || req.URL.Host != primary.Host {
+		// not a request for the cluster, like a redirect or an absolute URL.
+		return rt.delegate.RoundTrip(req)
+	}
+
+	var lastErr error
+	for attempt, i := range rt.order() {
+		endpointReq := req
+		if attempt > 0 {
+			var err error
+			if endpointReq, err = rewindRequest(req); err != nil {
+				return nil, lastErr
+			}
+		}
+		endpointReq = rt.requestFor(endpointReq, rt.endpoints[i])
+
+		resp, err := rt.delegate.RoundTrip(endpointReq)
+		if err == nil {
+			rt.succeeded(i)
+			if isWatchRequest(req) {
+				resp.Body = &failoverWatchBody{ReadCloser: resp.Body, rt: rt, endpoint: i}
+			}
+			return resp, nil
+		}
+		if !isFailoverError(req, err) {
+			return nil, err
+		}
+		rt.failed(i, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (rt *failoverRoundTripper) WrappedRoundTripper() http.RoundTripper { return rt.delegate }
+
+// order returns the indexes of the endpoints in the order they are tried: the
+// current endpoint and the other healthy ones in configured order, followed
+// by the endpoints that are backing off, which are tried as a last resort.
+func (rt *failoverRoundTripper) order() []int {
+	rt.lock.Lock()
+	current := rt.current
+	rt.lock.Unlock()
+
+	candidates := make([]int, 0, len(rt.endpoints))
+	var backingOff []int
+	for _, i := range append([]int{current}, rt.others(current)...) {
+		if rt.isBackingOff(i) {
+			backingOff = append(backingOff, i)
+		} else {
+			candidates = append(candidates, i)
+		}
+	}
+	return append(candidates, backingOff...)
+}
+
+// others returns the indexes of all endpoints except skip, in configured
+// order.
+func (rt *failoverRoundTripper) others(skip int) []int {
+	others := make([]int, 0, len(rt.endpoints)-1)
+	for i := range rt.endpoints {
+		if i != skip {
+			others = append(others, i)
+		}
+	}
+	return others
+}
+
+func (rt *failoverRoundTripper) isBackingOff(i int) bool {
+	b := rt.backoff.Backoff
+	return b.IsInBackOffSinceUpdate(rt.backoff.baseUrlKey(rt.endpoints[i]), b.Clock.Now())
+}
+
+// succeeded makes endpoint i the current endpoint and clears its backoff.
+func (rt *failoverRoundTripper) succeeded(i int) {
+	rt.backoff.Backoff.Reset(rt.backoff.baseUrlKey(rt.endpoints[i]))
+
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	if rt.current != i {
+		klog.V(2).Infof("Switching to API server endpoint %s", rt.endpoints[i].Host)
+		rt.current = i
+	}
+}
+
+// failed backs off from endpoint i, so that the next requests are sent to
+// another endpoint.
+func (rt *failoverRoundTripper) failed(i int, err error) {
+	b := rt.backoff.Backoff
+	key := rt.backoff.baseUrlKey(rt.endpoints[i])
+	b.Next(key, b.Clock.Now())
+	klog.V(2).Infof("API server endpoint %s is unreachable, avoiding it for %v: %v", rt.endpoints[i].Host, b.Get(key), err)
+}
+
+// requestFor returns req sent to endpoint instead of the primary endpoint.
+func (rt *failoverRoundTripper) requestFor(req *http.Request, endpoint *url.URL) *http.Request {
+	primary := rt.endpoints[0]
+	if endpoint == primary {
+		return req
+	}
+	req = req.Clone(req.Context())
+	req.URL.Scheme = endpoint.Scheme
+	req.URL.Host = endpoint.Host
+	// the path of Host is a prefix that may differ between endpoints.
+	req.URL.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(primary.Path, "/")), "/")
+	req.URL.RawPath = ""
+	if req.Host == primary.Host {
+		req.Host = endpoint.Host
+	}
+	return req
+}
+
+// rewindRequest returns a copy of req with a fresh body, so that it can be
+// sent to another endpoint.
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("request body cannot be replayed")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Body = body
+	return req, nil
+}
+
+// isFailoverError returns whether err shows that the endpoint req was sent to
+// cannot be reached. Requests that may have reached the server are only
+// failed over if they are safe to repeat.
+func isFailoverError(req *http.Request, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var opErr *net.OpError
+	if utilnet.IsConnectionRefused(err) || (errors.As(err, &opErr) && opErr.Op == "dial") {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err)
+	}
+	return false
+}
+
+func isWatchRequest(req *http.Request) bool {
+	switch req.URL.Query().Get("watch") {
+	case "true", "1":
+		return true
+	}
+	return false
+}
+
+// failoverWatchBody backs off from the endpoint of a watch when its stream
+// breaks, so that the watch is re-established on another endpoint.
+type failoverWatchBody struct {
+	io.ReadCloser
+	rt       *failoverRoundTripper
+	endpoint int
+	// closed is set once the client closed the body, after which read errors
+	// are expected.
+	closed int32
+}
+
+func (b *failoverWatchBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	// io.EOF is the server ending the watch, anything else a broken stream.
+	if err != nil && err != io.EOF && atomic.LoadInt32(&b.closed) == 0 && (utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err)) {
+		b.rt.failed(b.endpoint, err)
+	}
+	return n, err
+}
+
+func (b *failoverWatchBody) Close() error {
+	atomic.StoreInt32(&b.closed, 1)
+	return b.ReadCloser.Close()
+}
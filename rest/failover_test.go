@@ -0,0 +1,225 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// unreachableHost returns the address of a port nothing listens on.
+func unreachableHost(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := "http://" + l.Addr().String()
+	l.Close()
+	return host
+}
+
+type countingServer struct {
+	*httptest.Server
+	requests int32
+}
+
+func newCountingServer(handler http.HandlerFunc) *countingServer {
+	s := &countingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.requests, 1)
+		handler(w, r)
+	}))
+	return s
+}
+
+func (s *countingServer) count() int32 { return atomic.LoadInt32(&s.requests) }
+
+func newFailoverTestClient(t *testing.T, host string, failoverHosts ...string) (*RESTClient, *failoverRoundTripper, *clock.FakeClock) {
+	client, err := UnversionedRESTClientFor(&Config{
+		Host:          host,
+		FailoverHosts: failoverHosts,
+		ContentConfig: ContentConfig{NegotiatedSerializer: scheme.Codecs.WithoutConversion()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, ok := client.Client.Transport.(*failoverRoundTripper)
+	if !ok {
+		t.Fatalf("expected a failover round tripper, got %T", client.Client.Transport)
+	}
+	fakeClock := clock.NewFakeClock(time.Now())
+	rt.backoff = &URLBackoff{Backoff: flowcontrol.NewFakeBackOff(failoverInitialBackoff, failoverMaxBackoff, fakeClock)}
+	return client, rt, fakeClock
+}
+
+func TestFailover(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	secondary := newCountingServer(ok)
+	defer secondary.Close()
+	third := newCountingServer(ok)
+	defer third.Close()
+	primary := unreachableHost(t)
+
+	client, rt, fakeClock := newFailoverTestClient(t, primary, secondary.URL, third.URL)
+
+	for i := 0; i < 3; i++ {
+		if err := client.Get().AbsPath("/api").Do(context.Background()).Error(); err != nil {
+			t.Fatalf("request %d: unexpected error: %v", i, err)
+		}
+	}
+	if secondary.count() != 3 || third.count() != 0 {
+		t.Fatalf("expected all requests to fail over to the first reachable endpoint, got %d and %d", secondary.count(), third.count())
+	}
+	if !rt.isBackingOff(0) {
+		t.Errorf("expected the unreachable endpoint to back off")
+	}
+
+	// the client sticks to the healthy endpoint after the backoff passed.
+	fakeClock.Step(failoverMaxBackoff)
+	if err := client.Get().AbsPath("/api").Do(context.Background()).Error(); err != nil {
+		t.Fatal(err)
+	}
+	if secondary.count() != 4 {
+		t.Errorf("expected the client to stick to the healthy endpoint, got %d requests", secondary.count())
+	}
+
+	// it fails over again when the endpoint goes away.
+	secondary.Close()
+	if err := client.Get().AbsPath("/api").Do(context.Background()).Error(); err != nil {
+		t.Fatal(err)
+	}
+	if third.count() != 1 {
+		t.Errorf("expected the request to fail over to the third endpoint, got %d requests", third.count())
+	}
+}
+
+func TestFailoverAllUnreachable(t *testing.T) {
+	client, _, _ := newFailoverTestClient(t, unreachableHost(t), unreachableHost(t))
+	err := client.Get().AbsPath("/api").Do(context.Background()).Error()
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expected connection refused, got %v", err)
+	}
+}
+
+func TestFailoverWatch(t *testing.T) {
+	// the primary endpoint breaks the stream of the first watch.
+	primary := newCountingServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	})
+	defer primary.Close()
+	secondary := newCountingServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+	})
+	defer secondary.Close()
+
+	client, rt, _ := newFailoverTestClient(t, primary.URL, secondary.URL)
+
+	stream, err := client.Get().AbsPath("/api/v1/pods").Param("watch", "true").Stream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(stream); err == nil {
+		t.Fatalf("expected the watch stream to break")
+	}
+	stream.Close()
+	if !rt.isBackingOff(0) {
+		t.Fatalf("expected the endpoint of the broken watch to back off")
+	}
+
+	stream, err = client.Get().AbsPath("/api/v1/pods").Param("watch", "true").Stream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(stream); err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	if primary.count() != 1 || secondary.count() != 1 {
+		t.Errorf("expected the watch to be re-established on the other endpoint, got %d and %d requests", primary.count(), secondary.count())
+	}
+}
+
+func TestFailoverRequestFor(t *testing.T) {
+	rt, err := newFailoverRoundTripper(&Config{
+		Host:          "https://primary/prefix",
+		FailoverHosts: []string{"https://secondary:6443", "https://third/other/"},
+	}, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frt := rt.(*failoverRoundTripper)
+
+	req, _ := http.NewRequest("GET", "https://primary/prefix/api/v1/pods?watch=true", nil)
+	for i, expected := range []string{
+		"https://primary/prefix/api/v1/pods?watch=true",
+		"https://secondary:6443/api/v1/pods?watch=true",
+		"https://third/other/api/v1/pods?watch=true",
+	} {
+		if got := frt.requestFor(req, frt.endpoints[i]).URL.String(); got != expected {
+			t.Errorf("endpoint %d: expected %s, got %s", i, expected, got)
+		}
+	}
+	if req.URL.String() != "https://primary/prefix/api/v1/pods?watch=true" {
+		t.Errorf("the original request was modified: %s", req.URL)
+	}
+}
+
+func TestIsFailoverError(t *testing.T) {
+	reset := &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+	refused := &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}
+	testCases := []struct {
+		method   string
+		err      error
+		expected bool
+	}{
+		{method: "GET", err: refused, expected: true},
+		{method: "POST", err: refused, expected: true},
+		{method: "POST", err: &net.OpError{Op: "dial", Err: errors.New("no route to host")}, expected: true},
+		{method: "GET", err: reset, expected: true},
+		{method: "POST", err: reset, expected: false},
+		{method: "GET", err: context.Canceled, expected: false},
+		{method: "GET", err: errors.New("x509: certificate signed by unknown authority"), expected: false},
+	}
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, "http://localhost", nil)
+		if got := isFailoverError(req, tc.err); got != tc.expected {
+			t.Errorf("%s %v: expected %v, got %v", tc.method, tc.err, tc.expected, got)
+		}
+	}
+}
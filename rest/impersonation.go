@@ -0,0 +1,60 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"net/http"
+
+	"k8s.io/client-go/transport"
+)
+
+// WithImpersonation returns a copy of ctx that makes the requests sent with it
+// impersonate the user of impersonate. This allows a single client to act on
+// behalf of many users. Requests of clients whose config impersonates a
+// different user fail.
+func WithImpersonation(ctx context.Context, impersonate ImpersonationConfig) context.Context {
+	return transport.WithImpersonation(ctx, transportImpersonation(impersonate))
+}
+
+func transportImpersonation(impersonate ImpersonationConfig) transport.ImpersonationConfig {
+	return transport.ImpersonationConfig(impersonate)
+}
+
+// impersonate sets the impersonation headers of req from the impersonation of
+// its context, if any.
+func (r *Request) impersonate(req *http.Request) error {
+	impersonate, ok := transport.ImpersonationFrom(req.Context())
+	if !ok {
+		return nil
+	}
+	if err := transport.ValidateImpersonation(r.c.impersonate, impersonate); err != nil {
+		return err
+	}
+	// impersonation headers set on the Request take precedence.
+	if len(req.Header.Get(transport.ImpersonateUserHeader)) > 0 {
+		return nil
+	}
+	// the headers are shared with the Request, so copy them.
+	header := req.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	transport.SetImpersonationHeaders(header, impersonate)
+	req.Header = header
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/transport"
+)
+
+func TestImpersonationFromContext(t *testing.T) {
+	var lock sync.Mutex
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		header := http.Header{}
+		for _, name := range []string{transport.ImpersonateUserHeader, transport.ImpersonateUIDHeader, transport.ImpersonateGroupHeader, transport.ImpersonateUserExtraHeaderPrefix + "Scope"} {
+			if values := r.Header.Values(name); len(values) > 0 {
+				header[name] = values
+			}
+		}
+		headers = append(headers, header)
+	}))
+	defer server.Close()
+
+	newClient := func(impersonate ImpersonationConfig) *RESTClient {
+		client, err := RESTClientFor(&Config{
+			Host:        server.URL,
+			APIPath:     "/api",
+			Impersonate: impersonate,
+			ContentConfig: ContentConfig{
+				GroupVersion:         &v1.SchemeGroupVersion,
+				NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	gateway := ImpersonationConfig{UserName: "gateway"}
+	user := ImpersonationConfig{UserName: "user", UID: "1234", Groups: []string{"one", "two"}, Extra: map[string][]string{"scope": {"a"}}}
+
+	client := newClient(ImpersonationConfig{})
+	if err := client.Get().Resource("pods").Do(WithImpersonation(context.Background(), user)).Error(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := client.Get().Resource("pods").Do(context.Background()).Error(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, err := client.Get().Resource("pods").Stream(WithImpersonation(context.Background(), gateway))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body.Close()
+
+	// the impersonation of the config can be repeated, but not replaced.
+	impersonatingClient := newClient(gateway)
+	if err := impersonatingClient.Get().Resource("pods").Do(WithImpersonation(context.Background(), gateway)).Error(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := impersonatingClient.Get().Resource("pods").Do(WithImpersonation(context.Background(), user)).Error(); err == nil {
+		t.Fatal("expected conflicting impersonation to fail")
+	}
+	if err := client.Get().Resource("pods").Do(WithImpersonation(context.Background(), ImpersonationConfig{Groups: []string{"one"}})).Error(); err == nil {
+		t.Fatal("expected impersonation without a user name to fail")
+	}
+
+	expected := []http.Header{
+		{
+			transport.ImpersonateUserHeader:                      {"user"},
+			transport.ImpersonateUIDHeader:                       {"1234"},
+			transport.ImpersonateGroupHeader:                     {"one", "two"},
+			transport.ImpersonateUserExtraHeaderPrefix + "Scope": {"a"},
+		},
+		{},
+		{transport.ImpersonateUserHeader: {"gateway"}},
+		{transport.ImpersonateUserHeader: {"gateway"}},
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if !reflect.DeepEqual(expected, headers) {
+		t.Errorf("expected headers %v, got %v", expected, headers)
+	}
+}
@@ -0,0 +1,347 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// protobufPrefix is the magic number that precedes a protobuf encoded object.
+var protobufPrefix = []byte{0x6b, 0x38, 0x73, 0x00}
+
+// defaultMaxListItemBytes is the size of the largest protobuf encoded item
+// StreamList decodes, unless the request has a maximum response size.
+const defaultMaxListItemBytes = 64 << 20
+
+// ListItemFunc is called by StreamList with every item of a list.
+type ListItemFunc func(obj runtime.Object) error
+
+// StreamList executes a list request and decodes the items of the returned
+// list one at a time, calling fn with each of them. Unlike Do, the list is
+// never held in memory as a whole, and the maximum response size of the
+// request only limits the size of every protobuf encoded item, to 64MiB if it
+// is not set. JSON and protobuf responses are supported. Iteration
+// stops at the first error returned by fn, which is then returned. The
+// metadata of the list, like its resource version and continue token, is
+// returned once all items have been passed to fn.
+func (r *Request) StreamList(ctx context.Context, fn ListItemFunc) (metav1.ListMeta, error) {
+	var listMeta metav1.ListMeta
+	var streamErr error
+	err := r.request(ctx, func(req *http.Request, resp *http.Response) {
+		if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusPartialContent {
+			streamErr = r.transformResponse(resp, req).Error()
+			return
+		}
+		handleWarnings(resp.Header, r.warningHandler)
+
+		contentType := resp.Header.Get("Content-Type")
+		if len(contentType) == 0 {
+			contentType = r.c.content.ContentType
+		}
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			streamErr = errors.NewInternalError(err)
+			return
+		}
+		decoder, err := r.c.content.Negotiator.Decoder(mediaType, params)
+		if err != nil {
+			streamErr = fmt.Errorf("unable to decode a list of content type %q: %v", contentType, err)
+			return
+		}
+		switch mediaType {
+		case runtime.ContentTypeJSON:
+			streamErr = streamJSONList(resp.Body, decoder, &listMeta, fn)
+		case runtime.ContentTypeProtobuf:
+			maxItemBytes := r.maxResponseBytes
+			if maxItemBytes <= 0 {
+				maxItemBytes = defaultMaxListItemBytes
+			}
+			streamErr = streamProtobufList(resp.Body, decoder, maxItemBytes, &listMeta, fn)
+		default:
+			streamErr = fmt.Errorf("unable to stream a list of content type %q", contentType)
+		}
+	})
+	if err != nil {
+		return metav1.ListMeta{}, err
+	}
+	return listMeta, streamErr
+}
+
+// itemGroupVersionKind returns the kind of the items of a list of the given
+// kind, which is used for items that do not carry their own.
+func itemGroupVersionKind(apiVersion, listKind string) *schema.GroupVersionKind {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil || !strings.HasSuffix(listKind, "List") {
+		return nil
+	}
+	gvk := gv.WithKind(strings.TrimSuffix(listKind, "List"))
+	return &gvk
+}
+
+// streamJSONList decodes the JSON encoded list read from body.
+func streamJSONList(body io.Reader, decoder runtime.Decoder, listMeta *metav1.ListMeta, fn ListItemFunc) error {
+	d := json.NewDecoder(body)
+	if err := expectJSONDelim(d, '{'); err != nil {
+		return err
+	}
+	var apiVersion, kind string
+	for d.More() {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case "apiVersion":
+			err = d.Decode(&apiVersion)
+		case "kind":
+			err = d.Decode(&kind)
+		case "metadata":
+			err = d.Decode(listMeta)
+		case "items":
+			err = streamJSONItems(d, decoder, itemGroupVersionKind(apiVersion, kind), fn)
+		default:
+			var skipped json.RawMessage
+			err = d.Decode(&skipped)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return expectJSONDelim(d, '}')
+}
+
+func streamJSONItems(d *json.Decoder, decoder runtime.Decoder, gvk *schema.GroupVersionKind, fn ListItemFunc) error {
+	token, err := d.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		// the list has no items.
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected the items of the list to be an array, got %v", token)
+	}
+	for d.More() {
+		var item json.RawMessage
+		if err := d.Decode(&item); err != nil {
+			return err
+		}
+		obj, _, err := decoder.Decode(item, gvk, nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return expectJSONDelim(d, ']')
+}
+
+func expectJSONDelim(d *json.Decoder, expected json.Delim) error {
+	token, err := d.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != expected {
+		return fmt.Errorf("expected %v in the list, got %v", expected, token)
+	}
+	return nil
+}
+
+// streamProtobufList decodes the protobuf encoded list read from body. The
+// list is wrapped in a runtime.Unknown, whose raw field holds a message with
+// the list metadata in field 1 and the items in field 2, as all generated
+// lists do. Each item is wrapped in a runtime.Unknown of its own so that the
+// protobuf decoder can decode it. Fields larger than maxItemBytes are
+// rejected before they are read into memory.
+func streamProtobufList(body io.Reader, decoder runtime.Decoder, maxItemBytes int64, listMeta *metav1.ListMeta, fn ListItemFunc) error {
+	r := bufio.NewReader(body)
+	prefix := make([]byte, len(protobufPrefix))
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return err
+	}
+	if !bytes.Equal(prefix, protobufPrefix) {
+		return fmt.Errorf("the list does not start with the protobuf prefix")
+	}
+
+	var typeMeta runtime.TypeMeta
+	for {
+		field, wireType, err := readProtobufTag(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wireType == 2:
+			data, err := readProtobufBytes(r, maxItemBytes)
+			if err != nil {
+				return err
+			}
+			if err := typeMeta.Unmarshal(data); err != nil {
+				return err
+			}
+		case field == 2 && wireType == 2:
+			length, err := binary.ReadUvarint(r)
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			if length > math.MaxInt64 {
+				return fmt.Errorf("invalid protobuf field length %d in the list", length)
+			}
+			list := &io.LimitedReader{R: r, N: int64(length)}
+			if err := streamProtobufItems(list, decoder, maxItemBytes, typeMeta, listMeta, fn); err != nil {
+				return err
+			}
+		default:
+			if err := skipProtobufField(r, wireType); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// streamProtobufItems decodes the message of list, whose fields can not be
+// larger than what is left of it.
+func streamProtobufItems(list *io.LimitedReader, decoder runtime.Decoder, maxItemBytes int64, typeMeta runtime.TypeMeta, listMeta *metav1.ListMeta, fn ListItemFunc) error {
+	r := bufio.NewReader(list)
+	itemKind := strings.TrimSuffix(typeMeta.Kind, "List")
+	for {
+		field, wireType, err := readProtobufTag(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		maxBytes := maxItemBytes
+		if remaining := list.N + int64(r.Buffered()); remaining < maxBytes {
+			maxBytes = remaining
+		}
+		switch {
+		case field == 1 && wireType == 2:
+			data, err := readProtobufBytes(r, maxBytes)
+			if err != nil {
+				return err
+			}
+			if err := listMeta.Unmarshal(data); err != nil {
+				return err
+			}
+		case field == 2 && wireType == 2:
+			data, err := readProtobufBytes(r, maxBytes)
+			if err != nil {
+				return err
+			}
+			item := runtime.Unknown{
+				TypeMeta: runtime.TypeMeta{APIVersion: typeMeta.APIVersion, Kind: itemKind},
+				Raw:      data,
+			}
+			wrapped, err := item.Marshal()
+			if err != nil {
+				return err
+			}
+			obj, _, err := decoder.Decode(append(append([]byte{}, protobufPrefix...), wrapped...), nil, nil)
+			if err != nil {
+				return err
+			}
+			if err := fn(obj); err != nil {
+				return err
+			}
+		default:
+			if err := skipProtobufField(r, wireType); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readProtobufTag reads the field number and wire type of the next field. It
+// returns io.EOF if there are no more fields.
+func readProtobufTag(r *bufio.Reader) (int, int, error) {
+	tag, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(tag >> 3), int(tag & 0x7), nil
+}
+
+// readProtobufBytes reads a length-delimited field, which must not be larger
+// than maxBytes.
+func readProtobufBytes(r *bufio.Reader, maxBytes int64) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if length > uint64(maxBytes) {
+		return nil, fmt.Errorf("protobuf field of %d bytes in the list exceeds the limit of %d bytes", length, maxBytes)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return data, nil
+}
+
+func skipProtobufField(r *bufio.Reader, wireType int) error {
+	var err error
+	switch wireType {
+	case 0:
+		_, err = binary.ReadUvarint(r)
+	case 1:
+		_, err = io.CopyN(ioutil.Discard, r, 8)
+	case 2:
+		var length uint64
+		if length, err = binary.ReadUvarint(r); err == nil {
+			if length > math.MaxInt64 {
+				return fmt.Errorf("invalid protobuf field length %d in the list", length)
+			}
+			_, err = io.CopyN(ioutil.Discard, r, int64(length))
+		}
+	case 5:
+		_, err = io.CopyN(ioutil.Discard, r, 4)
+	default:
+		return fmt.Errorf("unsupported protobuf wire type %d in the list", wireType)
+	}
+	return unexpectedEOF(err)
+}
+
+// unexpectedEOF turns io.EOF in the middle of a field into
+// io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func testPodList(items int) *v1.PodList {
+	list := &v1.PodList{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "PodList"},
+		ListMeta: metav1.ListMeta{ResourceVersion: "42", Continue: "next"},
+	}
+	for i := 0; i < items; i++ {
+		pod := v1.Pod{}
+		pod.Name = fmt.Sprintf("pod-%d", i)
+		pod.Namespace = "default"
+		pod.Spec.NodeName = "node"
+		list.Items = append(list.Items, pod)
+	}
+	return list
+}
+
+func encodeTestPodList(t *testing.T, mediaType string, list *v1.PodList) []byte {
+	info, ok := runtime.SerializerInfoForMediaType(scheme.Codecs.SupportedMediaTypes(), mediaType)
+	if !ok {
+		t.Fatalf("no serializer for %s", mediaType)
+	}
+	data, err := runtime.Encode(scheme.Codecs.EncoderForVersion(info.Serializer, v1.SchemeGroupVersion), list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestStreamList(t *testing.T) {
+	list := testPodList(3)
+	for _, mediaType := range []string{runtime.ContentTypeJSON, runtime.ContentTypeProtobuf} {
+		t.Run(mediaType, func(t *testing.T) {
+			body := encodeTestPodList(t, mediaType, list)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", mediaType)
+				w.Write(body)
+			}))
+			defer server.Close()
+
+			var names []string
+			listMeta, err := testRESTClient(t, server).Get().Resource("pods").StreamList(context.Background(), func(obj runtime.Object) error {
+				pod, ok := obj.(*v1.Pod)
+				if !ok {
+					return fmt.Errorf("unexpected object %T", obj)
+				}
+				if pod.Namespace != "default" || pod.Spec.NodeName != "node" {
+					return fmt.Errorf("unexpected pod %#v", pod)
+				}
+				names = append(names, pod.Name)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if listMeta.ResourceVersion != "42" || listMeta.Continue != "next" {
+				t.Errorf("unexpected list metadata %#v", listMeta)
+			}
+			if strings.Join(names, ",") != "pod-0,pod-1,pod-2" {
+				t.Errorf("unexpected items %v", names)
+			}
+		})
+	}
+}
+
+func TestStreamListErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/forbidden" {
+			w.Header().Set("Content-Type", runtime.ContentTypeJSON)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Forbidden","code":403}`))
+			return
+		}
+		w.Header().Set("Content-Type", runtime.ContentTypeJSON)
+		w.Write(encodeTestPodList(t, runtime.ContentTypeJSON, testPodList(3)))
+	}))
+	defer server.Close()
+	c := testRESTClient(t, server)
+
+	_, err := c.Get().AbsPath("/forbidden").StreamList(context.Background(), func(runtime.Object) error { return nil })
+	if !apierrors.IsForbidden(err) {
+		t.Errorf("expected a forbidden error, got %v", err)
+	}
+
+	stop := errors.New("stop")
+	items := 0
+	_, err = c.Get().AbsPath("/pods").StreamList(context.Background(), func(runtime.Object) error {
+		items++
+		return stop
+	})
+	if err != stop || items != 1 {
+		t.Errorf("expected iteration to stop after the first item, got %v after %d items", err, items)
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	body := encodeTestPodList(t, runtime.ContentTypeJSON, testPodList(10))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", runtime.ContentTypeJSON)
+		if r.URL.Path == "/chunked" {
+			// flushing before the body is written prevents the server from
+			// setting the content length.
+			w.(http.Flusher).Flush()
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+	c := testRESTClient(t, server)
+
+	testCases := []struct {
+		path  string
+		limit int64
+		size  int64
+	}{
+		{path: "/length", limit: 100, size: int64(len(body))},
+		{path: "/chunked", limit: 100, size: -1},
+		{path: "/length", limit: int64(len(body))},
+		{path: "/chunked", limit: int64(len(body))},
+		{path: "/chunked"},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %d", tc.path, tc.limit), func(t *testing.T) {
+			err := c.Get().AbsPath(tc.path).MaxResponseBytes(tc.limit).Do(context.Background()).Error()
+			_, rawErr := c.Get().AbsPath(tc.path).MaxResponseBytes(tc.limit).DoRaw(context.Background())
+			for _, err := range []error{err, rawErr} {
+				if tc.size == 0 {
+					if err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+					continue
+				}
+				tooLarge, ok := err.(*ResponseTooLargeError)
+				if !ok {
+					t.Fatalf("expected a *ResponseTooLargeError, got %v", err)
+				}
+				if tooLarge.Limit != tc.limit || tooLarge.Size != tc.size {
+					t.Errorf("unexpected error %#v", tooLarge)
+				}
+			}
+		})
+	}
+
+	// streamed lists are not limited.
+	items := 0
+	_, err := c.Get().AbsPath("/chunked").MaxResponseBytes(100).StreamList(context.Background(), func(runtime.Object) error {
+		items++
+		return nil
+	})
+	if err != nil || items != 10 {
+		t.Errorf("expected 10 items, got %d: %v", items, err)
+	}
+}
+
+func TestStreamListProtobufFieldTooLarge(t *testing.T) {
+	prefix := string(protobufPrefix)
+	testCases := map[string]struct {
+		body             string
+		maxResponseBytes int64
+	}{
+		"list metadata larger than the default limit": {
+			body: prefix + "\x0a\x80\x80\x80\x80\x80\x80\x80\x80\x40",
+		},
+		"item larger than the list": {
+			// the list is 3 bytes long, its item announces 100.
+			body: prefix + "\x12\x03\x12\x64\x00",
+		},
+		"item larger than the maximum response size": {
+			body:             prefix + "\x12\xc8\x01\x12\x64",
+			maxResponseBytes: 50,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", runtime.ContentTypeProtobuf)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			_, err := testRESTClient(t, server).Get().Resource("pods").MaxResponseBytes(tc.maxResponseBytes).StreamList(context.Background(), func(runtime.Object) error {
+				t.Errorf("unexpected item")
+				return nil
+			})
+			if err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+				t.Errorf("expected the field to be rejected, got %v", err)
+			}
+		})
+	}
+}
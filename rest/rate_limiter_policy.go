@@ -0,0 +1,128 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// RateLimiterAttributes are the attributes of a request that a
+// RateLimiterPolicy selects a rate limiter by.
+type RateLimiterAttributes struct {
+	// Verb is the HTTP verb of the request.
+	Verb string
+	// Resource is the resource the request is for, if any.
+	Resource string
+	// Watch is set if the request is a watch.
+	Watch bool
+}
+
+// IsMutation returns whether the request may modify resources.
+func (a RateLimiterAttributes) IsMutation() bool {
+	switch a.Verb {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// RateLimiterPolicy selects the rate limiter that throttles a request.
+type RateLimiterPolicy interface {
+	// RateLimiterFor returns the rate limiter for a request with the given
+	// attributes, and the name of its bucket for logging. If it returns a
+	// nil rate limiter, the rate limiter of the client is used, and watches
+	// are not throttled.
+	RateLimiterFor(attributes RateLimiterAttributes) (name string, limiter flowcontrol.RateLimiter)
+}
+
+// RequestClass is a coarse class of requests a RateLimiterRule applies to.
+type RequestClass int
+
+const (
+	// AnyRequest matches every request.
+	AnyRequest RequestClass = iota
+	// ReadRequest matches requests that are neither watches nor mutations.
+	ReadRequest
+	// WatchRequest matches watches.
+	WatchRequest
+	// MutatingRequest matches POST, PUT, PATCH and DELETE requests.
+	MutatingRequest
+)
+
+// RateLimiterRule assigns a rate limiter to the requests it matches.
+type RateLimiterRule struct {
+	// Name identifies the bucket in logs.
+	Name string
+	// Class is the class of requests matched by the rule.
+	Class RequestClass
+	// Verbs are the HTTP verbs matched by the rule. Empty matches any verb.
+	Verbs []string
+	// Resources are the resources matched by the rule. Empty matches any
+	// resource.
+	Resources []string
+	// RateLimiter throttles the requests matched by the rule.
+	RateLimiter flowcontrol.RateLimiter
+}
+
+// RateLimiterRules is a RateLimiterPolicy that uses the rate limiter of the
+// first rule matching a request.
+type RateLimiterRules []RateLimiterRule
+
+var _ RateLimiterPolicy = RateLimiterRules{}
+
+// RateLimiterFor implements RateLimiterPolicy.
+func (rules RateLimiterRules) RateLimiterFor(attributes RateLimiterAttributes) (string, flowcontrol.RateLimiter) {
+	for i := range rules {
+		if rules[i].matches(attributes) {
+			return rules[i].Name, rules[i].RateLimiter
+		}
+	}
+	return "", nil
+}
+
+func (rule *RateLimiterRule) matches(attributes RateLimiterAttributes) bool {
+	switch rule.Class {
+	case ReadRequest:
+		if attributes.Watch || attributes.IsMutation() {
+			return false
+		}
+	case WatchRequest:
+		if !attributes.Watch {
+			return false
+		}
+	case MutatingRequest:
+		if !attributes.IsMutation() {
+			return false
+		}
+	}
+	return matchesAny(rule.Verbs, attributes.Verb) && matchesAny(rule.Resources, attributes.Resource)
+}
+
+// matchesAny returns true if values is empty or contains value.
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
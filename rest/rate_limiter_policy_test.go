@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// countingLimiter counts the requests it throttled.
+type countingLimiter struct {
+	fakeLimiter
+	lock  sync.Mutex
+	count int
+}
+
+func (l *countingLimiter) Wait(ctx context.Context) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.count++
+	return nil
+}
+
+func (l *countingLimiter) waited() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.count
+}
+
+func TestRateLimiterRules(t *testing.T) {
+	rules := RateLimiterRules{
+		{Name: "events", Resources: []string{"events"}},
+		{Name: "watch", Class: WatchRequest},
+		{Name: "mutation", Class: MutatingRequest},
+		{Name: "list", Class: ReadRequest, Verbs: []string{"GET"}},
+	}
+
+	tests := []struct {
+		attributes RateLimiterAttributes
+		expected   string
+	}{
+		{attributes: RateLimiterAttributes{Verb: "POST", Resource: "events"}, expected: "events"},
+		{attributes: RateLimiterAttributes{Verb: "GET", Resource: "pods", Watch: true}, expected: "watch"},
+		{attributes: RateLimiterAttributes{Verb: "PATCH", Resource: "pods"}, expected: "mutation"},
+		{attributes: RateLimiterAttributes{Verb: "DELETE", Resource: "pods"}, expected: "mutation"},
+		{attributes: RateLimiterAttributes{Verb: "GET", Resource: "pods"}, expected: "list"},
+		{attributes: RateLimiterAttributes{Verb: "HEAD", Resource: "pods"}, expected: ""},
+	}
+	for _, test := range tests {
+		if name, _ := rules.RateLimiterFor(test.attributes); name != test.expected {
+			t.Errorf("%#v: expected bucket %q, got %q", test.attributes, test.expected, name)
+		}
+	}
+}
+
+func TestRequestRateLimiterPolicy(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	defaultLimiter := &countingLimiter{}
+	mutationLimiter := &countingLimiter{}
+	watchLimiter := &countingLimiter{}
+	c := testRESTClient(t, testServer)
+	c.rateLimiter = defaultLimiter
+	c.rateLimiterPolicy = RateLimiterRules{
+		{Name: "mutation", Class: MutatingRequest, RateLimiter: mutationLimiter},
+		{Name: "watch", Class: WatchRequest, Resources: []string{"pods"}, RateLimiter: watchLimiter},
+	}
+
+	ctx := context.Background()
+	if err := c.Get().Resource("pods").Do(ctx).Error(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Patch("application/merge-patch+json").Resource("pods").Name("foo").Body([]byte("{}")).Do(ctx).Error(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete().Resource("pods").Name("foo").Do(ctx).Error(); err != nil {
+		t.Fatal(err)
+	}
+	for _, resource := range []string{"pods", "nodes"} {
+		// watches the policy assigns no rate limiter to are not throttled.
+		w, err := c.Get().Resource(resource).Param("watch", "true").Watch(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Stop()
+	}
+	// an explicit rate limiter takes precedence over the policy.
+	if err := c.Post().Resource("pods").Body([]byte("{}")).Throttle(defaultLimiter).Do(ctx).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := defaultLimiter.waited(); n != 2 {
+		t.Errorf("Expected the default limiter to throttle 2 requests, got %d", n)
+	}
+	if n := mutationLimiter.waited(); n != 2 {
+		t.Errorf("Expected the mutation limiter to throttle 2 requests, got %d", n)
+	}
+	if n := watchLimiter.waited(); n != 1 {
+		t.Errorf("Expected the watch limiter to throttle 1 request, got %d", n)
+	}
+}
@@ -80,6 +80,24 @@ func (r *RequestConstructionError) Error() string {
 	return fmt.Sprintf("request construction error: '%v'", r.Err)
 }
 
+// ResponseTooLargeError is returned when a response body is larger than the
+// maximum response size of a request.
+type ResponseTooLargeError struct {
+	// Limit is the maximum response size in bytes.
+	Limit int64
+	// Size is the size of the response body announced by the server, or -1 if
+	// it was unknown.
+	Size int64
+}
+
+// Error returns a textual description of 'r'.
+func (r *ResponseTooLargeError) Error() string {
+	if r.Size >= 0 {
+		return fmt.Sprintf("response body of %d bytes exceeds the limit of %d bytes", r.Size, r.Limit)
+	}
+	return fmt.Sprintf("response body exceeds the limit of %d bytes", r.Limit)
+}
+
 var noBackoff = &NoBackoff{}
 
 // Request allows for building up a request to a server in a chained fashion.
@@ -90,9 +108,19 @@ type Request struct {
 
 	warningHandler WarningHandler
 
-	rateLimiter flowcontrol.RateLimiter
-	backoff     BackoffManager
-	timeout     time.Duration
+	rateLimiter       flowcontrol.RateLimiter
+	rateLimiterPolicy RateLimiterPolicy
+	backoff           BackoffManager
+	timeout           time.Duration
+	maxResponseBytes  int64
+
+	tracer Tracer
+	// span is the span of the current call to Do, Watch or Stream.
+	span *requestSpan
+
+	logger *RequestLogger
+	// log is the record of the current call to Do, Watch or Stream.
+	log *requestLogEntry
 
 	// generic components accessible via method setters
 	verb       string
@@ -137,13 +165,17 @@ func NewRequest(c *RESTClient) *Request {
 	}
 
 	r := &Request{
-		c:              c,
-		rateLimiter:    c.rateLimiter,
-		backoff:        backoff,
-		timeout:        timeout,
-		pathPrefix:     pathPrefix,
-		retry:          &withRetry{maxRetries: 10},
-		warningHandler: c.warningHandler,
+		c:                 c,
+		rateLimiter:       c.rateLimiter,
+		rateLimiterPolicy: c.rateLimiterPolicy,
+		backoff:           withCircuitBreaker(backoff, c.circuitBreaker),
+		timeout:           timeout,
+		maxResponseBytes:  c.maxResponseBytes,
+		pathPrefix:        pathPrefix,
+		retry:             &withRetry{maxRetries: 10, policy: c.retryPolicy},
+		warningHandler:    c.warningHandler,
+		tracer:            c.tracer,
+		logger:            c.requestLogger,
 	}
 
 	switch {
@@ -213,11 +245,10 @@ func (r *Request) Resource(resource string) *Request {
 // or defaults to the stub implementation if nil is provided
 func (r *Request) BackOff(manager BackoffManager) *Request {
 	if manager == nil {
-		r.backoff = &NoBackoff{}
-		return r
+		manager = &NoBackoff{}
 	}
 
-	r.backoff = manager
+	r.backoff = withCircuitBreaker(manager, r.c.circuitBreaker)
 	return r
 }
 
@@ -228,9 +259,11 @@ func (r *Request) WarningHandler(handler WarningHandler) *Request {
 	return r
 }
 
-// Throttle receives a rate-limiter and sets or replaces an existing request limiter
+// Throttle receives a rate-limiter and sets or replaces an existing request limiter.
+// It takes precedence over the RateLimiterPolicy of the client.
 func (r *Request) Throttle(limiter flowcontrol.RateLimiter) *Request {
 	r.rateLimiter = limiter
+	r.rateLimiterPolicy = nil
 	return r
 }
 
@@ -403,6 +436,17 @@ func (r *Request) Timeout(d time.Duration) *Request {
 	return r
 }
 
+// MaxResponseBytes makes the request fail with a *ResponseTooLargeError if the
+// response body is larger than n bytes, instead of reading it into memory.
+// A value of zero means no limit. Streamed responses are not limited.
+func (r *Request) MaxResponseBytes(n int64) *Request {
+	if r.err != nil {
+		return r
+	}
+	r.maxResponseBytes = n
+	return r
+}
+
 // MaxRetries makes the request use the given integer as a ceiling of retrying upon receiving
 // "Retry-After" headers and 429 status-code in the response. The default is 10 unless this
 // function is specifically called with a different value.
@@ -412,6 +456,17 @@ func (r *Request) MaxRetries(maxRetries int) *Request {
 	return r
 }
 
+// RetryPolicy makes the request consult policy to decide whether a failed
+// attempt should be retried, in addition to retries the server asks for with
+// a 'Retry-After' response header. The number of retries is still bounded by
+// MaxRetries. If set to nil, only server requested retries are performed.
+func (r *Request) RetryPolicy(policy RetryPolicy) *Request {
+	if retry, ok := r.retry.(*withRetry); ok {
+		retry.policy = policy
+	}
+	return r
+}
+
 // Body makes the request use obj as the body. Optional.
 // If obj is a string, try to read a file of that name.
 // If obj is a []byte, send it directly.
@@ -574,21 +629,50 @@ func (r Request) finalURLTemplate() url.URL {
 	return *url
 }
 
+// policyRateLimiter returns the rate limiter and bucket name that the
+// RateLimiterPolicy selects for the request, if any.
+func (r *Request) policyRateLimiter() (flowcontrol.RateLimiter, string) {
+	if r.rateLimiterPolicy == nil {
+		return nil, ""
+	}
+	name, limiter := r.rateLimiterPolicy.RateLimiterFor(RateLimiterAttributes{
+		Verb:     r.verb,
+		Resource: r.resource,
+		Watch:    r.params.Get("watch") == "true",
+	})
+	return limiter, name
+}
+
+// selectRateLimiter returns the rate limiter that throttles the request,
+// and the name of its bucket if it was selected by the RateLimiterPolicy.
+func (r *Request) selectRateLimiter() (flowcontrol.RateLimiter, string) {
+	if limiter, bucket := r.policyRateLimiter(); limiter != nil {
+		return limiter, bucket
+	}
+	return r.rateLimiter, ""
+}
+
 func (r *Request) tryThrottleWithInfo(ctx context.Context, retryInfo string) error {
-	if r.rateLimiter == nil {
+	rateLimiter, bucket := r.selectRateLimiter()
+	if rateLimiter == nil {
 		return nil
 	}
 
 	now := time.Now()
 
-	err := r.rateLimiter.Wait(ctx)
+	err := rateLimiter.Wait(ctx)
 
 	latency := time.Since(now)
+	r.span.addThrottleWait(latency)
 
 	var message string
 	switch {
+	case len(retryInfo) > 0 && len(bucket) > 0:
+		message = fmt.Sprintf("Waited for %v, %s - bucket: %s, request: %s:%s", latency, retryInfo, bucket, r.verb, r.URL().String())
 	case len(retryInfo) > 0:
 		message = fmt.Sprintf("Waited for %v, %s - request: %s:%s", latency, retryInfo, r.verb, r.URL().String())
+	case len(bucket) > 0:
+		message = fmt.Sprintf("Waited for %v due to client-side throttling in bucket %q, not priority and fairness, request: %s:%s", latency, bucket, r.verb, r.URL().String())
 	default:
 		message = fmt.Sprintf("Waited for %v due to client-side throttling, not priority and fairness, request: %s:%s", latency, r.verb, r.URL().String())
 	}
@@ -668,12 +752,29 @@ func (b *throttledLogger) Infof(message string, args ...interface{}) {
 
 // Watch attempts to begin watching the requested location.
 // Returns a watch.Interface, or an error.
-func (r *Request) Watch(ctx context.Context) (watch.Interface, error) {
+func (r *Request) Watch(ctx context.Context) (_ watch.Interface, err error) {
+	ctx = r.startSpan(ctx)
+	r.startLog()
+	// the span of a watch ends when its response body is closed.
+	streaming := false
+	defer func() {
+		r.log.end(err)
+		if !streaming {
+			r.span.end(err)
+		}
+	}()
+
 	// We specifically don't want to rate limit watches, so we
-	// don't use r.rateLimiter here.
+	// don't use r.rateLimiter here, unless the RateLimiterPolicy
+	// explicitly asks for it.
 	if r.err != nil {
 		return nil, r.err
 	}
+	if limiter, _ := r.policyRateLimiter(); limiter != nil {
+		if err := r.tryThrottle(ctx); err != nil {
+			return nil, err
+		}
+	}
 
 	client := r.c.Client
 	if client == nil {
@@ -707,17 +808,24 @@ func (r *Request) Watch(ctx context.Context) (watch.Interface, error) {
 			retryAfter = nil
 		}
 
+		if err := r.checkCircuitBreaker(); err != nil {
+			return nil, err
+		}
 		resp, err := client.Do(req)
 		updateURLMetrics(ctx, r, resp, err)
+		r.observeResponse(resp, err)
 		if r.c.base != nil {
 			if err != nil {
-				r.backoff.UpdateBackoff(r.c.base, err, 0)
+				r.backoff.UpdateBackoff(r.URL(), err, 0)
 			} else {
-				r.backoff.UpdateBackoff(r.c.base, err, resp.StatusCode)
+				r.backoff.UpdateBackoff(r.URL(), err, resp.StatusCode)
 			}
 		}
 		if err == nil && resp.StatusCode == http.StatusOK {
-			return r.newStreamWatcher(resp)
+			resp.Body = r.span.endOnClose(resp.Body)
+			watcher, err := r.newStreamWatcher(resp)
+			streaming = err == nil
+			return watcher, err
 		}
 
 		done, transformErr := func() (bool, error) {
@@ -728,6 +836,9 @@ func (r *Request) Watch(ctx context.Context) (watch.Interface, error) {
 			if retry {
 				err := r.retry.BeforeNextRetry(ctx, r.backoff, retryAfter, url, r.body)
 				if err == nil {
+					updateRetryMetrics(ctx, r, resp)
+					r.span.addRetry()
+					r.log.addRetry()
 					return false, nil
 				}
 				klog.V(4).Infof("Could not retry request - %v", err)
@@ -798,11 +909,48 @@ func updateURLMetrics(ctx context.Context, req *Request, resp *http.Response, er
 	}
 }
 
+// observeResponse tells the rate limiter about the outcome of the request,
+// if it adapts its rate to the responses of the server.
+func (r *Request) observeResponse(resp *http.Response, err error) {
+	r.span.observe(resp)
+	r.log.observe(resp)
+	rateLimiter, _ := r.selectRateLimiter()
+	if limiter, ok := rateLimiter.(flowcontrol.AdaptiveRateLimiter); ok {
+		limiter.Observe(resp, err)
+	}
+}
+
+// updateRetryMetrics is a convenience function for counting retries.
+// A nil resp means the previous attempt failed with an error.
+func updateRetryMetrics(ctx context.Context, req *Request, resp *http.Response) {
+	url := "none"
+	if req.c.base != nil {
+		url = req.c.base.Host
+	}
+
+	if resp == nil {
+		metrics.RequestRetry.IncrementRetry(ctx, "<error>", req.verb, url)
+	} else {
+		metrics.RequestRetry.IncrementRetry(ctx, strconv.Itoa(resp.StatusCode), req.verb, url)
+	}
+}
+
 // Stream formats and executes the request, and offers streaming of the response.
 // Returns io.ReadCloser which could be used for streaming of the response, or an error
 // Any non-2xx http status code causes an error.  If we get a non-2xx code, we try to convert the body into an APIStatus object.
 // If we can, we return that as an error.  Otherwise, we create an error that lists the http status and the content of the response.
-func (r *Request) Stream(ctx context.Context) (io.ReadCloser, error) {
+func (r *Request) Stream(ctx context.Context) (_ io.ReadCloser, err error) {
+	ctx = r.startSpan(ctx)
+	r.startLog()
+	// the span of a stream ends when its response body is closed.
+	streaming := false
+	defer func() {
+		r.log.end(err)
+		if !streaming {
+			r.span.end(err)
+		}
+	}()
+
 	if r.err != nil {
 		return nil, r.err
 	}
@@ -838,8 +986,12 @@ func (r *Request) Stream(ctx context.Context) (io.ReadCloser, error) {
 			retryAfter = nil
 		}
 
+		if err := r.checkCircuitBreaker(); err != nil {
+			return nil, err
+		}
 		resp, err := client.Do(req)
 		updateURLMetrics(ctx, r, resp, err)
+		r.observeResponse(resp, err)
 		if r.c.base != nil {
 			if err != nil {
 				r.backoff.UpdateBackoff(r.URL(), err, 0)
@@ -855,7 +1007,8 @@ func (r *Request) Stream(ctx context.Context) (io.ReadCloser, error) {
 		switch {
 		case (resp.StatusCode >= 200) && (resp.StatusCode < 300):
 			handleWarnings(resp.Header, r.warningHandler)
-			return resp.Body, nil
+			streaming = true
+			return r.span.endOnClose(resp.Body), nil
 
 		default:
 			done, transformErr := func() (bool, error) {
@@ -866,6 +1019,9 @@ func (r *Request) Stream(ctx context.Context) (io.ReadCloser, error) {
 				if retry {
 					err := r.retry.BeforeNextRetry(ctx, r.backoff, retryAfter, url, r.body)
 					if err == nil {
+						updateRetryMetrics(ctx, r, resp)
+						r.span.addRetry()
+						r.log.addRetry()
 						return false, nil
 					}
 					klog.V(4).Infof("Could not retry request - %v", err)
@@ -918,6 +1074,12 @@ func (r *Request) newHTTPRequest(ctx context.Context) (*http.Request, error) {
 	}
 	req = req.WithContext(ctx)
 	req.Header = r.headers
+	if err := r.impersonate(req); err != nil {
+		return nil, err
+	}
+	r.attributeUserAgent(req)
+	r.span.inject(req)
+	r.log.requestBody(req)
 	return req, nil
 }
 
@@ -925,7 +1087,14 @@ func (r *Request) newHTTPRequest(ctx context.Context) (*http.Request, error) {
 // received. It handles retry behavior and up front validation of requests. It will invoke
 // fn at most once. It will return an error if a problem occurred prior to connecting to the
 // server - the provided function is responsible for handling server errors.
-func (r *Request) request(ctx context.Context, fn func(*http.Request, *http.Response)) error {
+func (r *Request) request(ctx context.Context, fn func(*http.Request, *http.Response)) (err error) {
+	ctx = r.startSpan(ctx)
+	r.startLog()
+	defer func() {
+		r.log.end(err)
+		r.span.end(err)
+	}()
+
 	//Metrics for total request latency
 	start := time.Now()
 	defer func() {
@@ -977,8 +1146,12 @@ func (r *Request) request(ctx context.Context, fn func(*http.Request, *http.Resp
 			}
 			retryAfter = nil
 		}
+		if err := r.checkCircuitBreaker(); err != nil {
+			return err
+		}
 		resp, err := client.Do(req)
 		updateURLMetrics(ctx, r, resp, err)
+		r.observeResponse(resp, err)
 		if err != nil {
 			r.backoff.UpdateBackoff(r.URL(), err, 0)
 		} else {
@@ -1013,6 +1186,9 @@ func (r *Request) request(ctx context.Context, fn func(*http.Request, *http.Resp
 			if retry {
 				err := r.retry.BeforeNextRetry(ctx, r.backoff, retryAfter, req.URL.String(), r.body)
 				if err == nil {
+					updateRetryMetrics(ctx, r, resp)
+					r.span.addRetry()
+					r.log.addRetry()
 					return false
 				}
 				klog.V(4).Infof("Could not retry request - %v", err)
@@ -1048,8 +1224,12 @@ func (r *Request) Do(ctx context.Context) Result {
 func (r *Request) DoRaw(ctx context.Context) ([]byte, error) {
 	var result Result
 	err := r.request(ctx, func(req *http.Request, resp *http.Response) {
-		result.body, result.err = ioutil.ReadAll(resp.Body)
+		result.body, result.err = r.readResponseBody(resp)
+		if result.err != nil {
+			return
+		}
 		glogBody("Response Body", result.body)
+		r.log.responseBody(result.body)
 		if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusPartialContent {
 			result.err = r.transformUnstructuredResponseError(resp, req, result.body)
 		}
@@ -1060,14 +1240,37 @@ func (r *Request) DoRaw(ctx context.Context) ([]byte, error) {
 	return result.body, result.err
 }
 
+// readResponseBody reads the body of resp, unless it is larger than the
+// maximum response size of the request.
+func (r *Request) readResponseBody(resp *http.Response) ([]byte, error) {
+	if r.maxResponseBytes <= 0 {
+		return ioutil.ReadAll(resp.Body)
+	}
+	if resp.ContentLength > r.maxResponseBytes {
+		return nil, &ResponseTooLargeError{Limit: r.maxResponseBytes, Size: resp.ContentLength}
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, r.maxResponseBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > r.maxResponseBytes {
+		return nil, &ResponseTooLargeError{Limit: r.maxResponseBytes, Size: -1}
+	}
+	return data, nil
+}
+
 // transformResponse converts an API response into a structured API object
 func (r *Request) transformResponse(resp *http.Response, req *http.Request) Result {
 	var body []byte
 	if resp.Body != nil {
-		data, err := ioutil.ReadAll(resp.Body)
+		data, err := r.readResponseBody(resp)
 		switch err.(type) {
 		case nil:
 			body = data
+		case *ResponseTooLargeError:
+			return Result{
+				err: err,
+			}
 		case http2.StreamError:
 			// This is trying to catch the scenario that the server may close the connection when sending the
 			// response body. This can be caused by server timeout due to a slow network connection.
@@ -1091,6 +1294,7 @@ func (r *Request) transformResponse(resp *http.Response, req *http.Request) Resu
 	}
 
 	glogBody("Response Body", body)
+	r.log.responseBody(body)
 
 	// verify the content type is accurate
 	var decoder runtime.Decoder
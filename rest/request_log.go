@@ -0,0 +1,310 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/transport"
+	"k8s.io/klog/v2"
+)
+
+// DefaultMaxLoggedBodyBytes is the number of bytes of request and response
+// bodies a RequestLogger records if MaxBodyBytes is not set.
+const DefaultMaxLoggedBodyBytes = 4096
+
+// maskedBodyResources are the resources whose bodies carry credentials, and
+// are never logged.
+var maskedBodyResources = map[string]bool{
+	"secrets":      true,
+	"tokenreviews": true,
+}
+
+// RequestLogger writes a structured record of every request sent by a
+// client, for auditing. Unlike the request logging enabled by the verbosity
+// of klog, records are written regardless of the log level, one per call to
+// Do, DoRaw, Watch or Stream. Credentials in headers are masked.
+//
+// A RequestLogger may be shared by several clients.
+type RequestLogger struct {
+	// Writer, if set, receives every record as a line of JSON.
+	Writer io.Writer
+	// Func, if set, is called with every record.
+	Func func(record RequestRecord)
+	// SampleRatio is the fraction of requests that are logged, between 0
+	// and 1. Set it to 1 to log every request, zero or less logs none.
+	SampleRatio float64
+	// LogBodies enables logging of request bodies, and of the response bodies
+	// of calls to Do and DoRaw. Bodies of secrets and token reviews are never
+	// logged.
+	LogBodies bool
+	// MaxBodyBytes is the number of bytes of a body that are logged, longer
+	// bodies are truncated. Zero means DefaultMaxLoggedBodyBytes.
+	MaxBodyBytes int
+}
+
+// requestLogWriteLock serializes the records written to the Writers of all
+// RequestLoggers, so that lines are not interleaved.
+var requestLogWriteLock sync.Mutex
+
+// RequestRecord is the record of a request written by a RequestLogger.
+type RequestRecord struct {
+	// Time is the time the call started.
+	Time time.Time `json:"time"`
+	// Method is the HTTP method of the request.
+	Method string `json:"method"`
+	// URL is the URL of the request, without user info.
+	URL string `json:"url"`
+	// Verb is the Kubernetes API verb of the request, like "list" or "create".
+	Verb string `json:"verb"`
+	// Namespace, Resource, Subresource and Name describe the object the
+	// request is for, when they are set.
+	Namespace   string `json:"namespace,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+	// UserAgent is the user agent the request was sent with.
+	UserAgent string `json:"userAgent,omitempty"`
+	// ImpersonatedUser and ImpersonatedGroups are the user and groups the
+	// request impersonated, if any.
+	ImpersonatedUser   string   `json:"impersonatedUser,omitempty"`
+	ImpersonatedGroups []string `json:"impersonatedGroups,omitempty"`
+	// Header holds the request headers, with credentials masked.
+	Header http.Header `json:"header,omitempty"`
+	// StatusCode is the status code of the last response, or zero if no
+	// response was received.
+	StatusCode int `json:"statusCode,omitempty"`
+	// Error is the error the call failed with, if any.
+	Error string `json:"error,omitempty"`
+	// Latency is the time until the response was handled, or for Watch and
+	// Stream until the response headers were received.
+	Latency time.Duration `json:"latency"`
+	// Retries is the number of times the request was retried.
+	Retries int `json:"retries,omitempty"`
+	// RequestBody and ResponseBody hold the bodies of the request and the
+	// response if LogBodies is set, up to MaxBodyBytes.
+	RequestBody           string `json:"requestBody,omitempty"`
+	RequestBodyTruncated  bool   `json:"requestBodyTruncated,omitempty"`
+	ResponseBody          string `json:"responseBody,omitempty"`
+	ResponseBodyTruncated bool   `json:"responseBodyTruncated,omitempty"`
+}
+
+func (l *RequestLogger) sampled() bool {
+	return l.SampleRatio >= 1 || (l.SampleRatio > 0 && rand.Float64() < l.SampleRatio)
+}
+
+func (l *RequestLogger) maxBodyBytes() int {
+	if l.MaxBodyBytes > 0 {
+		return l.MaxBodyBytes
+	}
+	return DefaultMaxLoggedBodyBytes
+}
+
+func (l *RequestLogger) write(record RequestRecord) {
+	if l.Func != nil {
+		l.Func(record)
+	}
+	if l.Writer == nil {
+		return
+	}
+	data, err := json.Marshal(&record)
+	if err != nil {
+		klog.V(2).Infof("Failed to encode request log record: %v", err)
+		return
+	}
+	requestLogWriteLock.Lock()
+	defer requestLogWriteLock.Unlock()
+	if _, err := l.Writer.Write(append(data, '\n')); err != nil {
+		klog.V(2).Infof("Failed to write request log record: %v", err)
+	}
+}
+
+// requestLogEntry collects the record of a logged call.
+type requestLogEntry struct {
+	logger *RequestLogger
+	start  time.Time
+	// logBodies is unset for requests whose bodies carry credentials.
+	logBodies bool
+
+	lock   sync.Mutex
+	record RequestRecord
+	ended  bool
+}
+
+// startLog starts the record of a call if the client has a RequestLogger
+// and the call is sampled.
+func (r *Request) startLog() {
+	r.log = nil
+	if r.logger == nil || !r.logger.sampled() {
+		return
+	}
+	u := r.URL()
+	r.log = &requestLogEntry{
+		logger:    r.logger,
+		start:     time.Now(),
+		logBodies: r.logger.LogBodies && !maskedBodyResources[r.resource] && r.subresource != "token",
+		record: RequestRecord{
+			Method:      r.verb,
+			URL:         redactURL(u),
+			Verb:        r.apiVerb(),
+			Namespace:   r.namespace,
+			Resource:    r.resource,
+			Subresource: r.subresource,
+			Name:        r.resourceName,
+		},
+	}
+	r.log.record.Time = r.log.start
+	r.log.setHeader(r.headers)
+}
+
+// apiVerb returns the Kubernetes API verb of the request.
+func (r *Request) apiVerb() string {
+	switch r.verb {
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		if len(r.resourceName) == 0 && len(r.resource) > 0 {
+			return "deletecollection"
+		}
+		return "delete"
+	case http.MethodGet:
+		switch {
+		case r.params.Get("watch") == "true" || r.params.Get("watch") == "1":
+			return "watch"
+		case len(r.resourceName) == 0 && len(r.resource) > 0:
+			return "list"
+		}
+		return "get"
+	}
+	return r.verb
+}
+
+func redactURL(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+	redacted := *u
+	redacted.User = nil
+	return redacted.String()
+}
+
+// setHeader records the headers the request was sent with.
+func (e *requestLogEntry) setHeader(header http.Header) {
+	e.record.Header = transport.RedactHeader(header)
+	e.record.UserAgent = header.Get("User-Agent")
+	e.record.ImpersonatedUser = header.Get(transport.ImpersonateUserHeader)
+	e.record.ImpersonatedGroups = header.Values(transport.ImpersonateGroupHeader)
+}
+
+// requestBody records the body of req, if bodies are logged.
+func (e *requestLogEntry) requestBody(req *http.Request) {
+	if e == nil || !e.logBodies || req.GetBody == nil {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.record.RequestBody) > 0 {
+		// the body was recorded by an earlier attempt.
+		return
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(body, int64(e.logger.maxBodyBytes())+1))
+	if err != nil {
+		return
+	}
+	e.record.RequestBody, e.record.RequestBodyTruncated = truncateLoggedBody(data, e.logger.maxBodyBytes())
+}
+
+// responseBody records body as the body of the response, if bodies are
+// logged.
+func (e *requestLogEntry) responseBody(body []byte) {
+	if e == nil || !e.logBodies {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.record.ResponseBody, e.record.ResponseBodyTruncated = truncateLoggedBody(body, e.logger.maxBodyBytes())
+}
+
+func truncateLoggedBody(body []byte, max int) (string, bool) {
+	if len(body) > max {
+		return string(body[:max]), true
+	}
+	return string(body), false
+}
+
+func (e *requestLogEntry) observe(resp *http.Response) {
+	if e == nil {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if resp == nil {
+		e.record.StatusCode = 0
+		return
+	}
+	e.record.StatusCode = resp.StatusCode
+	if resp.Request != nil {
+		// the request the transport sent, with the headers added by the
+		// round trippers of the client.
+		e.setHeader(resp.Request.Header)
+	}
+}
+
+func (e *requestLogEntry) addRetry() {
+	if e == nil {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.record.Retries++
+}
+
+// end writes the record with err, unless it has been written already.
+func (e *requestLogEntry) end(err error) {
+	if e == nil {
+		return
+	}
+	e.lock.Lock()
+	if e.ended {
+		e.lock.Unlock()
+		return
+	}
+	e.ended = true
+	record := e.record
+	record.Latency = time.Since(e.start)
+	if err != nil {
+		record.Error = err.Error()
+	}
+	e.lock.Unlock()
+	e.logger.write(record)
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func newRequestLogTestClient(t *testing.T, handler http.HandlerFunc, logger *RequestLogger) (*RESTClient, func()) {
+	server := httptest.NewServer(handler)
+	client, err := RESTClientFor(&Config{
+		Host:          server.URL,
+		APIPath:       "/api",
+		BearerToken:   "secret-token",
+		UserAgent:     "audit-test",
+		Impersonate:   ImpersonationConfig{UserName: "alice", Groups: []string{"devs"}},
+		RequestLogger: logger,
+		ContentConfig: ContentConfig{
+			GroupVersion:         &v1.SchemeGroupVersion,
+			NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server.Close
+}
+
+func TestRequestLoggerWriter(t *testing.T) {
+	var retried int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&retried, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"kind":"Pod","apiVersion":"v1","metadata":{"name":"foo"}}`))
+	}
+	var out bytes.Buffer
+	client, stop := newRequestLogTestClient(t, handler, &RequestLogger{Writer: &out, SampleRatio: 1, LogBodies: true, MaxBodyBytes: 16})
+	defer stop()
+
+	pod := &v1.Pod{}
+	pod.Name = "foo"
+	if err := client.Post().Namespace("default").Resource("pods").Body(pod).Do(context.Background()).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one record, got %d: %s", len(lines), out.String())
+	}
+	var record RequestRecord
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+
+	if record.Method != "POST" || record.Verb != "create" || record.Namespace != "default" || record.Resource != "pods" {
+		t.Errorf("unexpected request attributes: %#v", record)
+	}
+	if !strings.HasSuffix(record.URL, "/api/v1/namespaces/default/pods") {
+		t.Errorf("unexpected URL %s", record.URL)
+	}
+	if record.StatusCode != http.StatusCreated || record.Retries != 1 || record.Latency <= 0 || len(record.Error) != 0 {
+		t.Errorf("unexpected result: %#v", record)
+	}
+	if record.UserAgent != "audit-test" || record.ImpersonatedUser != "alice" || len(record.ImpersonatedGroups) != 1 || record.ImpersonatedGroups[0] != "devs" {
+		t.Errorf("unexpected identity: %#v", record)
+	}
+	if auth := record.Header.Get("Authorization"); auth != "Bearer <masked>" {
+		t.Errorf("expected the bearer token to be masked, got %q", auth)
+	}
+	if strings.Contains(lines[0], "secret-token") {
+		t.Errorf("the record contains the bearer token: %s", lines[0])
+	}
+	if len(record.RequestBody) != 16 || !record.RequestBodyTruncated {
+		t.Errorf("expected the request body to be truncated to 16 bytes, got %q", record.RequestBody)
+	}
+	if record.ResponseBody != `{"kind":"Pod","a` || !record.ResponseBodyTruncated {
+		t.Errorf("expected the response body to be truncated to 16 bytes, got %q", record.ResponseBody)
+	}
+}
+
+func TestRequestLoggerFunc(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"Secret","apiVersion":"v1","data":{"password":"aHVudGVyMg=="}}`))
+	}
+	var lock sync.Mutex
+	var records []RequestRecord
+	client, stop := newRequestLogTestClient(t, handler, &RequestLogger{
+		Func: func(record RequestRecord) {
+			lock.Lock()
+			defer lock.Unlock()
+			records = append(records, record)
+		},
+		SampleRatio: 1,
+		LogBodies:   true,
+	})
+	defer stop()
+
+	if err := client.Get().Namespace("default").Resource("secrets").Name("foo").Do(context.Background()).Error(); err != nil {
+		t.Fatal(err)
+	}
+	w, err := client.Get().Namespace("default").Resource("pods").Param("watch", "true").Watch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Stop()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(records) != 2 {
+		t.Fatalf("expected two records, got %d", len(records))
+	}
+	if records[0].Verb != "get" || records[0].Name != "foo" || len(records[0].ResponseBody) != 0 {
+		t.Errorf("expected a get without the secret body, got %#v", records[0])
+	}
+	if records[1].Verb != "watch" || records[1].StatusCode != http.StatusOK {
+		t.Errorf("expected a watch, got %#v", records[1])
+	}
+}
+
+func TestRequestLoggerSampling(t *testing.T) {
+	testCases := map[string]struct {
+		ratio    float64
+		expected int32
+	}{
+		"unset":  {ratio: 0, expected: 0},
+		"tiny":   {ratio: 1e-12, expected: 0},
+		"all":    {ratio: 1, expected: 10},
+		"beyond": {ratio: 2, expected: 10},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			handler := func(w http.ResponseWriter, r *http.Request) {}
+			var logged int32
+			client, stop := newRequestLogTestClient(t, handler, &RequestLogger{
+				Func:        func(RequestRecord) { atomic.AddInt32(&logged, 1) },
+				SampleRatio: tc.ratio,
+			})
+			defer stop()
+
+			for i := 0; i < 10; i++ {
+				client.Get().Resource("pods").Do(context.Background())
+			}
+			if logged := atomic.LoadInt32(&logged); logged != tc.expected {
+				t.Errorf("expected %d requests to be sampled, got %d", tc.expected, logged)
+			}
+		})
+	}
+}
+
+func TestRequestLoggerAPIVerb(t *testing.T) {
+	c := testRESTClient(t, nil)
+	testCases := []struct {
+		request  *Request
+		expected string
+	}{
+		{c.Get().Resource("pods"), "list"},
+		{c.Get().Resource("pods").Name("foo"), "get"},
+		{c.Get().Resource("pods").Param("watch", "1"), "watch"},
+		{c.Put().Resource("pods").Name("foo"), "update"},
+		{c.Patch("application/merge-patch+json").Resource("pods").Name("foo"), "patch"},
+		{c.Delete().Resource("pods"), "deletecollection"},
+		{c.Delete().Resource("pods").Name("foo"), "delete"},
+		{c.Get().AbsPath("/version"), "get"},
+	}
+	for _, tc := range testCases {
+		if verb := tc.request.apiVerb(); verb != tc.expected {
+			t.Errorf("%s %s: expected %s, got %s", tc.request.verb, tc.request.URL(), tc.expected, verb)
+		}
+	}
+}
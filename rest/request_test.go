@@ -49,6 +49,7 @@ import (
 	"k8s.io/apimachinery/pkg/util/diff"
 	"k8s.io/apimachinery/pkg/util/httpstream"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/scheme"
 	restclientwatch "k8s.io/client-go/rest/watch"
@@ -1712,6 +1713,140 @@ func TestCheckRetryHandles429And5xx(t *testing.T) {
 	}
 }
 
+func TestRequestRetryPolicy(t *testing.T) {
+	policy := NewDefaultRetryPolicy()
+	policy.Backoff = wait.Backoff{Duration: time.Millisecond}
+	policy.IdempotentVerbs.Insert("PUT")
+	body := strings.Repeat("abcd", 1000)
+
+	tests := []struct {
+		name          string
+		verb          string
+		body          interface{}
+		statusCodes   []int
+		expectErr     bool
+		expectAttempt int
+	}{
+		{
+			name:          "GET is retried on 5xx",
+			verb:          "GET",
+			statusCodes:   []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK},
+			expectAttempt: 3,
+		},
+		{
+			name:          "GET is not retried on 404",
+			verb:          "GET",
+			statusCodes:   []int{http.StatusNotFound, http.StatusOK},
+			expectErr:     true,
+			expectAttempt: 1,
+		},
+		{
+			name:          "POST is not retried on 5xx",
+			verb:          "POST",
+			body:          []byte(body),
+			statusCodes:   []int{http.StatusServiceUnavailable, http.StatusOK},
+			expectErr:     true,
+			expectAttempt: 1,
+		},
+		{
+			name:          "PUT replays the body",
+			verb:          "PUT",
+			body:          []byte(body),
+			statusCodes:   []int{http.StatusServiceUnavailable, http.StatusOK},
+			expectAttempt: 2,
+		},
+		{
+			name:          "PUT with a body that can not be replayed",
+			verb:          "PUT",
+			body:          ioutil.NopCloser(strings.NewReader(body)),
+			statusCodes:   []int{http.StatusServiceUnavailable, http.StatusOK},
+			expectErr:     true,
+			expectAttempt: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var attempts int
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				data, err := ioutil.ReadAll(req.Body)
+				if err != nil {
+					t.Errorf("unable to read request body: %v", err)
+				}
+				if test.body != nil && string(data) != body {
+					t.Errorf("attempt %d did not send a complete body: %q", attempts, data)
+				}
+				w.WriteHeader(test.statusCodes[attempts])
+				attempts++
+			}))
+			defer testServer.Close()
+
+			c := testRESTClient(t, testServer)
+			c.retryPolicy = policy
+			req := c.Verb(test.verb).Prefix("foo")
+			if test.body != nil {
+				req.Body(test.body)
+			}
+			_, err := req.DoRaw(context.Background())
+			if test.expectErr != (err != nil) {
+				t.Errorf("Expected error: %t, but got: %v", test.expectErr, err)
+			}
+			if attempts != test.expectAttempt {
+				t.Errorf("Expected attempts: %d, but got: %d", test.expectAttempt, attempts)
+			}
+		})
+	}
+}
+
+func TestRequestRetryPolicyOverride(t *testing.T) {
+	var attempts int
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer testServer.Close()
+
+	c := testRESTClient(t, testServer)
+	c.retryPolicy = NewDefaultRetryPolicy()
+	_, err := c.Get().Prefix("foo").RetryPolicy(nil).DoRaw(context.Background())
+	if err == nil {
+		t.Fatalf("Expected an error")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected a single attempt, but got: %d", attempts)
+	}
+}
+
+func TestAdaptiveRateLimiterObservesResponses(t *testing.T) {
+	count := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		count++
+		if count <= 2 {
+			w.Header().Set("X-Kubernetes-PF-FlowSchema-UID", "flow-schema")
+			w.Header().Set("X-Kubernetes-PF-PriorityLevel-UID", "priority-level")
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	limiter := flowcontrol.NewAdaptiveRateLimiter(1, 100, 10)
+	c := testRESTClient(t, testServer)
+	c.rateLimiter = limiter
+	if _, err := c.Get().Prefix("foo").DoRaw(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 attempts, got %d", count)
+	}
+	// halved twice, then raised by 1% of the maximum.
+	if qps := limiter.QPS(); qps != 26 {
+		t.Errorf("Expected QPS 26, got %v", qps)
+	}
+}
+
 func BenchmarkCheckRetryClosesBody(b *testing.B) {
 	count := 0
 	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -0,0 +1,142 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// RetryPolicy decides whether a request that failed should be sent again.
+// It is consulted after every failed attempt the server did not already
+// ask us to retry with a 'Retry-After' response header, and only for
+// requests whose body can be replayed.
+type RetryPolicy interface {
+	// ShouldRetry returns how long to wait before the next attempt and
+	// true if the request should be retried, or false otherwise.
+	//
+	// request: the request that was sent to the server
+	// resp: the response sent from the server, it is set if err is nil
+	// err: the error returned by the transport, if err is set then resp is nil.
+	// attempt: the number of attempts that have failed so far, starting at 1.
+	// elapsed: the time since the first attempt failed.
+	ShouldRetry(request *http.Request, resp *http.Response, err error, attempt int, elapsed time.Duration) (time.Duration, bool)
+}
+
+// BackoffRetryPolicy is a RetryPolicy that retries requests that failed
+// with a transient error, waiting an exponentially growing, jittered delay
+// between attempts. A request that failed because the connection was
+// refused is always retried, since it never reached the server. Any other
+// error or status code is only retried for idempotent verbs.
+type BackoffRetryPolicy struct {
+	// MaxRetries is the maximum number of retries. Zero means no limit
+	// other than MaxElapsed.
+	MaxRetries int
+
+	// MaxElapsed is the time budget for retries, measured from the first
+	// failed attempt. A retry that would start after the budget is spent
+	// is not attempted. Zero means no budget.
+	MaxElapsed time.Duration
+
+	// Backoff computes the delay before the Nth retry as
+	// Duration*Factor^(N-1), capped at Cap and jittered by Jitter. Steps is
+	// ignored.
+	Backoff wait.Backoff
+
+	// IdempotentVerbs are the verbs that are safe to send again after the
+	// server may have received them.
+	IdempotentVerbs sets.String
+
+	// RetryableStatusCodes are the HTTP status codes that are retried.
+	RetryableStatusCodes sets.Int
+}
+
+var _ RetryPolicy = &BackoffRetryPolicy{}
+
+// NewDefaultRetryPolicy returns a BackoffRetryPolicy that retries GET, HEAD
+// and OPTIONS requests up to 5 times within 30 seconds after connection
+// resets, EOFs, timeouts, HTTP/2 GOAWAYs and 500, 502, 503 and 504 responses.
+func NewDefaultRetryPolicy() *BackoffRetryPolicy {
+	return &BackoffRetryPolicy{
+		MaxRetries: 5,
+		MaxElapsed: 30 * time.Second,
+		Backoff: wait.Backoff{
+			Duration: 100 * time.Millisecond,
+			Factor:   2.0,
+			Jitter:   0.2,
+			Cap:      5 * time.Second,
+		},
+		IdempotentVerbs: sets.NewString("GET", "HEAD", "OPTIONS"),
+		RetryableStatusCodes: sets.NewInt(
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		),
+	}
+}
+
+// ShouldRetry implements RetryPolicy.
+func (p *BackoffRetryPolicy) ShouldRetry(request *http.Request, resp *http.Response, err error, attempt int, elapsed time.Duration) (time.Duration, bool) {
+	if p.MaxRetries > 0 && attempt > p.MaxRetries {
+		return 0, false
+	}
+
+	idempotent := p.IdempotentVerbs.Has(request.Method)
+	switch {
+	case err != nil && net.IsConnectionRefused(err):
+		// the request never made it to the server.
+	case err != nil:
+		if !idempotent || !(net.IsConnectionReset(err) || net.IsProbableEOF(err) || net.IsTimeout(err)) {
+			return 0, false
+		}
+	case resp != nil:
+		if !idempotent || !p.RetryableStatusCodes.Has(resp.StatusCode) {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	delay := p.delay(attempt)
+	if p.MaxElapsed > 0 && elapsed+delay > p.MaxElapsed {
+		return 0, false
+	}
+	return delay, true
+}
+
+// delay returns the jittered delay before the given retry.
+func (p *BackoffRetryPolicy) delay(attempt int) time.Duration {
+	factor := p.Backoff.Factor
+	if factor < 1 {
+		factor = 1
+	}
+	backoff := float64(p.Backoff.Duration) * math.Pow(factor, float64(attempt-1))
+	delay := time.Duration(backoff)
+	if backoff > math.MaxInt64 || (p.Backoff.Cap > 0 && delay > p.Backoff.Cap) {
+		delay = p.Backoff.Cap
+	}
+	if p.Backoff.Jitter > 0 {
+		delay = wait.Jitter(delay, p.Backoff.Jitter)
+	}
+	return delay
+}
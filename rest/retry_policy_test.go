@@ -0,0 +1,153 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestBackoffRetryPolicy(t *testing.T) {
+	policy := NewDefaultRetryPolicy()
+	policy.Backoff = wait.Backoff{Duration: time.Second, Factor: 2, Cap: 3 * time.Second}
+
+	refused := &net.OpError{Op: "dial", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}
+	tests := []struct {
+		name        string
+		verb        string
+		resp        *http.Response
+		err         error
+		attempt     int
+		elapsed     time.Duration
+		expectRetry bool
+		expectWait  time.Duration
+	}{
+		{
+			name:        "GET with 503",
+			verb:        "GET",
+			resp:        &http.Response{StatusCode: http.StatusServiceUnavailable},
+			attempt:     1,
+			expectRetry: true,
+			expectWait:  time.Second,
+		},
+		{
+			name:        "backoff grows",
+			verb:        "GET",
+			resp:        &http.Response{StatusCode: http.StatusBadGateway},
+			attempt:     2,
+			expectRetry: true,
+			expectWait:  2 * time.Second,
+		},
+		{
+			name:        "backoff is capped",
+			verb:        "GET",
+			resp:        &http.Response{StatusCode: http.StatusGatewayTimeout},
+			attempt:     4,
+			expectRetry: true,
+			expectWait:  3 * time.Second,
+		},
+		{
+			name:    "GET with 501",
+			verb:    "GET",
+			resp:    &http.Response{StatusCode: http.StatusNotImplemented},
+			attempt: 1,
+		},
+		{
+			name:    "POST with 503",
+			verb:    "POST",
+			resp:    &http.Response{StatusCode: http.StatusServiceUnavailable},
+			attempt: 1,
+		},
+		{
+			name:        "GET with EOF",
+			verb:        "GET",
+			err:         io.ErrUnexpectedEOF,
+			attempt:     1,
+			expectRetry: true,
+			expectWait:  time.Second,
+		},
+		{
+			name:    "POST with EOF",
+			verb:    "POST",
+			err:     io.ErrUnexpectedEOF,
+			attempt: 1,
+		},
+		{
+			name:        "POST with connection refused",
+			verb:        "POST",
+			err:         refused,
+			attempt:     1,
+			expectRetry: true,
+			expectWait:  time.Second,
+		},
+		{
+			name:    "GET with unknown error",
+			verb:    "GET",
+			err:     errors.New("unknown"),
+			attempt: 1,
+		},
+		{
+			name:    "too many retries",
+			verb:    "GET",
+			resp:    &http.Response{StatusCode: http.StatusServiceUnavailable},
+			attempt: 6,
+		},
+		{
+			name:    "elapsed budget exhausted",
+			verb:    "GET",
+			resp:    &http.Response{StatusCode: http.StatusServiceUnavailable},
+			attempt: 1,
+			elapsed: 29500 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := &http.Request{Method: test.verb}
+			wait, retry := policy.ShouldRetry(req, test.resp, test.err, test.attempt, test.elapsed)
+			if retry != test.expectRetry {
+				t.Fatalf("Expected retry: %t, but got: %t", test.expectRetry, retry)
+			}
+			if wait != test.expectWait {
+				t.Errorf("Expected wait: %v, but got: %v", test.expectWait, wait)
+			}
+		})
+	}
+}
+
+func TestBackoffRetryPolicyJitter(t *testing.T) {
+	policy := NewDefaultRetryPolicy()
+	req := &http.Request{Method: "GET"}
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable}
+	for i := 0; i < 100; i++ {
+		wait, retry := policy.ShouldRetry(req, resp, nil, 1, 0)
+		if !retry {
+			t.Fatalf("Expected a retry")
+		}
+		if wait < 100*time.Millisecond || wait > 120*time.Millisecond {
+			t.Fatalf("Expected a wait between 100ms and 120ms, but got: %v", wait)
+		}
+	}
+}
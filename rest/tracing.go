@@ -0,0 +1,212 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Tracer starts a span for every call to Do, DoRaw, Watch and Stream of a
+// request. It is deliberately small, so that it can be adapted to any
+// tracing library.
+type Tracer interface {
+	// StartSpan is called with the context passed to Do, DoRaw, Watch or
+	// Stream before the request is throttled and sent. The returned context
+	// is used for the request.
+	StartSpan(ctx context.Context, attributes SpanAttributes) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SpanContext returns the identity of the span, that is sent to the
+	// server in a W3C 'traceparent' request header. No header is sent if
+	// the trace ID is zero.
+	SpanContext() SpanContext
+	// End is called once when the call ends. For Watch and Stream this is
+	// when the response body is closed, or when the call fails.
+	End(result SpanResult)
+}
+
+// SpanAttributes describe the request a span is started for.
+type SpanAttributes struct {
+	// Verb is the HTTP verb of the request.
+	Verb string
+	// Namespace, Resource, Subresource and Name describe the object the
+	// request is for, when they are set.
+	Namespace   string
+	Resource    string
+	Subresource string
+	Name        string
+	// URL is the URL of the request.
+	URL string
+}
+
+// SpanResult describes the outcome of the call a span was started for.
+type SpanResult struct {
+	// StatusCode is the status code of the last response from the server,
+	// or zero if no response was received.
+	StatusCode int
+	// Err is the error the call failed with before a response could be
+	// handled, if any.
+	Err error
+	// Retries is the number of times the request was retried.
+	Retries int
+	// ThrottleWait is the time the request spent waiting for the client-side
+	// rate limiter.
+	ThrottleWait time.Duration
+}
+
+// SpanContext identifies a span, see https://www.w3.org/TR/trace-context/.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid returns whether the span context has a trace and span ID.
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != [16]byte{} && c.SpanID != [8]byte{}
+}
+
+// TraceParent returns the value of the W3C 'traceparent' header for the span.
+func (c SpanContext) TraceParent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(c.TraceID[:]) + "-" + hex.EncodeToString(c.SpanID[:]) + "-" + flags
+}
+
+// requestSpan collects the result of a traced call.
+type requestSpan struct {
+	span Span
+
+	lock   sync.Mutex
+	result SpanResult
+	ended  bool
+}
+
+// startSpan starts a span for the request if the client has a Tracer, and
+// returns the context to use for the request.
+func (r *Request) startSpan(ctx context.Context) context.Context {
+	r.span = nil
+	if r.tracer == nil {
+		return ctx
+	}
+	ctx, span := r.tracer.StartSpan(ctx, SpanAttributes{
+		Verb:        r.verb,
+		Namespace:   r.namespace,
+		Resource:    r.resource,
+		Subresource: r.subresource,
+		Name:        r.resourceName,
+		URL:         r.URL().String(),
+	})
+	if span != nil {
+		r.span = &requestSpan{span: span}
+	}
+	return ctx
+}
+
+// inject adds the 'traceparent' header of the span to req.
+func (s *requestSpan) inject(req *http.Request) {
+	if s == nil {
+		return
+	}
+	sc := s.span.SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	// the headers are shared with the Request, so copy them.
+	header := req.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("traceparent", sc.TraceParent())
+	req.Header = header
+}
+
+func (s *requestSpan) observe(resp *http.Response) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if resp != nil {
+		s.result.StatusCode = resp.StatusCode
+	} else {
+		s.result.StatusCode = 0
+	}
+}
+
+func (s *requestSpan) addRetry() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.result.Retries++
+}
+
+func (s *requestSpan) addThrottleWait(wait time.Duration) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.result.ThrottleWait += wait
+}
+
+// end ends the span with err, unless it has been ended already.
+func (s *requestSpan) end(err error) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	result := s.result
+	result.Err = err
+	s.lock.Unlock()
+	s.span.End(result)
+}
+
+// endOnClose returns body wrapped so that the span ends when it is closed.
+func (s *requestSpan) endOnClose(body io.ReadCloser) io.ReadCloser {
+	if s == nil {
+		return body
+	}
+	return &spanEndingBody{ReadCloser: body, span: s}
+}
+
+type spanEndingBody struct {
+	io.ReadCloser
+	span *requestSpan
+}
+
+func (b *spanEndingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.span.end(nil)
+	return err
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+type testSpanKey struct{}
+
+type testSpan struct {
+	tracer *testTracer
+	ctx    SpanContext
+}
+
+func (s *testSpan) SpanContext() SpanContext { return s.ctx }
+
+func (s *testSpan) End(result SpanResult) {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.tracer.ended = append(s.tracer.ended, result)
+}
+
+type testTracer struct {
+	lock    sync.Mutex
+	started []SpanAttributes
+	ended   []SpanResult
+}
+
+func (t *testTracer) StartSpan(ctx context.Context, attributes SpanAttributes) (context.Context, Span) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.started = append(t.started, attributes)
+	span := &testSpan{tracer: t}
+	span.ctx.TraceID[0] = 0xab
+	span.ctx.SpanID[7] = byte(len(t.started))
+	span.ctx.Sampled = true
+	return context.WithValue(ctx, testSpanKey{}, span), span
+}
+
+func (t *testTracer) results() []SpanResult {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]SpanResult(nil), t.ended...)
+}
+
+func TestSpanContextTraceParent(t *testing.T) {
+	sc := SpanContext{Sampled: true}
+	for i := range sc.TraceID {
+		sc.TraceID[i] = byte(i)
+	}
+	for i := range sc.SpanID {
+		sc.SpanID[i] = byte(0xf0 + i)
+	}
+	if expected, got := "00-000102030405060708090a0b0c0d0e0f-f0f1f2f3f4f5f6f7-01", sc.TraceParent(); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if (SpanContext{}).IsValid() {
+		t.Errorf("expected an empty span context to be invalid")
+	}
+}
+
+func TestRequestTracing(t *testing.T) {
+	var lock sync.Mutex
+	var traceParents []string
+	count := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		traceParents = append(traceParents, req.Header.Get("traceparent"))
+		count++
+		first := count == 1
+		lock.Unlock()
+		if first {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	tracer := &testTracer{}
+	c := testRESTClient(t, testServer)
+	c.tracer = tracer
+
+	if _, err := c.Get().Namespace("ns").Resource("pods").Name("foo").DoRaw(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tracer.started) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(tracer.started))
+	}
+	attributes := tracer.started[0]
+	if attributes.Verb != "GET" || attributes.Namespace != "ns" || attributes.Resource != "pods" || attributes.Name != "foo" {
+		t.Errorf("unexpected span attributes: %#v", attributes)
+	}
+	results := tracer.results()
+	if len(results) != 1 {
+		t.Fatalf("expected 1 ended span, got %d", len(results))
+	}
+	if results[0].StatusCode != http.StatusOK || results[0].Retries != 1 || results[0].Err != nil {
+		t.Errorf("unexpected span result: %#v", results[0])
+	}
+	expected := "00-ab000000000000000000000000000000-0000000000000001-01"
+	if len(traceParents) != 2 || traceParents[0] != expected || traceParents[1] != expected {
+		t.Errorf("expected every attempt to carry traceparent %q, got %v", expected, traceParents)
+	}
+}
+
+func TestRequestTracingError(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	testServer.Close()
+
+	tracer := &testTracer{}
+	c := testRESTClient(t, testServer)
+	c.tracer = tracer
+	if _, err := c.Get().Resource("pods").MaxRetries(0).DoRaw(context.Background()); err == nil {
+		t.Fatalf("expected an error")
+	}
+	results := tracer.results()
+	if len(results) != 1 || results[0].Err == nil || results[0].StatusCode != 0 {
+		t.Errorf("unexpected span results: %#v", results)
+	}
+}
+
+func TestWatchAndStreamTracing(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	defer testServer.Close()
+
+	tracer := &testTracer{}
+	c := testRESTClient(t, testServer)
+	c.tracer = tracer
+
+	expectEnded := func(n int) {
+		t.Helper()
+		err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+			return len(tracer.results()) == n, nil
+		})
+		if err != nil {
+			t.Fatalf("expected %d ended spans, got %d", n, len(tracer.results()))
+		}
+	}
+
+	w, err := c.Get().Resource("pods").Param("watch", "true").Watch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(tracer.results()); n != 0 {
+		t.Fatalf("expected the span of an open watch not to end, got %d ended spans", n)
+	}
+	w.Stop()
+	expectEnded(1)
+
+	body, err := c.Get().Resource("pods").Stream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(tracer.results()); n != 1 {
+		t.Fatalf("expected the span of an open stream not to end, got %d ended spans", n)
+	}
+	body.Close()
+	expectEnded(2)
+
+	for _, result := range tracer.results() {
+		if result.StatusCode != http.StatusOK || result.Err != nil {
+			t.Errorf("unexpected span result: %#v", result)
+		}
+	}
+}
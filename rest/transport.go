@@ -44,7 +44,11 @@ func TransportFor(config *Config) (http.RoundTripper, error) {
 	if err != nil {
 		return nil, err
 	}
-	return transport.New(cfg)
+	rt, err := transport.New(cfg)
+	if err != nil || len(config.FailoverHosts) == 0 {
+		return rt, err
+	}
+	return newFailoverRoundTripper(config, rt)
 }
 
 // HTTPWrappersForConfig wraps a round tripper with any relevant layered behavior from the
@@ -76,6 +80,8 @@ func (c *Config) TransportConfig() (*transport.Config, error) {
 			KeyFile:    c.KeyFile,
 			KeyData:    c.KeyData,
 			NextProtos: c.NextProtos,
+
+			ReloadCAFile: c.ReloadCAFile,
 		},
 		Username:        c.Username,
 		Password:        c.Password,
@@ -83,11 +89,18 @@ func (c *Config) TransportConfig() (*transport.Config, error) {
 		BearerTokenFile: c.BearerTokenFile,
 		Impersonate: transport.ImpersonationConfig{
 			UserName: c.Impersonate.UserName,
+			UID:      c.Impersonate.UID,
 			Groups:   c.Impersonate.Groups,
 			Extra:    c.Impersonate.Extra,
 		},
-		Dial:  c.Dial,
-		Proxy: c.Proxy,
+		Dial:                        c.Dial,
+		Proxy:                       c.Proxy,
+		HTTP2ReadIdleTimeout:        c.HTTP2ReadIdleTimeout,
+		HTTP2PingTimeout:            c.HTTP2PingTimeout,
+		CoalesceRequests:            c.CoalesceRequests,
+		MaxCoalescedResponseBytes:   c.MaxResponseBytes,
+		InFlightLimiter:             c.InFlightLimiter,
+		RequestCompressionThreshold: c.RequestCompressionThreshold,
 	}
 
 	if c.ExecProvider != nil && c.AuthProvider != nil {
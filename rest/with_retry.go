@@ -62,7 +62,8 @@ type WithRetry interface {
 	//  - we have already reached the maximum retry threshold.
 	//  - the error does not fall into the retryable category.
 	//  - the server has not sent us a 429, or 5xx status code and the
-	//    'Retry-After' response header is not set with a value.
+	//    'Retry-After' response header is not set with a value, and either
+	//    there is no RetryPolicy or it does not want to retry.
 	//
 	// if retry is set to true, retryAfter will contain the information
 	// regarding the next retry.
@@ -83,6 +84,8 @@ type WithRetry interface {
 	//   it fails to do so.
 	// - we should wait the number of seconds the server has asked us to
 	//   in the 'Retry-After' response header.
+	// - if the RetryPolicy asked for the retry, the function should return
+	//   an error if the request body can not be sent again.
 	//
 	// If BeforeNextRetry returns an error the client should abort the retry,
 	// otherwise it is safe to initiate the next retry.
@@ -107,6 +110,15 @@ type RetryAfter struct {
 type withRetry struct {
 	maxRetries int
 	attempts   int
+
+	// policy is consulted when the server has not asked us to retry with a
+	// 'Retry-After' response header, it is still bound by maxRetries.
+	policy RetryPolicy
+	// firstFailure is when NextRetry was first called.
+	firstFailure time.Time
+	// fromPolicy is set when the last call to NextRetry decided to retry
+	// because of the RetryPolicy rather than the server.
+	fromPolicy bool
 }
 
 func (r *withRetry) SetMaxRetries(maxRetries int) {
@@ -123,6 +135,10 @@ func (r *withRetry) NextRetry(req *http.Request, resp *http.Response, err error,
 	}
 
 	r.attempts++
+	r.fromPolicy = false
+	if r.firstFailure.IsZero() {
+		r.firstFailure = time.Now()
+	}
 	retryAfter := &RetryAfter{Attempt: r.attempts}
 	if r.attempts > r.maxRetries {
 		return retryAfter, false
@@ -137,7 +153,7 @@ func (r *withRetry) NextRetry(req *http.Request, resp *http.Response, err error,
 		resp = retryAfterResponse()
 	}
 	if err != nil && !errIsRetryable {
-		return retryAfter, false
+		return r.nextRetryFromPolicy(req, resp, err, retryAfter)
 	}
 
 	// if we are here, we have either a or b:
@@ -147,7 +163,7 @@ func (r *withRetry) NextRetry(req *http.Request, resp *http.Response, err error,
 	//     need to check if it is retryable
 	seconds, wait := checkWait(resp)
 	if !wait {
-		return retryAfter, false
+		return r.nextRetryFromPolicy(req, resp, err, retryAfter)
 	}
 
 	retryAfter.Wait = time.Duration(seconds) * time.Second
@@ -155,6 +171,23 @@ func (r *withRetry) NextRetry(req *http.Request, resp *http.Response, err error,
 	return retryAfter, true
 }
 
+// nextRetryFromPolicy asks the RetryPolicy, if any, whether a request the
+// server has not asked us to retry should be retried.
+func (r *withRetry) nextRetryFromPolicy(req *http.Request, resp *http.Response, err error, retryAfter *RetryAfter) (*RetryAfter, bool) {
+	if r.policy == nil {
+		return retryAfter, false
+	}
+
+	wait, retry := r.policy.ShouldRetry(req, resp, err, r.attempts, time.Since(r.firstFailure))
+	if !retry {
+		return retryAfter, false
+	}
+	retryAfter.Wait = wait
+	retryAfter.Reason = getRetryPolicyReason(r.attempts, wait, resp, err)
+	r.fromPolicy = true
+	return retryAfter, true
+}
+
 func (r *withRetry) BeforeNextRetry(ctx context.Context, backoff BackoffManager, retryAfter *RetryAfter, url string, body io.Reader) error {
 	// Ensure the response body is fully read and closed before
 	// we reconnect, so that we reuse the same TCP connection.
@@ -166,6 +199,10 @@ func (r *withRetry) BeforeNextRetry(ctx context.Context, backoff BackoffManager,
 		if _, err := seeker.Seek(0, 0); err != nil {
 			return fmt.Errorf("can't Seek() back to beginning of body for %T", r)
 		}
+	} else if body != nil && r.fromPolicy {
+		// the server did not ask for this retry, and might have consumed
+		// the body already, so never send a truncated body.
+		return fmt.Errorf("can't replay request body of type %T", body)
 	}
 
 	klog.V(4).Infof("Got a Retry-After %s response for attempt %d to %v", retryAfter.Wait, retryAfter.Attempt, url)
@@ -208,6 +245,14 @@ func getRetryReason(retries, seconds int, resp *http.Response, err error) string
 	}
 }
 
+func getRetryPolicyReason(retries int, wait time.Duration, resp *http.Response, err error) string {
+	message := fmt.Sprintf("retries: %d, backoff: %v", retries, wait)
+	if err != nil {
+		return fmt.Sprintf("%s - retry-reason: retry policy, error: %v", message, err)
+	}
+	return fmt.Sprintf("%s - retry-reason: retry policy, status code: %d", message, resp.StatusCode)
+}
+
 func readAndCloseResponseBody(resp *http.Response) {
 	if resp == nil {
 		return
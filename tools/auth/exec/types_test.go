@@ -89,6 +89,8 @@ func TestV1beta1ClusterTypesAreSynced(t *testing.T) {
 			"CertificateAuthority",
 			// Cluster uses Config to provide its cluster-specific configuration object.
 			"Extensions",
+			// Credentials are for the cluster as a whole, Server is enough to identify it.
+			"FailoverServers",
 		)
 
 		for i := 0; i < clientcmdType.NumField(); i++ {
@@ -63,6 +63,10 @@ type Preferences struct {
 type Cluster struct {
 	// Server is the address of the kubernetes cluster (https://hostname:port).
 	Server string `json:"server"`
+	// FailoverServers are further addresses of the same kubernetes cluster, that are used in order when Server cannot be reached.
+	// They must present certificates signed by the same certificate authority as Server.
+	// +optional
+	FailoverServers []string `json:"failover-servers,omitempty"`
 	// TLSServerName is used to check server certificate. If TLSServerName is empty, the hostname used to contact the server is used.
 	// +optional
 	TLSServerName string `json:"tls-server-name,omitempty"`
@@ -81,9 +85,6 @@ type Cluster struct {
 	// attempts to construct a proxy configuration from http_proxy and
 	// https_proxy environment variables. If these environment variables are not
 	// set, the client does not attempt to proxy requests.
-	//
-	// socks5 proxying does not currently support spdy streaming endpoints (exec,
-	// attach, port forward).
 	// +optional
 	ProxyURL string `json:"proxy-url,omitempty"`
 	// Extensions holds additional information. This is useful for extenders so that reads and writes don't clobber unknown fields
@@ -157,6 +157,7 @@ func (config *DirectClientConfig) ClientConfig() (*restclient.Config, error) {
 
 	clientConfig := &restclient.Config{}
 	clientConfig.Host = configClusterInfo.Server
+	clientConfig.FailoverHosts = configClusterInfo.FailoverServers
 	if configClusterInfo.ProxyURL != "" {
 		u, err := parseProxyURL(configClusterInfo.ProxyURL)
 		if err != nil {
@@ -529,6 +530,10 @@ func (config *DirectClientConfig) getCluster() (clientcmdapi.Cluster, error) {
 		if config.overrides.ClusterInfo.TLSServerName != "" || config.overrides.ClusterInfo.Server != "" {
 			mergedClusterInfo.TLSServerName = config.overrides.ClusterInfo.TLSServerName
 		}
+		// the failover servers of the kubeconfig belong to its server, so they do not apply to a --server set on the command line.
+		if config.overrides.ClusterInfo.Server != "" {
+			mergedClusterInfo.FailoverServers = config.overrides.ClusterInfo.FailoverServers
+		}
 	}
 
 	return *mergedClusterInfo, nil
@@ -331,6 +331,48 @@ func TestCertificateData(t *testing.T) {
 	matchByteArg(keyData, clientConfig.TLSClientConfig.KeyData, t)
 }
 
+func TestFailoverServers(t *testing.T) {
+	tests := []struct {
+		desc     string
+		server   string
+		expected []string
+	}{
+		{
+			desc:     "failover servers of the cluster",
+			expected: []string{"https://localhost:8444", "https://localhost:8445"},
+		},
+		{
+			desc:   "server override",
+			server: "https://example.com",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := clientcmdapi.NewConfig()
+			config.Clusters["clean"] = &clientcmdapi.Cluster{
+				Server:          "https://localhost:8443",
+				FailoverServers: []string{"https://localhost:8444", "https://localhost:8445"},
+			}
+			config.AuthInfos["clean"] = &clientcmdapi.AuthInfo{}
+			config.Contexts["clean"] = &clientcmdapi.Context{
+				Cluster:  "clean",
+				AuthInfo: "clean",
+			}
+			config.CurrentContext = "clean"
+
+			overrides := &ConfigOverrides{ClusterInfo: clientcmdapi.Cluster{Server: test.server}}
+			clientConfig, err := NewNonInteractiveClientConfig(*config, "clean", overrides, nil).ClientConfig()
+			if err != nil {
+				t.Fatalf("Unexpected error constructing config: %v", err)
+			}
+			if !reflect.DeepEqual(clientConfig.FailoverHosts, test.expected) {
+				t.Errorf("Expected failover hosts %v, got %v", test.expected, clientConfig.FailoverHosts)
+			}
+		})
+	}
+}
+
 func TestProxyURL(t *testing.T) {
 	tests := []struct {
 		desc      string
@@ -0,0 +1,314 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// Event types the controller records in the metadata of the keys it adds to
+// its queue, see workqueue.ItemMetadata.
+const (
+	EventTypeAdd    = "add"
+	EventTypeUpdate = "update"
+	EventTypeDelete = "delete"
+)
+
+// ReconcileFunc brings the object identified by key to its desired state. ctx
+// is canceled once the shutdown grace period of a stopped controller has
+// passed.
+//
+// If it returns an error, key is requeued with the rate limiter of the
+// controller. Otherwise, if requeueAfter is positive, key is processed again
+// after that duration.
+type ReconcileFunc func(ctx context.Context, key string) (requeueAfter time.Duration, err error)
+
+// Config configures a Controller.
+type Config struct {
+	// Name identifies the controller in logs and metrics. Required.
+	Name string
+
+	// Informers whose events trigger reconciles. The controller registers an
+	// event handler with each of them and waits for all of them to sync
+	// before it starts reconciling, but it doesn't start them.
+	Informers []cache.SharedInformer
+
+	// KeyFunc turns the objects delivered by the informers into the keys
+	// passed to Reconcile. Defaults to
+	// cache.DeletionHandlingMetaNamespaceKeyFunc.
+	KeyFunc cache.KeyFunc
+
+	// Reconcile is called for every key. Required.
+	Reconcile ReconcileFunc
+
+	// Workers is the number of keys reconciled concurrently. Defaults to 1.
+	Workers int
+
+	// RateLimiter decides how long a key waits before it is retried after a
+	// failed reconcile. Defaults to workqueue.DefaultControllerRateLimiter.
+	RateLimiter workqueue.RateLimiter
+
+	// MaxRetries is the number of times a key is retried after consecutive
+	// failed reconciles before it is dropped. Zero means retry forever.
+	MaxRetries int
+
+	// ShutdownGracePeriod is how long the keys that are queued or being
+	// reconciled when the controller is stopped are still reconciled. Once it
+	// has passed, the context passed to Reconcile is canceled and the keys
+	// left are dropped. Defaults to 30 seconds.
+	ShutdownGracePeriod time.Duration
+}
+
+// defaultShutdownGracePeriod is the default of Config.ShutdownGracePeriod.
+const defaultShutdownGracePeriod = 30 * time.Second
+
+// Controller reconciles the keys of the objects of a set of informers. Create
+// one with New.
+type Controller struct {
+	name       string
+	informers  []cache.SharedInformer
+	keyFunc    cache.KeyFunc
+	reconcile  ReconcileFunc
+	workers    int
+	maxRetries int
+
+	shutdownGracePeriod time.Duration
+
+	queue   queue
+	metrics *controllerMetrics
+}
+
+// queue is the work queue of a Controller.
+type queue interface {
+	workqueue.ErrorRateLimitingInterface
+	workqueue.MetadataInterface
+}
+
+// New creates a Controller from config and registers its event handlers with
+// the informers.
+func New(config Config) (*Controller, error) {
+	if len(config.Name) == 0 {
+		return nil, fmt.Errorf("controller name must be set")
+	}
+	if config.Reconcile == nil {
+		return nil, fmt.Errorf("controller %s: Reconcile must be set", config.Name)
+	}
+	if config.MaxRetries < 0 {
+		return nil, fmt.Errorf("controller %s: MaxRetries must not be negative", config.Name)
+	}
+	if config.ShutdownGracePeriod < 0 {
+		return nil, fmt.Errorf("controller %s: ShutdownGracePeriod must not be negative", config.Name)
+	}
+
+	c := &Controller{
+		name:       config.Name,
+		informers:  config.Informers,
+		keyFunc:    config.KeyFunc,
+		reconcile:  config.Reconcile,
+		workers:    config.Workers,
+		maxRetries: config.MaxRetries,
+		metrics:    globalMetricsFactory.newControllerMetrics(config.Name),
+
+		shutdownGracePeriod: config.ShutdownGracePeriod,
+	}
+	if c.shutdownGracePeriod == 0 {
+		c.shutdownGracePeriod = defaultShutdownGracePeriod
+	}
+	if c.keyFunc == nil {
+		c.keyFunc = cache.DeletionHandlingMetaNamespaceKeyFunc
+	}
+	if c.workers < 1 {
+		c.workers = 1
+	}
+	rateLimiter := config.RateLimiter
+	if rateLimiter == nil {
+		rateLimiter = workqueue.DefaultControllerRateLimiter()
+	}
+	// rate limiting queues take errors and metadata into account.
+	c.queue = workqueue.NewNamedRateLimitingQueue(rateLimiter, config.Name).(queue)
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueue(obj, EventTypeAdd)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			c.enqueue(new, EventTypeUpdate)
+		},
+		DeleteFunc: func(obj interface{}) {
+			c.enqueue(obj, EventTypeDelete)
+		},
+	}
+	for _, informer := range c.informers {
+		informer.AddEventHandler(handler)
+	}
+	return c, nil
+}
+
+// Enqueue adds the key of obj to the queue of the controller, for callers that
+// want to trigger reconciles from sources other than the informers.
+func (c *Controller) Enqueue(obj interface{}) {
+	key, err := c.keyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("controller %s: couldn't get key for object %+v: %v", c.name, obj, err))
+		return
+	}
+	c.queue.Add(key)
+}
+
+// EnqueueAfter adds key to the queue of the controller after duration has
+// passed.
+func (c *Controller) EnqueueAfter(key string, duration time.Duration) {
+	c.queue.AddAfter(key, duration)
+}
+
+func (c *Controller) enqueue(obj interface{}, eventType string) {
+	key, err := c.keyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("controller %s: couldn't get key for object %+v: %v", c.name, obj, err))
+		return
+	}
+	c.queue.AddWithMetadata(key, workqueue.ItemMetadata{EventType: eventType})
+}
+
+// Run waits for the caches of the informers to sync and then reconciles keys
+// until ctx is canceled. Once ctx is canceled, no new keys are queued, and the
+// keys that are queued or being reconciled are still reconciled, with a
+// context that is only canceled after the shutdown grace period. Run returns
+// once they are done, or were dropped after the grace period. It returns an
+// error if the caches failed to sync. A Controller can only be run once.
+func (c *Controller) Run(ctx context.Context) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	klog.Infof("Starting controller %s", c.name)
+	defer klog.Infof("Shutting down controller %s", c.name)
+
+	hasSynced := make([]cache.InformerSynced, 0, len(c.informers))
+	for _, informer := range c.informers {
+		hasSynced = append(hasSynced, informer.HasSynced)
+	}
+	if !cache.WaitForNamedCacheSync(c.name, ctx.Done(), hasSynced...) {
+		return fmt.Errorf("controller %s: caches did not sync", c.name)
+	}
+
+	// the workers outlive ctx to drain the queue.
+	workerCtx, cancelWorkers := context.WithCancel(detachedContext{ctx})
+	defer cancelWorkers()
+	wg := sync.WaitGroup{}
+	wg.Add(c.workers)
+	for i := 0; i < c.workers; i++ {
+		go func() {
+			defer wg.Done()
+			for c.processNextWorkItem(workerCtx) {
+			}
+		}()
+	}
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	<-ctx.Done()
+	c.queue.ShutDown()
+	grace := time.NewTimer(c.shutdownGracePeriod)
+	defer grace.Stop()
+	select {
+	case <-drained:
+	case <-grace.C:
+		klog.Infof("Controller %s did not drain its queue within %v, canceling the reconciles in flight", c.name, c.shutdownGracePeriod)
+		cancelWorkers()
+		<-drained
+	}
+	return nil
+}
+
+// detachedContext carries the values of its parent context, but is not
+// canceled with it.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// processNextWorkItem reconciles a single key from the queue. It returns false
+// when the queue has been shut down and drained.
+func (c *Controller) processNextWorkItem(ctx context.Context) bool {
+	obj, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(obj)
+
+	if ctx.Err() != nil {
+		// The shutdown grace period has passed, drop the key.
+		return true
+	}
+
+	key := obj.(string)
+	requeueAfter, err := c.reconcileHandler(ctx, key)
+	switch {
+	case err != nil:
+		if c.maxRetries > 0 && c.queue.NumRequeues(key) >= c.maxRetries {
+			c.queue.Forget(key)
+			utilruntime.HandleError(fmt.Errorf("controller %s: dropping %q out of the queue after %d retries: %v", c.name, key, c.maxRetries, err))
+			return true
+		}
+		utilruntime.HandleError(fmt.Errorf("controller %s: error syncing %q, requeuing: %v", c.name, key, err))
+		c.queue.AddRateLimitedWithError(key, err)
+	case requeueAfter > 0:
+		c.queue.Forget(key)
+		c.queue.AddAfter(key, requeueAfter)
+	default:
+		c.queue.Forget(key)
+	}
+	return true
+}
+
+// reconcileHandler calls the reconcile function, turning a panic into an error
+// so that a single bad key can't take the whole process down.
+func (c *Controller) reconcileHandler(ctx context.Context, key string) (requeueAfter time.Duration, err error) {
+	c.metrics.activeWorkers.Inc()
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			c.metrics.panics.Inc()
+			klog.Errorf("Observed a panic in controller %s reconciling %q: %v\n%s", c.name, key, r, debug.Stack())
+			requeueAfter, err = 0, fmt.Errorf("panic: %v", r)
+		}
+		c.metrics.activeWorkers.Dec()
+		c.metrics.duration.Observe(time.Since(start).Seconds())
+		c.metrics.reconciles.Inc()
+		if err != nil {
+			c.metrics.errors.Inc()
+		}
+	}()
+
+	return c.reconcile(ctx, key)
+}
@@ -0,0 +1,312 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	fcache "k8s.io/client-go/tools/cache/testing"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func newPod(name string) *v1.Pod {
+	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"}}
+}
+
+// testController runs a Controller for a single fake pod informer.
+type testController struct {
+	*Controller
+	source *fcache.FakeControllerSource
+
+	cancel context.CancelFunc
+	stopCh chan struct{}
+	runErr chan error
+}
+
+func newTestController(t *testing.T, reconcile ReconcileFunc, maxRetries int) *testController {
+	return newTestControllerForConfig(t, Config{
+		Reconcile:  reconcile,
+		Workers:    2,
+		MaxRetries: maxRetries,
+	})
+}
+
+// newTestControllerForConfig runs a controller for config, with a fake pod
+// informer and a fast rate limiter.
+func newTestControllerForConfig(t *testing.T, config Config) *testController {
+	source := fcache.NewFakeControllerSource()
+	informer := cache.NewSharedInformer(source, &v1.Pod{}, 0)
+	config.Name = "test"
+	config.Informers = []cache.SharedInformer{informer}
+	config.RateLimiter = workqueue.NewItemFastSlowRateLimiter(time.Millisecond, time.Millisecond, 0)
+	c, err := New(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tc := &testController{
+		Controller: c,
+		source:     source,
+		cancel:     cancel,
+		stopCh:     make(chan struct{}),
+		runErr:     make(chan error, 1),
+	}
+	go informer.Run(tc.stopCh)
+	go func() {
+		tc.runErr <- c.Run(ctx)
+	}()
+	return tc
+}
+
+func (tc *testController) stop(t *testing.T) {
+	tc.cancel()
+	select {
+	case err := <-tc.runErr:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Errorf("controller did not stop")
+	}
+	close(tc.stopCh)
+}
+
+// keyCounter counts the reconciles of every key.
+type keyCounter struct {
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+func (k *keyCounter) inc(key string) int {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.counts == nil {
+		k.counts = map[string]int{}
+	}
+	k.counts[key]++
+	return k.counts[key]
+}
+
+func (k *keyCounter) get(key string) int {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.counts[key]
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	if err := wait.PollImmediate(time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return condition(), nil
+	}); err != nil {
+		t.Fatalf("condition not met: %v", err)
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	reconcile := func(ctx context.Context, key string) (time.Duration, error) { return 0, nil }
+	for name, config := range map[string]Config{
+		"no name":             {Reconcile: reconcile},
+		"no reconcile":        {Name: "test"},
+		"negative maxRetries": {Name: "test", Reconcile: reconcile, MaxRetries: -1},
+		"negative grace":      {Name: "test", Reconcile: reconcile, ShutdownGracePeriod: -1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := New(config); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	counter := &keyCounter{}
+	tc := newTestController(t, func(ctx context.Context, key string) (time.Duration, error) {
+		counter.inc(key)
+		return 0, nil
+	}, 0)
+	defer tc.stop(t)
+
+	tc.source.Add(newPod("foo"))
+	tc.source.Add(newPod("bar"))
+	waitFor(t, func() bool { return counter.get("ns/foo") == 1 && counter.get("ns/bar") == 1 })
+
+	tc.source.Delete(newPod("foo"))
+	waitFor(t, func() bool { return counter.get("ns/foo") == 2 })
+
+	tc.Enqueue(newPod("baz"))
+	waitFor(t, func() bool { return counter.get("ns/baz") == 1 })
+}
+
+func TestReconcileErrorRetries(t *testing.T) {
+	counter := &keyCounter{}
+	tc := newTestController(t, func(ctx context.Context, key string) (time.Duration, error) {
+		if counter.inc(key) < 3 {
+			return 0, errors.New("boom")
+		}
+		return 0, nil
+	}, 0)
+	defer tc.stop(t)
+
+	tc.source.Add(newPod("foo"))
+	waitFor(t, func() bool { return counter.get("ns/foo") == 3 })
+	waitFor(t, func() bool { return tc.queue.NumRequeues("ns/foo") == 0 })
+}
+
+func TestReconcileMaxRetries(t *testing.T) {
+	counter := &keyCounter{}
+	tc := newTestController(t, func(ctx context.Context, key string) (time.Duration, error) {
+		counter.inc(key)
+		return 0, errors.New("boom")
+	}, 2)
+	defer tc.stop(t)
+
+	tc.source.Add(newPod("foo"))
+	waitFor(t, func() bool { return counter.get("ns/foo") == 3 })
+	time.Sleep(50 * time.Millisecond)
+	if e, a := 3, counter.get("ns/foo"); e != a {
+		t.Errorf("expected %d reconciles, got %d", e, a)
+	}
+}
+
+func TestReconcilePanic(t *testing.T) {
+	counter := &keyCounter{}
+	tc := newTestController(t, func(ctx context.Context, key string) (time.Duration, error) {
+		if counter.inc(key) == 1 {
+			panic("boom")
+		}
+		return 0, nil
+	}, 0)
+	defer tc.stop(t)
+
+	tc.source.Add(newPod("foo"))
+	waitFor(t, func() bool { return counter.get("ns/foo") == 2 })
+}
+
+func TestReconcileRequeueAfter(t *testing.T) {
+	counter := &keyCounter{}
+	tc := newTestController(t, func(ctx context.Context, key string) (time.Duration, error) {
+		if counter.inc(key) == 1 {
+			return 10 * time.Millisecond, nil
+		}
+		return 0, nil
+	}, 0)
+	defer tc.stop(t)
+
+	tc.source.Add(newPod("foo"))
+	waitFor(t, func() bool { return counter.get("ns/foo") == 2 })
+}
+
+func TestRunDrainsInFlightReconciles(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	counter := &keyCounter{}
+	var reconcileErr error
+	tc := newTestControllerForConfig(t, Config{
+		Reconcile: func(ctx context.Context, key string) (time.Duration, error) {
+			if counter.inc(key) == 1 && key == "ns/foo" {
+				close(started)
+				<-release
+				reconcileErr = ctx.Err()
+			}
+			return 0, nil
+		},
+		Workers: 1,
+	})
+
+	tc.source.Add(newPod("foo"))
+	<-started
+	// bar is queued behind foo on the single worker.
+	tc.source.Add(newPod("bar"))
+	waitFor(t, func() bool { return tc.queue.Len() == 1 })
+	tc.cancel()
+
+	select {
+	case <-tc.runErr:
+		t.Fatalf("Run returned while a reconcile was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	// stop waits for Run to return.
+	tc.stop(t)
+	if reconcileErr != nil {
+		t.Errorf("the context of the reconcile in flight was canceled: %v", reconcileErr)
+	}
+	if n := counter.get("ns/bar"); n != 1 {
+		t.Errorf("expected the queued key to be reconciled once, got %d", n)
+	}
+}
+
+func TestRunShutdownGracePeriod(t *testing.T) {
+	started := make(chan struct{})
+	counter := &keyCounter{}
+	tc := newTestControllerForConfig(t, Config{
+		Reconcile: func(ctx context.Context, key string) (time.Duration, error) {
+			if counter.inc(key) == 1 && key == "ns/foo" {
+				close(started)
+				<-ctx.Done()
+			}
+			return 0, nil
+		},
+		Workers:             1,
+		ShutdownGracePeriod: 50 * time.Millisecond,
+	})
+
+	tc.source.Add(newPod("foo"))
+	<-started
+	tc.source.Add(newPod("bar"))
+	waitFor(t, func() bool { return tc.queue.Len() == 1 })
+
+	// stop returns once the grace period has canceled the reconcile of foo.
+	tc.stop(t)
+	if n := counter.get("ns/bar"); n != 0 {
+		t.Errorf("expected the queued key to be dropped, got %d reconciles", n)
+	}
+}
+
+func TestRunWaitsForCacheSync(t *testing.T) {
+	source := fcache.NewFakeControllerSource()
+	// The informer is never started, so its cache never syncs.
+	informer := cache.NewSharedInformer(source, &v1.Pod{}, 0)
+	c, err := New(Config{
+		Name:      "test",
+		Informers: []cache.SharedInformer{informer},
+		Reconcile: func(ctx context.Context, key string) (time.Duration, error) {
+			t.Errorf("unexpected reconcile of %q", key)
+			return 0, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.Enqueue(newPod("foo"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := c.Run(ctx); err == nil {
+		t.Errorf("expected an error")
+	}
+}
@@ -0,0 +1,27 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package controller provides a reusable skeleton for controllers that
+// react to informer events by reconciling objects by key.
+//
+// A Controller wires a set of shared informers to a rate limited workqueue
+// and runs a pool of workers that call a ReconcileFunc for every key. It
+// takes care of the parts that are easy to get subtly wrong: waiting for
+// the informer caches to sync before processing anything, calling Forget
+// and Done on the queue, requeueing with backoff on errors, recovering
+// from panics in the reconcile function, and letting in-flight reconciles
+// finish when the controller is stopped.
+package controller // import "k8s.io/client-go/tools/controller"
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// This file provides abstractions for setting the provider (e.g., prometheus)
+// of metrics. The queue of every controller is named after the controller, so
+// its metrics are reported through the provider set with
+// workqueue.SetProvider.
+
+type noopMetric struct{}
+
+func (noopMetric) Inc()            {}
+func (noopMetric) Dec()            {}
+func (noopMetric) Observe(float64) {}
+
+// controllerMetrics records the outcome of the reconciles of a controller.
+type controllerMetrics struct {
+	// total number of reconciles
+	reconciles workqueue.CounterMetric
+	// number of reconciles that returned an error or panicked
+	errors workqueue.CounterMetric
+	// number of reconciles that panicked
+	panics workqueue.CounterMetric
+	// how long reconciles take, in seconds
+	duration workqueue.HistogramMetric
+	// number of workers currently running a reconcile
+	activeWorkers workqueue.GaugeMetric
+}
+
+// MetricsProvider generates various metrics used by controllers.
+type MetricsProvider interface {
+	NewReconcilesMetric(name string) workqueue.CounterMetric
+	NewReconcileErrorsMetric(name string) workqueue.CounterMetric
+	NewReconcilePanicsMetric(name string) workqueue.CounterMetric
+	NewReconcileDurationMetric(name string) workqueue.HistogramMetric
+	NewActiveWorkersMetric(name string) workqueue.GaugeMetric
+}
+
+type noopMetricsProvider struct{}
+
+func (noopMetricsProvider) NewReconcilesMetric(name string) workqueue.CounterMetric {
+	return noopMetric{}
+}
+
+func (noopMetricsProvider) NewReconcileErrorsMetric(name string) workqueue.CounterMetric {
+	return noopMetric{}
+}
+
+func (noopMetricsProvider) NewReconcilePanicsMetric(name string) workqueue.CounterMetric {
+	return noopMetric{}
+}
+
+func (noopMetricsProvider) NewReconcileDurationMetric(name string) workqueue.HistogramMetric {
+	return noopMetric{}
+}
+
+func (noopMetricsProvider) NewActiveWorkersMetric(name string) workqueue.GaugeMetric {
+	return noopMetric{}
+}
+
+var globalMetricsFactory = controllerMetricsFactory{
+	metricsProvider: noopMetricsProvider{},
+}
+
+type controllerMetricsFactory struct {
+	metricsProvider MetricsProvider
+
+	onlyOnce sync.Once
+}
+
+func (f *controllerMetricsFactory) setProvider(mp MetricsProvider) {
+	f.onlyOnce.Do(func() {
+		f.metricsProvider = mp
+	})
+}
+
+func (f *controllerMetricsFactory) newControllerMetrics(name string) *controllerMetrics {
+	mp := f.metricsProvider
+	return &controllerMetrics{
+		reconciles:    mp.NewReconcilesMetric(name),
+		errors:        mp.NewReconcileErrorsMetric(name),
+		panics:        mp.NewReconcilePanicsMetric(name),
+		duration:      mp.NewReconcileDurationMetric(name),
+		activeWorkers: mp.NewActiveWorkersMetric(name),
+	}
+}
+
+// SetProvider sets the metrics provider for all subsequently created
+// controllers. Only the first call has an effect.
+func SetProvider(metricsProvider MetricsProvider) {
+	globalMetricsFactory.setProvider(metricsProvider)
+}
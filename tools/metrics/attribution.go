@@ -0,0 +1,42 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import "context"
+
+type attributionKey struct{}
+
+// WithAttribution returns a copy of ctx that attributes the requests sent
+// with it to label, like the name of a component or controller. Rest clients
+// pass the context of a request to RequestLatency and RequestResult, whose
+// implementations can read the label with AttributionFrom to partition their
+// observations by caller. Since the label becomes a metric label, it should
+// be taken from a small set of values: a reconcile key is better attached
+// only for debugging. The label replaces any label ctx already carries.
+func WithAttribution(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, attributionKey{}, label)
+}
+
+// AttributionFrom returns the label the requests sent with ctx are
+// attributed to, or an empty string if there is none.
+func AttributionFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	label, _ := ctx.Value(attributionKey{}).(string)
+	return label
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAttribution(t *testing.T) {
+	ctx := context.Background()
+	if label := AttributionFrom(ctx); label != "" {
+		t.Errorf("expected no attribution, got %q", label)
+	}
+	ctx = WithAttribution(ctx, "deployment-controller")
+	if label := AttributionFrom(ctx); label != "deployment-controller" {
+		t.Errorf("expected deployment-controller, got %q", label)
+	}
+	ctx, cancel := context.WithCancel(WithAttribution(ctx, "replicaset-controller"))
+	defer cancel()
+	if label := AttributionFrom(ctx); label != "replicaset-controller" {
+		t.Errorf("expected the label to be replaced, got %q", label)
+	}
+}
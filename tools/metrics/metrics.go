@@ -47,6 +47,59 @@ type ResultMetric interface {
 	Increment(ctx context.Context, code string, method string, host string)
 }
 
+// RetryMetric counts the number of retries sent to the server
+// partitioned by code, method, and host.
+type RetryMetric interface {
+	IncrementRetry(ctx context.Context, code string, method string, host string)
+}
+
+// CircuitBreakerStateMetric sets the state of a circuit breaker partitioned
+// by host and group/resource.
+type CircuitBreakerStateMetric interface {
+	Set(host string, resource string, state string)
+}
+
+// CircuitBreakerRejectedMetric counts the requests rejected by an open
+// circuit breaker partitioned by host and group/resource.
+type CircuitBreakerRejectedMetric interface {
+	Increment(host string, resource string)
+}
+
+// QueueDepthMetric sets the number of requests waiting in a queue
+// partitioned by the kind of request.
+type QueueDepthMetric interface {
+	Set(requestKind string, depth int)
+}
+
+// QueueWaitMetric observes the time requests waited in a queue partitioned
+// by the kind of request.
+type QueueWaitMetric interface {
+	Observe(requestKind string, wait time.Duration)
+}
+
+// CompressionMetric observes the size of request bodies before and after
+// compression partitioned by host.
+type CompressionMetric interface {
+	Observe(ctx context.Context, host string, uncompressedBytes int, compressedBytes int)
+}
+
+// TransportCacheMetric shows the number of entries in the internal transport cache.
+type TransportCacheMetric interface {
+	Observe(value int)
+}
+
+// TransportCreateCallsMetric counts the number of times a transport is
+// requested partitioned by the result of the cache lookup.
+type TransportCreateCallsMetric interface {
+	Increment(result string)
+}
+
+// TransportConnectionsMetric sets the number of open connections of a cached
+// transport.
+type TransportConnectionsMetric interface {
+	Set(transport string, open int)
+}
+
 // CallsMetric counts calls that take place for a specific exec plugin.
 type CallsMetric interface {
 	// Increment increments a counter per exitCode and callStatus.
@@ -67,16 +120,58 @@ var (
 	// ExecPluginCalls is the number of calls made to an exec plugin, partitioned by
 	// exit code and call status.
 	ExecPluginCalls CallsMetric = noopCalls{}
+	// RequestRetry is the retry metric that tracks the number of
+	// retries sent to the server.
+	RequestRetry RetryMetric = noopRetry{}
+	// CircuitBreakerState is the state of the circuit breakers of rest clients,
+	// one of "closed", "open" or "half-open".
+	CircuitBreakerState CircuitBreakerStateMetric = noopCircuitBreakerState{}
+	// CircuitBreakerRejected is the number of requests that failed fast
+	// because a circuit breaker was open.
+	CircuitBreakerRejected CircuitBreakerRejectedMetric = noopCircuitBreakerRejected{}
+	// InFlightQueueDepth is the number of requests waiting for the in-flight
+	// limit of a client, partitioned by "short" and "long-running" requests.
+	InFlightQueueDepth QueueDepthMetric = noopQueueDepth{}
+	// InFlightQueueWait is the time requests waited for the in-flight limit
+	// of a client, partitioned by "short" and "long-running" requests.
+	InFlightQueueWait QueueWaitMetric = noopQueueWait{}
+	// RequestCompression is the size of compressed request bodies before
+	// and after compression.
+	RequestCompression CompressionMetric = noopCompression{}
+	// RequestCompressionRejected counts requests with a compressed body that
+	// the server rejected, partitioned by code, method and host. They are
+	// sent again uncompressed.
+	RequestCompressionRejected ResultMetric = noopResult{}
+	// TransportCacheEntries is the number of transports in the internal
+	// transport cache.
+	TransportCacheEntries TransportCacheMetric = noopTransportCache{}
+	// TransportCreateCalls is the number of calls to get a transport,
+	// partitioned by the result of the cache: "hit", "miss" or "uncacheable".
+	TransportCreateCalls TransportCreateCallsMetric = noopTransportCreateCalls{}
+	// TransportConnections is the number of open connections of the
+	// transports in the internal transport cache, partitioned by an opaque
+	// identifier of the transport.
+	TransportConnections TransportConnectionsMetric = noopTransportConnections{}
 )
 
 // RegisterOpts contains all the metrics to register. Metrics may be nil.
 type RegisterOpts struct {
-	ClientCertExpiry      ExpiryMetric
-	ClientCertRotationAge DurationMetric
-	RequestLatency        LatencyMetric
-	RateLimiterLatency    LatencyMetric
-	RequestResult         ResultMetric
-	ExecPluginCalls       CallsMetric
+	ClientCertExpiry           ExpiryMetric
+	ClientCertRotationAge      DurationMetric
+	RequestLatency             LatencyMetric
+	RateLimiterLatency         LatencyMetric
+	RequestResult              ResultMetric
+	ExecPluginCalls            CallsMetric
+	RequestRetry               RetryMetric
+	CircuitBreakerState        CircuitBreakerStateMetric
+	CircuitBreakerRejected     CircuitBreakerRejectedMetric
+	InFlightQueueDepth         QueueDepthMetric
+	InFlightQueueWait          QueueWaitMetric
+	RequestCompression         CompressionMetric
+	RequestCompressionRejected ResultMetric
+	TransportCacheEntries      TransportCacheMetric
+	TransportCreateCalls       TransportCreateCallsMetric
+	TransportConnections       TransportConnectionsMetric
 }
 
 // Register registers metrics for the rest client to use. This can
@@ -101,6 +196,36 @@ func Register(opts RegisterOpts) {
 		if opts.ExecPluginCalls != nil {
 			ExecPluginCalls = opts.ExecPluginCalls
 		}
+		if opts.RequestRetry != nil {
+			RequestRetry = opts.RequestRetry
+		}
+		if opts.CircuitBreakerState != nil {
+			CircuitBreakerState = opts.CircuitBreakerState
+		}
+		if opts.CircuitBreakerRejected != nil {
+			CircuitBreakerRejected = opts.CircuitBreakerRejected
+		}
+		if opts.InFlightQueueDepth != nil {
+			InFlightQueueDepth = opts.InFlightQueueDepth
+		}
+		if opts.InFlightQueueWait != nil {
+			InFlightQueueWait = opts.InFlightQueueWait
+		}
+		if opts.RequestCompression != nil {
+			RequestCompression = opts.RequestCompression
+		}
+		if opts.RequestCompressionRejected != nil {
+			RequestCompressionRejected = opts.RequestCompressionRejected
+		}
+		if opts.TransportCacheEntries != nil {
+			TransportCacheEntries = opts.TransportCacheEntries
+		}
+		if opts.TransportCreateCalls != nil {
+			TransportCreateCalls = opts.TransportCreateCalls
+		}
+		if opts.TransportConnections != nil {
+			TransportConnections = opts.TransportConnections
+		}
 	})
 }
 
@@ -123,3 +248,39 @@ func (noopResult) Increment(context.Context, string, string, string) {}
 type noopCalls struct{}
 
 func (noopCalls) Increment(int, string) {}
+
+type noopRetry struct{}
+
+func (noopRetry) IncrementRetry(context.Context, string, string, string) {}
+
+type noopCircuitBreakerState struct{}
+
+func (noopCircuitBreakerState) Set(string, string, string) {}
+
+type noopCircuitBreakerRejected struct{}
+
+func (noopCircuitBreakerRejected) Increment(string, string) {}
+
+type noopQueueDepth struct{}
+
+func (noopQueueDepth) Set(string, int) {}
+
+type noopQueueWait struct{}
+
+func (noopQueueWait) Observe(string, time.Duration) {}
+
+type noopCompression struct{}
+
+func (noopCompression) Observe(context.Context, string, int, int) {}
+
+type noopTransportCache struct{}
+
+func (noopTransportCache) Observe(int) {}
+
+type noopTransportCreateCalls struct{}
+
+func (noopTransportCreateCalls) Increment(string) {}
+
+type noopTransportConnections struct{}
+
+func (noopTransportConnections) Set(string, int) {}
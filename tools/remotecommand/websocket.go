@@ -0,0 +1,186 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecommand
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/websocket"
+	"k8s.io/klog/v2"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/remotecommand"
+	restclient "k8s.io/client-go/rest"
+)
+
+// StreamProtocolV5Name is the WebSocket channel protocol that adds closing a
+// single channel, which allows closing stdin, to v4.channel.k8s.io.
+const StreamProtocolV5Name = "v5.channel.k8s.io"
+
+// webSocketExecutor handles transporting standard shell streams over the
+// channels of a WebSocket connection.
+type webSocketExecutor struct {
+	transport http.RoundTripper
+
+	method    string
+	url       *url.URL
+	protocols []string
+
+	// fallback streams when the server refuses the upgrade, if set.
+	fallback Executor
+}
+
+// NewWebSocketExecutor connects to the provided server and upgrades the
+// connection to a WebSocket, whose channels carry the streams as of the
+// v5.channel.k8s.io or v4.channel.k8s.io protocols. Only v5.channel.k8s.io
+// propagates the end of stdin to the remote command. If the server refuses
+// the upgrade, like servers that do not support WebSockets or are behind
+// proxies that do not, it falls back to the SPDY executor of NewSPDYExecutor.
+// Servers usually only accept WebSocket upgrades of GET requests.
+func NewWebSocketExecutor(config *restclient.Config, method string, url *url.URL) (Executor, error) {
+	transport, err := restclient.TransportFor(config)
+	if err != nil {
+		return nil, err
+	}
+	fallback, err := NewSPDYExecutor(config, method, url)
+	if err != nil {
+		return nil, err
+	}
+	return &webSocketExecutor{
+		transport: transport,
+		method:    method,
+		url:       url,
+		protocols: []string{StreamProtocolV5Name, remotecommand.StreamProtocolV4Name},
+		fallback:  fallback,
+	}, nil
+}
+
+// NewWebSocketExecutorForProtocols connects to the provided server using the
+// given transport and upgrades the connection to a WebSocket using only the
+// provided protocols, without falling back to SPDY. Exposed for testing, most
+// callers should use NewWebSocketExecutor.
+func NewWebSocketExecutorForProtocols(transport http.RoundTripper, method string, url *url.URL, protocols ...string) (Executor, error) {
+	return &webSocketExecutor{
+		transport: transport,
+		method:    method,
+		url:       url,
+		protocols: protocols,
+	}, nil
+}
+
+// Stream opens a WebSocket connection to the server and streams until a
+// client closes the connection or the server disconnects.
+func (e *webSocketExecutor) Stream(options StreamOptions) error {
+	conn, err := e.connect(options)
+	if err != nil {
+		var upgradeErr *upgradeFailureError
+		if e.fallback != nil && errors.As(err, &upgradeErr) {
+			klog.V(4).Infof("The server refused the WebSocket upgrade, falling back to SPDY: %v", err)
+			return e.fallback.Stream(options)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	// both protocols frame the streams of v4.channel.k8s.io.
+	return newStreamProtocolV4(options).stream(conn)
+}
+
+// connect sends the upgrade request, and returns the connection that carries
+// the streams of options.
+func (e *webSocketExecutor) connect(options StreamOptions) (*webSocketConn, error) {
+	config := &websocket.Config{
+		Location: e.url,
+		Origin:   &url.URL{Scheme: e.url.Scheme, Host: e.url.Host},
+		Protocol: e.protocols,
+		Version:  websocket.ProtocolVersionHybi13,
+	}
+	conn := &upgradeConn{upgrade: e.upgrade}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		if err == websocket.ErrBadWebSocketProtocol {
+			return nil, &upgradeFailureError{err: fmt.Errorf("unable to upgrade connection: the server selected the unsupported protocol %q", conn.protocol)}
+		}
+		if _, ok := err.(*websocket.ProtocolError); ok {
+			return nil, fmt.Errorf("unable to upgrade connection: %v", err)
+		}
+		return nil, err
+	}
+	if len(conn.protocol) == 0 {
+		ws.Close()
+		return nil, &upgradeFailureError{err: errors.New("unable to upgrade connection: the server did not select a protocol")}
+	}
+	return newWebSocketConn(ws, conn.protocol, options), nil
+}
+
+// upgrade sends the handshake request of the WebSocket client with the
+// transport, and returns the response and the upgraded connection.
+func (e *webSocketExecutor) upgrade(handshake *http.Request) (*http.Response, io.ReadWriteCloser, error) {
+	req, err := http.NewRequest(e.method, e.url.String(), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header = handshake.Header
+
+	resp, err := (&http.Client{Transport: e.transport}).Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error sending request: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		return nil, nil, &upgradeFailureError{err: upgradeResponseError(resp)}
+	}
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("unable to upgrade connection: the response body of %T is not writable", resp.Body)
+	}
+	return resp, rwc, nil
+}
+
+// upgradeFailureError is returned when the server refuses the WebSocket
+// upgrade.
+type upgradeFailureError struct {
+	err error
+}
+
+func (e *upgradeFailureError) Error() string { return e.err.Error() }
+
+func (e *upgradeFailureError) Unwrap() error { return e.err }
+
+// upgradeResponseError returns the error of a response that is not an
+// upgrade, decoding the Status the server responded with, if any.
+func upgradeResponseError(resp *http.Response) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to upgrade connection: unable to read error from server response")
+	}
+	var status metav1.Status
+	if err := json.Unmarshal(body, &status); err == nil && status.Kind == "Status" {
+		return &apierrors.StatusError{ErrStatus: status}
+	}
+	return fmt.Errorf("unable to upgrade connection: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
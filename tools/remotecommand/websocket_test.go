@@ -0,0 +1,454 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecommand
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/exec"
+)
+
+// webSocketSession is the server side of a remote command streamed over a
+// WebSocket connection.
+type webSocketSession struct {
+	ws       *websocket.Conn
+	protocol string
+
+	// stdin receives the data of the stdin channel, and is closed when the
+	// client closes the channel.
+	stdin chan []byte
+	// resizes receives the terminal sizes of the resize channel.
+	resizes chan TerminalSize
+}
+
+// send sends data on channel.
+func (s *webSocketSession) send(channel byte, data string) error {
+	return websocket.Message.Send(s.ws, append([]byte{channel}, data...))
+}
+
+// exit sends status on the error channel.
+func (s *webSocketSession) exit(status metav1.Status) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return s.send(errorChannel, string(data))
+}
+
+// newWebSocketServer returns a server that accepts WebSocket upgrades with
+// the first of protocols the client supports, and runs command for them.
+func newWebSocketServer(protocols []string, command func(s *webSocketSession)) *httptest.Server {
+	return httptest.NewServer(websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			for _, protocol := range protocols {
+				for _, requested := range config.Protocol {
+					if protocol == requested {
+						config.Protocol = []string{protocol}
+						return nil
+					}
+				}
+			}
+			return errors.New("no supported protocol")
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			ws.PayloadType = websocket.BinaryFrame
+			s := &webSocketSession{
+				ws:       ws,
+				protocol: ws.Config().Protocol[0],
+				stdin:    make(chan []byte, 16),
+				resizes:  make(chan TerminalSize, 16),
+			}
+			go func() {
+				defer close(s.stdin)
+				for {
+					var message []byte
+					if err := websocket.Message.Receive(ws, &message); err != nil || len(message) == 0 {
+						return
+					}
+					switch message[0] {
+					case stdinChannel:
+						s.stdin <- message[1:]
+					case resizeChannel:
+						var size TerminalSize
+						if err := json.Unmarshal(message[1:], &size); err == nil {
+							s.resizes <- size
+						}
+					case closeChannel:
+						if len(message) == 2 && message[1] == stdinChannel {
+							return
+						}
+					}
+				}
+			}()
+			// like the API server, signal the client that the channels are open.
+			for _, channel := range []byte{stdoutChannel, stderrChannel, errorChannel} {
+				s.send(channel, "")
+			}
+			command(s)
+		},
+	})
+}
+
+// streamTo streams options to the server of rawURL with executor.
+func streamTo(t *testing.T, executor func(*url.URL) (Executor, error), rawURL string, options StreamOptions) error {
+	u, _ := url.Parse(rawURL)
+	e, err := executor(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error)
+	go func() {
+		errCh <- e.Stream(options)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(wait.ForeverTestTimeout):
+		return errors.New("execute timeout")
+	}
+}
+
+func webSocketExecutorFor(protocols ...string) func(*url.URL) (Executor, error) {
+	return func(u *url.URL) (Executor, error) {
+		return NewWebSocketExecutorForProtocols(&http.Transport{}, "GET", u, protocols...)
+	}
+}
+
+type fakeTerminalSizeQueue struct {
+	sizes []TerminalSize
+}
+
+func (q *fakeTerminalSizeQueue) Next() *TerminalSize {
+	if len(q.sizes) == 0 {
+		return nil
+	}
+	size := q.sizes[0]
+	q.sizes = q.sizes[1:]
+	return &size
+}
+
+func TestWebSocketExecutorStream(t *testing.T) {
+	// cat echoes stdin to stdout until stdin is closed, which only
+	// v5.channel.k8s.io can signal.
+	cat := func(s *webSocketSession) {
+		for data := range s.stdin {
+			s.send(stdoutChannel, string(data))
+		}
+		s.send(stderrChannel, "done")
+		s.exit(metav1.Status{Status: metav1.StatusSuccess})
+	}
+	// fail reads a line of stdin, and exits with code 3.
+	fail := func(s *webSocketSession) {
+		var line []byte
+		for !bytes.HasSuffix(line, []byte("\n")) {
+			line = append(line, <-s.stdin...)
+		}
+		s.send(stdoutChannel, string(line))
+		s.exit(metav1.Status{
+			Status: metav1.StatusFailure,
+			Reason: remotecommandconsts.NonZeroExitCodeReason,
+			Details: &metav1.StatusDetails{
+				Causes: []metav1.StatusCause{{Type: remotecommandconsts.ExitCodeCauseType, Message: "3"}},
+			},
+		})
+	}
+
+	tests := []struct {
+		name            string
+		serverProtocols []string
+		command         func(*webSocketSession)
+		stdin           string
+		expectStdout    string
+		expectStderr    string
+		expectExitCode  int
+	}{
+		{
+			name:            "v5 closes stdin",
+			serverProtocols: []string{StreamProtocolV5Name, remotecommandconsts.StreamProtocolV4Name},
+			command:         cat,
+			stdin:           "hello\nworld\n",
+			expectStdout:    "hello\nworld\n",
+			expectStderr:    "done",
+		},
+		{
+			name:            "v4",
+			serverProtocols: []string{remotecommandconsts.StreamProtocolV4Name},
+			command:         fail,
+			stdin:           "hello\n",
+			expectStdout:    "hello\n",
+			expectExitCode:  3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newWebSocketServer(test.serverProtocols, test.command)
+			defer server.Close()
+
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			err := streamTo(t, webSocketExecutorFor(StreamProtocolV5Name, remotecommandconsts.StreamProtocolV4Name), server.URL, StreamOptions{
+				Stdin:  strings.NewReader(test.stdin),
+				Stdout: stdout,
+				Stderr: stderr,
+			})
+			if test.expectExitCode == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else {
+				exitErr, ok := err.(exec.CodeExitError)
+				if !ok || exitErr.Code != test.expectExitCode {
+					t.Fatalf("expected exit code %d, got %v", test.expectExitCode, err)
+				}
+			}
+			if stdout.String() != test.expectStdout {
+				t.Errorf("expected stdout %q, got %q", test.expectStdout, stdout.String())
+			}
+			if stderr.String() != test.expectStderr {
+				t.Errorf("expected stderr %q, got %q", test.expectStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestWebSocketExecutorResize(t *testing.T) {
+	var lock sync.Mutex
+	var resizes []TerminalSize
+	server := newWebSocketServer([]string{StreamProtocolV5Name}, func(s *webSocketSession) {
+		for i := 0; i < 2; i++ {
+			size := <-s.resizes
+			lock.Lock()
+			resizes = append(resizes, size)
+			lock.Unlock()
+		}
+		// with a TTY, stderr is sent over stdout.
+		s.send(stdoutChannel, "resized")
+		s.exit(metav1.Status{Status: metav1.StatusSuccess})
+	})
+	defer server.Close()
+
+	stdout := &bytes.Buffer{}
+	sizes := []TerminalSize{{Width: 80, Height: 24}, {Width: 120, Height: 40}}
+	err := streamTo(t, webSocketExecutorFor(StreamProtocolV5Name), server.URL, StreamOptions{
+		Stdout:            stdout,
+		Stderr:            &bytes.Buffer{},
+		Tty:               true,
+		TerminalSizeQueue: &fakeTerminalSizeQueue{sizes: append([]TerminalSize(nil), sizes...)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stdout.String() != "resized" {
+		t.Errorf("expected stdout %q, got %q", "resized", stdout.String())
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if !reflect.DeepEqual(sizes, resizes) {
+		t.Errorf("expected terminal sizes %v, got %v", sizes, resizes)
+	}
+}
+
+func TestWebSocketExecutorDisconnect(t *testing.T) {
+	server := newWebSocketServer([]string{StreamProtocolV5Name}, func(s *webSocketSession) {
+		s.send(stdoutChannel, "partial")
+		// drop the connection without a status.
+		s.ws.Close()
+	})
+	defer server.Close()
+
+	stdout := &bytes.Buffer{}
+	err := streamTo(t, webSocketExecutorFor(StreamProtocolV5Name), server.URL, StreamOptions{Stdout: stdout})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stdout.String() != "partial" {
+		t.Errorf("expected stdout %q, got %q", "partial", stdout.String())
+	}
+}
+
+// releasingWriter closes release on its first write.
+type releasingWriter struct {
+	buffer  bytes.Buffer
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *releasingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.release) })
+	return w.buffer.Write(p)
+}
+
+// blockedWriter blocks writes until release is closed.
+type blockedWriter struct {
+	buffer  bytes.Buffer
+	release chan struct{}
+}
+
+func (w *blockedWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.buffer.Write(p)
+}
+
+func TestWebSocketExecutorUnreadStream(t *testing.T) {
+	server := newWebSocketServer([]string{StreamProtocolV5Name}, func(s *webSocketSession) {
+		for i := 0; i < 3; i++ {
+			s.send(stdoutChannel, "out")
+		}
+		s.send(stderrChannel, "err")
+		s.exit(metav1.Status{Status: metav1.StatusSuccess})
+	})
+	defer server.Close()
+
+	// stdout is not read until stderr is, which the data of stdout must not
+	// hold up.
+	release := make(chan struct{})
+	stdout := &blockedWriter{release: release}
+	stderr := &releasingWriter{release: release}
+	err := streamTo(t, webSocketExecutorFor(StreamProtocolV5Name), server.URL, StreamOptions{Stdout: stdout, Stderr: stderr})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stdout.buffer.String() != "outoutout" {
+		t.Errorf("expected stdout %q, got %q", "outoutout", stdout.buffer.String())
+	}
+	if stderr.buffer.String() != "err" {
+		t.Errorf("expected stderr %q, got %q", "err", stderr.buffer.String())
+	}
+}
+
+func TestWebSocketStreamBufferLimit(t *testing.T) {
+	c := &webSocketConn{streams: map[byte]*webSocketStream{}, closed: make(chan struct{})}
+	c.addStream(stdoutChannel)
+	c.addStream(stderrChannel)
+	stdout, stderr := c.streams[stdoutChannel], c.streams[stderrChannel]
+
+	// the read loop waits for a stream whose buffer is full to be read.
+	stdout.deliver(make([]byte, maxStreamBufferSize))
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		stdout.deliver([]byte("more"))
+	}()
+	select {
+	case <-delivered:
+		t.Fatalf("expected the stream buffer to be full")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := io.CopyN(ioutil.Discard, stdout, maxStreamBufferSize); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-delivered:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("expected the data to be delivered once the buffer was read")
+	}
+	p := make([]byte, 10)
+	if n, err := stdout.Read(p); err != nil || string(p[:n]) != "more" {
+		t.Errorf("expected %q, got %q, %v", "more", p[:n], err)
+	}
+
+	// a reset stream discards what it is sent.
+	stderr.deliver(make([]byte, maxStreamBufferSize))
+	delivered = make(chan struct{})
+	go func() {
+		defer close(delivered)
+		stderr.deliver([]byte("more"))
+	}()
+	stderr.Reset()
+	select {
+	case <-delivered:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("expected the data to be discarded once the stream was reset")
+	}
+}
+
+func TestWebSocketExecutorUpgradeFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		status := apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "foo", errors.New("exec is not allowed")).Status()
+		status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(int(status.Code))
+		json.NewEncoder(w).Encode(status)
+	}))
+	defer server.Close()
+
+	err := streamTo(t, webSocketExecutorFor(StreamProtocolV5Name), server.URL, StreamOptions{Stdout: &bytes.Buffer{}})
+	if !apierrors.IsForbidden(err) {
+		t.Errorf("expected the status of the server, got %v", err)
+	}
+}
+
+func TestWebSocketExecutorFallback(t *testing.T) {
+	var lock sync.Mutex
+	var upgrades []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		upgrades = append(upgrades, req.Header.Get("Upgrade"))
+		lock.Unlock()
+		if strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+			// like proxies that do not support WebSockets.
+			http.Error(w, "websockets are not supported", http.StatusBadRequest)
+			return
+		}
+		options := &StreamOptions{Stdout: &bytes.Buffer{}}
+		ctx, err := createHTTPStreams(w, req, options)
+		if err != nil {
+			return
+		}
+		defer ctx.conn.Close()
+		io.WriteString(ctx.stdoutStream, "over spdy")
+		ctx.stdoutStream.Close()
+		ctx.writeStatus(&apierrors.StatusError{ErrStatus: metav1.Status{Status: metav1.StatusSuccess}})
+	}))
+	defer server.Close()
+
+	stdout := &bytes.Buffer{}
+	executor := func(u *url.URL) (Executor, error) {
+		return NewWebSocketExecutor(&rest.Config{Host: u.Host}, "POST", u)
+	}
+	if err := streamTo(t, executor, server.URL, StreamOptions{Stdout: stdout}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stdout.String() != "over spdy" {
+		t.Errorf("expected stdout %q, got %q", "over spdy", stdout.String())
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if !reflect.DeepEqual([]string{"websocket", "SPDY/3.1"}, upgrades) {
+		t.Errorf("expected a WebSocket and then a SPDY upgrade, got %v", upgrades)
+	}
+}
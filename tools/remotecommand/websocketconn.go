@@ -0,0 +1,371 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecommand
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/runtime"
+)
+
+// The channels of the WebSocket channel protocols. Every message starts with
+// the channel it belongs to.
+const (
+	stdinChannel byte = iota
+	stdoutChannel
+	stderrChannel
+	errorChannel
+	resizeChannel
+
+	// closeChannel messages of v5.channel.k8s.io close the channel they carry.
+	closeChannel byte = 255
+)
+
+// channels maps the stream types to their channels.
+var channels = map[string]byte{
+	v1.StreamTypeStdin:  stdinChannel,
+	v1.StreamTypeStdout: stdoutChannel,
+	v1.StreamTypeStderr: stderrChannel,
+	v1.StreamTypeError:  errorChannel,
+	v1.StreamTypeResize: resizeChannel,
+}
+
+// maxMessageSize is the size of the largest message read from the server.
+const maxMessageSize = 32 << 20
+
+// maxStreamBufferSize is the size of the data buffered for a stream that is
+// not read, past which the messages of the server are not read anymore. A
+// single message is buffered even if it is larger.
+const maxStreamBufferSize = 4 << 20
+
+// upgradeConn is the connection a WebSocket client handshakes over. The
+// handshake request written to it is sent with upgrade, and the response is
+// read back from it, followed by the upgraded connection.
+type upgradeConn struct {
+	upgrade func(req *http.Request) (*http.Response, io.ReadWriteCloser, error)
+
+	request  bytes.Buffer
+	protocol string
+	conn     io.ReadWriteCloser
+	reader   io.Reader
+}
+
+func (c *upgradeConn) Write(p []byte) (int, error) {
+	if c.conn == nil {
+		return c.request.Write(p)
+	}
+	return c.conn.Write(p)
+}
+
+func (c *upgradeConn) Read(p []byte) (int, error) {
+	if c.conn == nil {
+		if err := c.handshake(); err != nil {
+			return 0, err
+		}
+	}
+	return c.reader.Read(p)
+}
+
+// handshake sends the handshake request written so far, and returns once the
+// connection is upgraded.
+func (c *upgradeConn) handshake() error {
+	req, err := http.ReadRequest(bufio.NewReader(&c.request))
+	if err != nil {
+		return err
+	}
+	resp, conn, err := c.upgrade(req)
+	if err != nil {
+		return err
+	}
+	c.protocol = resp.Header.Get("Sec-WebSocket-Protocol")
+	head := &bytes.Buffer{}
+	fmt.Fprintf(head, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(head)
+	head.WriteString("\r\n")
+	c.conn = conn
+	c.reader = io.MultiReader(head, conn)
+	return nil
+}
+
+func (c *upgradeConn) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// webSocketConn is the client side of a WebSocket connection whose channels
+// carry the streams of a remote command.
+type webSocketConn struct {
+	ws       *websocket.Conn
+	protocol string
+
+	// streams are the streams the server may send to, by channel.
+	streams map[byte]*webSocketStream
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var _ streamCreator = &webSocketConn{}
+
+// newWebSocketConn returns the connection of ws, and starts reading from it.
+// Data read for the streams options does not use is discarded.
+func newWebSocketConn(ws *websocket.Conn, protocol string, options StreamOptions) *webSocketConn {
+	ws.PayloadType = websocket.BinaryFrame
+	ws.MaxPayloadBytes = maxMessageSize
+	c := &webSocketConn{
+		ws:       ws,
+		protocol: protocol,
+		streams:  map[byte]*webSocketStream{},
+		closed:   make(chan struct{}),
+	}
+	// the streams are those the stream protocol creates for options.
+	c.addStream(errorChannel)
+	if options.Stdin != nil {
+		c.addStream(stdinChannel)
+	}
+	if options.Stdout != nil {
+		c.addStream(stdoutChannel)
+	}
+	if options.Stderr != nil && !options.Tty {
+		c.addStream(stderrChannel)
+	}
+	if options.Tty {
+		c.addStream(resizeChannel)
+	}
+	go c.readLoop()
+	return c
+}
+
+func (c *webSocketConn) addStream(channel byte) {
+	s := &webSocketStream{conn: c, channel: channel}
+	s.cond = sync.NewCond(&s.lock)
+	c.streams[channel] = s
+}
+
+// CreateStream returns the stream of the channel of the stream type of
+// headers.
+func (c *webSocketConn) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	streamType := headers.Get(v1.StreamType)
+	channel, ok := channels[streamType]
+	if !ok {
+		return nil, fmt.Errorf("unknown stream type %q", streamType)
+	}
+	s, ok := c.streams[channel]
+	if !ok {
+		return nil, fmt.Errorf("unexpected stream type %q", streamType)
+	}
+	s.headers = headers.Clone()
+	return s, nil
+}
+
+// Close closes the connection. The streams read what they have been sent so
+// far, and then io.EOF.
+func (c *webSocketConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		// the read loop may be waiting for a stream to be read.
+		for _, s := range c.streams {
+			s.lock.Lock()
+			s.cond.Broadcast()
+			s.lock.Unlock()
+		}
+		err = c.ws.Close()
+	})
+	return err
+}
+
+// readLoop copies the messages of the server to their streams until the
+// connection is closed. The streams buffer up to maxStreamBufferSize of what
+// they are sent, so that a stream that is not read does not hold up the
+// others until then.
+func (c *webSocketConn) readLoop() {
+	defer runtime.HandleCrash()
+
+	var err error
+	defer func() {
+		select {
+		case <-c.closed:
+			// closed by the client.
+			err = nil
+		default:
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		for _, s := range c.streams {
+			s.closeWithError(err)
+		}
+	}()
+
+	for {
+		var message []byte
+		if err = websocket.Message.Receive(c.ws, &message); err != nil {
+			return
+		}
+		if len(message) == 0 {
+			continue
+		}
+		channel, data := message[0], message[1:]
+		if channel == closeChannel && c.protocol == StreamProtocolV5Name {
+			if len(data) == 1 {
+				if s, ok := c.streams[data[0]]; ok {
+					s.closeWithError(nil)
+				}
+			}
+			continue
+		}
+		if s, ok := c.streams[channel]; ok && len(data) > 0 {
+			s.deliver(data)
+		}
+	}
+}
+
+// writeMessage sends data on channel.
+func (c *webSocketConn) writeMessage(channel byte, data []byte) error {
+	message := make([]byte, 0, len(data)+1)
+	message = append(message, channel)
+	message = append(message, data...)
+	return websocket.Message.Send(c.ws, message)
+}
+
+// webSocketStream is the stream of a channel of a webSocketConn.
+type webSocketStream struct {
+	conn    *webSocketConn
+	channel byte
+	headers http.Header
+
+	lock sync.Mutex
+	cond *sync.Cond
+	// buffer holds the data sent to the stream that was not read yet.
+	buffer bytes.Buffer
+	// err is returned by Read once buffer is drained, it is set when the
+	// server closes the stream.
+	err error
+	// reset is set once the stream is reset.
+	reset bool
+}
+
+var _ httpstream.Stream = &webSocketStream{}
+
+// deliver queues data for Read, unless the stream is reset or closed. It waits
+// for the buffered data to be read while it would exceed maxStreamBufferSize.
+func (s *webSocketStream) deliver(data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for s.buffer.Len() > 0 && s.buffer.Len()+len(data) > maxStreamBufferSize && !s.done() {
+		s.cond.Wait()
+	}
+	if s.done() {
+		return
+	}
+	s.buffer.Write(data)
+	s.cond.Broadcast()
+}
+
+// done returns whether the data sent to the stream is discarded, because it
+// is reset or closed. The lock must be held.
+func (s *webSocketStream) done() bool {
+	if s.reset || s.err != nil {
+		return true
+	}
+	select {
+	case <-s.conn.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeWithError makes Read return err, or io.EOF if err is nil, once the
+// data sent so far is read.
+func (s *webSocketStream) closeWithError(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	s.err = err
+	s.cond.Broadcast()
+}
+
+func (s *webSocketStream) Read(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for s.buffer.Len() == 0 && s.err == nil && !s.reset {
+		s.cond.Wait()
+	}
+	switch {
+	case s.reset:
+		return 0, io.ErrClosedPipe
+	case s.buffer.Len() > 0:
+		// deliver may be waiting for room in the buffer.
+		s.cond.Broadcast()
+		return s.buffer.Read(p)
+	default:
+		return 0, s.err
+	}
+}
+
+func (s *webSocketStream) Write(p []byte) (int, error) {
+	if err := s.conn.writeMessage(s.channel, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the sending side of the stream. Only v5.channel.k8s.io can
+// signal this to the server, it does nothing with older protocols.
+func (s *webSocketStream) Close() error {
+	if s.conn.protocol != StreamProtocolV5Name {
+		return nil
+	}
+	return s.conn.writeMessage(closeChannel, []byte{s.channel})
+}
+
+// Reset stops reading the stream, the data the server sends to it is
+// discarded.
+func (s *webSocketStream) Reset() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reset = true
+	s.buffer.Reset()
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *webSocketStream) Headers() http.Header {
+	return s.headers
+}
+
+func (s *webSocketStream) Identifier() uint32 {
+	return uint32(s.channel)
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/connrotation"
+	"k8s.io/klog/v2"
+)
+
+// CACallbackRefreshDuration is exposed so that integration tests can crank up the reload speed.
+var CACallbackRefreshDuration = time.Minute
+
+// CARotationOverlap is how long the CAs of a CA file keep being trusted after
+// they were removed from the file, so that servers which have not been issued
+// certificates by the new CA yet can still be reached during a rotation.
+var CARotationOverlap = 10 * time.Minute
+
+// minCAReloadInterval limits how often the CA file is read when server
+// certificates signed by an unknown authority are seen.
+const minCAReloadInterval = time.Second
+
+// dynamicCA verifies server certificates against the CAs of a file that is
+// reloaded periodically, and when a server presents a certificate signed by
+// an authority that is not known yet.
+type dynamicCA struct {
+	caFile string
+
+	connDialer *connrotation.Dialer
+
+	lock     sync.RWMutex
+	caData   []byte
+	pool     *x509.CertPool
+	loadedAt time.Time
+	// previous holds the CAs of the file before it last changed, until
+	// previousUntil.
+	previous      []byte
+	previousUntil time.Time
+	now           func() time.Time
+}
+
+func caRotatingDialer(caFile string, dial utilnet.DialFunc) (*dynamicCA, error) {
+	ca := &dynamicCA{
+		caFile:     caFile,
+		connDialer: connrotation.NewDialer(connrotation.DialFunc(dial)),
+		now:        time.Now,
+	}
+	caData, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := rootCertPool(caData)
+	if err != nil {
+		return nil, err
+	}
+	ca.caData, ca.pool, ca.loadedAt = caData, pool, ca.now()
+	return ca, nil
+}
+
+// loadCA reads the CA file, and returns whether the CAs changed.
+func (c *dynamicCA) loadCA() (bool, error) {
+	caData, err := ioutil.ReadFile(c.caFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	c.loadedAt = now
+	if bytes.Equal(caData, c.caData) {
+		return false, nil
+	}
+	previous := c.caData
+	if now.Before(c.previousUntil) {
+		// the file changed again during an overlap, keep trusting the CAs
+		// of both earlier versions.
+		previous = append(append(append([]byte{}, c.previous...), '\n'), previous...)
+	}
+	pool, err := rootCertPool(append(append(append([]byte{}, caData...), '\n'), previous...))
+	if err != nil {
+		return false, fmt.Errorf("unable to load root certificates from %s: %w", c.caFile, err)
+	}
+	c.caData, c.pool = caData, pool
+	c.previous, c.previousUntil = previous, now.Add(CARotationOverlap)
+	return true, nil
+}
+
+// expirePrevious stops trusting the CAs of earlier versions of the CA file
+// once the overlap has passed, and returns whether it did.
+func (c *dynamicCA) expirePrevious() (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.previous == nil || c.now().Before(c.previousUntil) {
+		return false, nil
+	}
+	pool, err := rootCertPool(c.caData)
+	if err != nil {
+		return false, err
+	}
+	c.pool, c.previous = pool, nil
+	return true, nil
+}
+
+// reload reloads the CA file, and closes all connections if the trusted CAs
+// changed, so that servers are verified against the current CAs.
+func (c *dynamicCA) reload() {
+	changed, err := c.loadCA()
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to reload CA file %s: %v", c.caFile, err))
+	}
+	if changed {
+		klog.V(1).Infof("CA rotation detected in %s, shutting down client connections to verify servers with the new CAs", c.caFile)
+		c.connDialer.CloseAll()
+		return
+	}
+	expired, err := c.expirePrevious()
+	if err != nil {
+		utilruntime.HandleError(err)
+	}
+	if expired {
+		klog.V(1).Infof("No longer trusting the previous CAs of %s, shutting down client connections", c.caFile)
+		c.connDialer.CloseAll()
+	}
+}
+
+func (c *dynamicCA) currentPool() (*x509.CertPool, time.Time) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.pool, c.loadedAt
+}
+
+// dialTLS returns a function that dials TLS connections with a copy of config,
+// for http.Transport.DialTLSContext. The certificate of every server is
+// verified against the server name of config, or else the host the connection
+// is dialed for, which also covers servers addressed by IP.
+func (c *dynamicCA) dialTLS(config *tls.Config, dial utilnet.DialFunc) utilnet.DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig := config.Clone()
+		if len(tlsConfig.ServerName) == 0 {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				host = address
+			}
+			tlsConfig.ServerName = host
+		}
+		serverName := tlsConfig.ServerName
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return c.verifyConnection(cs, serverName)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+		defer cancel()
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// VerifyConnection verifies the certificate chain presented by the server
+// against the current CAs and the server name of the connection. It is used
+// for the connections that http.Transport sets up itself, which are those
+// through a proxy. Servers addressed by IP send no server name, so they can
+// only be verified by the connections of dialTLS.
+func (c *dynamicCA) VerifyConnection(cs tls.ConnectionState) error {
+	if len(cs.ServerName) == 0 {
+		return errors.New("tls: cannot verify the certificate of a server addressed by IP through a proxy while reloading the CA file")
+	}
+	return c.verifyConnection(cs, cs.ServerName)
+}
+
+// verifyConnection verifies the certificate chain presented by the server
+// against the current CAs, like crypto/tls does for tls.Config.RootCAs. If the
+// certificate is signed by an unknown authority, the CA file is read again
+// in case the CA was rotated. Other connections are kept then, since the CAs
+// they were verified with are still trusted during the overlap.
+func (c *dynamicCA) verifyConnection(cs tls.ConnectionState, serverName string) error {
+	pool, loadedAt := c.currentPool()
+	err := verifyServer(cs, pool, serverName)
+	var unknownAuthority x509.UnknownAuthorityError
+	if !errors.As(err, &unknownAuthority) || c.now().Sub(loadedAt) < minCAReloadInterval {
+		return err
+	}
+	if _, loadErr := c.loadCA(); loadErr != nil {
+		klog.V(2).Infof("Failed to reload CA file %s: %v", c.caFile, loadErr)
+		return err
+	}
+	pool, _ = c.currentPool()
+	return verifyServer(cs, pool, serverName)
+}
+
+// verifyServer verifies the certificate chain of cs against the CAs of pool,
+// and that it is valid for serverName, which may be an IP.
+func verifyServer(cs tls.ConnectionState, pool *x509.CertPool, serverName string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls: server did not present a certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// Run reloads the CA file until stopCh is closed.
+func (c *dynamicCA) Run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+
+	klog.V(3).Infof("Starting CA rotation controller for %s", c.caFile)
+	defer klog.V(3).Infof("Shutting down CA rotation controller for %s", c.caFile)
+
+	wait.Until(c.reload, CACallbackRefreshDuration, stopCh)
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// serverCert issues a serving certificate for 127.0.0.1.
+func (ca *testCA) serverCert(t *testing.T) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newRotatingTLSServer returns a server that presents the certificate that
+// cert holds at the time of the handshake.
+func newRotatingTLSServer(cert *atomic.Value) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		// GetCertificate is not used for clients that send no server name.
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{Certificates: []tls.Certificate{*cert.Load().(*tls.Certificate)}}, nil
+		},
+	}
+	server.StartTLS()
+	return server
+}
+
+func TestCARotation(t *testing.T) {
+	oldCA, newCA := newTestCA(t, "old"), newTestCA(t, "new")
+	var serverCert atomic.Value
+	serverCert.Store(oldCA.serverCert(t))
+	server := newRotatingTLSServer(&serverCert)
+	defer closeTestServer(server)
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := ioutil.WriteFile(caFile, oldCA.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := caRotatingDialer(caFile, (&net.Dialer{}).DialContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	ca.now = func() time.Time { return now }
+	rt := &http.Transport{
+		DialContext:    ca.connDialer.DialContext,
+		DialTLSContext: ca.dialTLS(&tls.Config{InsecureSkipVerify: true}, ca.connDialer.DialContext),
+	}
+	defer rt.CloseIdleConnections()
+
+	// get sends a request, and returns whether it reused a connection.
+	get := func() (bool, error) {
+		var reused bool
+		trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		return reused, nil
+	}
+
+	if _, err := get(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reused, err := get(); err != nil || !reused {
+		t.Fatalf("expected the connection to be reused, got %t, %v", reused, err)
+	}
+
+	// rotating the CA closes the connections, and the old CA is still trusted
+	// during the overlap.
+	if err := ioutil.WriteFile(caFile, newCA.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	ca.reload()
+	if reused, err := get(); err != nil || reused {
+		t.Fatalf("expected a new connection, got %t, %v", reused, err)
+	}
+	serverCert.Store(newCA.serverCert(t))
+	rt.CloseIdleConnections()
+	if _, err := get(); err != nil {
+		t.Fatalf("unexpected error with the new CA: %v", err)
+	}
+
+	// reloading an unchanged file keeps the connections.
+	ca.reload()
+	if reused, err := get(); err != nil || !reused {
+		t.Fatalf("expected the connection to be reused, got %t, %v", reused, err)
+	}
+
+	// after the overlap the old CA is no longer trusted, and the connections
+	// are closed.
+	serverCert.Store(oldCA.serverCert(t))
+	now = now.Add(CARotationOverlap)
+	ca.reload()
+	var unknownAuthority x509.UnknownAuthorityError
+	if _, err := get(); !errors.As(err, &unknownAuthority) {
+		t.Fatalf("expected an unknown authority error, got %v", err)
+	}
+}
+
+func TestCAReloadOnUnknownAuthority(t *testing.T) {
+	oldCA, newCA := newTestCA(t, "old"), newTestCA(t, "new")
+	var serverCert atomic.Value
+	serverCert.Store(newCA.serverCert(t))
+	server := newRotatingTLSServer(&serverCert)
+	defer closeTestServer(server)
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := ioutil.WriteFile(caFile, oldCA.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := New(&Config{TLS: TLSConfig{CAFile: caFile, ReloadCAFile: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	if _, err := rt.RoundTrip(req); err == nil {
+		t.Fatal("expected the server to be rejected")
+	}
+
+	// the file is read again once a server presents a certificate of an
+	// unknown authority, but not more often than minCAReloadInterval.
+	if err := ioutil.WriteFile(caFile, newCA.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(minCAReloadInterval)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error after the CA file changed: %v", err)
+	}
+	resp.Body.Close()
+	rt.(*http.Transport).CloseIdleConnections()
+}
+
+func TestCAReloadVerifiesDialedHost(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	var serverCert atomic.Value
+	serverCert.Store(ca.serverCert(t))
+	server := newRotatingTLSServer(&serverCert)
+	defer closeTestServer(server)
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := ioutil.WriteFile(caFile, ca.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := New(&Config{TLS: TLSConfig{CAFile: caFile, ReloadCAFile: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.(*http.Transport).CloseIdleConnections()
+
+	// the certificate is valid for 127.0.0.1.
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	// but not for localhost, although that is the same server.
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	req, _ = http.NewRequest("GET", "https://localhost:"+port, nil)
+	var hostnameErr x509.HostnameError
+	if _, err := rt.RoundTrip(req); !errors.As(err, &hostnameErr) {
+		t.Errorf("expected a hostname error, got %v", err)
+	}
+}
+
+func TestCAFileNotReloadedByDefault(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := ioutil.WriteFile(caFile, ca.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := New(&Config{TLS: TLSConfig{CAFile: caFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := rt.(*http.Transport)
+	if transport.DialTLSContext != nil || transport.TLSClientConfig.InsecureSkipVerify {
+		t.Errorf("expected the CAs to be verified by crypto/tls")
+	}
+}
@@ -20,12 +20,19 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"golang.org/x/net/http2"
+
 	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/metrics"
+	"k8s.io/klog/v2"
 )
 
 // TlsTransportCache caches TLS http.RoundTrippers different configurations. The
@@ -33,16 +40,38 @@ import (
 // the config has no custom TLS options, http.DefaultTransport is returned.
 type tlsTransportCache struct {
 	mu         sync.Mutex
-	transports map[tlsCacheKey]*http.Transport
+	transports map[tlsCacheKey]*cachedTransport
+	now        func() time.Time
+}
+
+// cachedTransport is a transport of the cache and its connections.
+type cachedTransport struct {
+	transport *http.Transport
+	conns     *connTracker
+	// stopCh stops reloading the certificates of the transport. It is closed
+	// once the transport is evicted and no longer referenced by any client.
+	stopCh chan struct{}
 }
 
 const idleConnsPerHost = 25
 
-var tlsCache = &tlsTransportCache{transports: make(map[tlsCacheKey]*http.Transport)}
+const tlsHandshakeTimeout = 10 * time.Second
+
+// TransportCacheIdleTimeout, if greater than zero, is how long a cached
+// transport is kept after it was last handed out and had no connection opened
+// or closed. Transports with open connections, like those of watches, are
+// never evicted. Evicted transports keep working for the clients that use
+// them, and keep reloading their certificate and CA files until no client
+// references them anymore, but their idle connections are closed and later
+// clients get a new transport. Zero, the default, disables eviction.
+var TransportCacheIdleTimeout time.Duration
+
+var tlsCache = &tlsTransportCache{transports: make(map[tlsCacheKey]*cachedTransport), now: time.Now}
 
 type tlsCacheKey struct {
 	insecure           bool
 	caData             string
+	caFile             string
 	certData           string
 	keyData            string `datapolicy:"security-key"`
 	certFile           string
@@ -50,6 +79,8 @@ type tlsCacheKey struct {
 	serverName         string
 	nextProtos         string
 	disableCompression bool
+	readIdleTimeout    time.Duration
+	pingTimeout        time.Duration
 }
 
 func (t tlsCacheKey) String() string {
@@ -57,7 +88,7 @@ func (t tlsCacheKey) String() string {
 	if len(t.keyData) > 0 {
 		keyText = "<redacted>"
 	}
-	return fmt.Sprintf("insecure:%v, caData:%#v, certData:%#v, keyData:%s, serverName:%s, disableCompression:%t", t.insecure, t.caData, t.certData, keyText, t.serverName, t.disableCompression)
+	return fmt.Sprintf("insecure:%v, caData:%#v, caFile:%s, certData:%#v, keyData:%s, serverName:%s, disableCompression:%t, readIdleTimeout:%v, pingTimeout:%v", t.insecure, t.caData, t.caFile, t.certData, keyText, t.serverName, t.disableCompression, t.readIdleTimeout, t.pingTimeout)
 }
 
 func (c *tlsTransportCache) get(config *Config) (http.RoundTripper, error) {
@@ -70,11 +101,18 @@ func (c *tlsTransportCache) get(config *Config) (http.RoundTripper, error) {
 		// Ensure we only create a single transport for the given TLS options
 		c.mu.Lock()
 		defer c.mu.Unlock()
+		defer func() { metrics.TransportCacheEntries.Observe(len(c.transports)) }()
+		c.evictIdle()
 
 		// See if we already have a custom transport for this config
 		if t, ok := c.transports[key]; ok {
-			return t, nil
+			metrics.TransportCreateCalls.Increment("hit")
+			t.conns.touch()
+			return t.transport, nil
 		}
+		metrics.TransportCreateCalls.Increment("miss")
+	} else {
+		metrics.TransportCreateCalls.Increment("uncacheable")
 	}
 
 	// Get the TLS options for this client config
@@ -83,10 +121,19 @@ func (c *tlsTransportCache) get(config *Config) (http.RoundTripper, error) {
 		return nil, err
 	}
 	// The options didn't require a custom TLS config
-	if tlsConfig == nil && config.Dial == nil && config.Proxy == nil {
+	if tlsConfig == nil && config.Dial == nil && config.Proxy == nil && !config.hasHTTP2HealthCheck() {
 		return http.DefaultTransport, nil
 	}
 
+	// the certificates of transports that are not cached are reloaded for as
+	// long as the process runs.
+	stopCh := wait.NeverStop
+	var stop chan struct{}
+	if canCache {
+		stop = make(chan struct{})
+		stopCh = stop
+	}
+
 	dial := config.Dial
 	if dial == nil {
 		dial = (&net.Dialer{
@@ -101,7 +148,32 @@ func (c *tlsTransportCache) get(config *Config) (http.RoundTripper, error) {
 		dynamicCertDialer := certRotatingDialer(tlsConfig.GetClientCertificate, dial)
 		tlsConfig.GetClientCertificate = dynamicCertDialer.GetClientCertificate
 		dial = dynamicCertDialer.connDialer.DialContext
-		go dynamicCertDialer.Run(wait.NeverStop)
+		go dynamicCertDialer.Run(stopCh)
+	}
+
+	// If the CA file is reloaded, servers are verified against the CAs the
+	// file holds at the time, and connections are closed when they change.
+	var rotatingCA *dynamicCA
+	if config.reloadsCAFile() && tlsConfig != nil {
+		rotatingCA, err = caRotatingDialer(config.TLS.CAFile, dial)
+		if err != nil {
+			return nil, err
+		}
+		// verification against RootCAs is replaced by rotatingCA.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = rotatingCA.VerifyConnection
+		dial = rotatingCA.connDialer.DialContext
+		go rotatingCA.Run(stopCh)
+	}
+
+	var conns *connTracker
+	if canCache {
+		conns = newConnTracker(key, c.now)
+		dial = conns.dial(dial)
+		// conns is only referenced by the cache and by the transport, through
+		// its dialer, so the certificates of the transport are reloaded until
+		// it is evicted and every client has released it.
+		runtime.SetFinalizer(conns, func(*connTracker) { close(stop) })
 	}
 
 	proxy := http.ProxyFromEnvironment
@@ -109,23 +181,50 @@ func (c *tlsTransportCache) get(config *Config) (http.RoundTripper, error) {
 		proxy = config.Proxy
 	}
 
-	transport := utilnet.SetTransportDefaults(&http.Transport{
+	transport := setTransportDefaults(&http.Transport{
 		Proxy:               proxy,
-		TLSHandshakeTimeout: 10 * time.Second,
+		TLSHandshakeTimeout: tlsHandshakeTimeout,
 		TLSClientConfig:     tlsConfig,
 		MaxIdleConnsPerHost: idleConnsPerHost,
 		DialContext:         dial,
 		DisableCompression:  config.DisableCompression,
-	})
+	}, config)
+	if rotatingCA != nil {
+		// servers that are not reached through a proxy are verified against
+		// the host the connection was dialed for.
+		transport.DialTLSContext = rotatingCA.dialTLS(transport.TLSClientConfig, dial)
+	}
 
 	if canCache {
 		// Cache a single transport for these options
-		c.transports[key] = transport
+		c.transports[key] = &cachedTransport{transport: transport, conns: conns, stopCh: stop}
 	}
 
 	return transport, nil
 }
 
+// evictIdle removes the transports that were idle for longer than
+// TransportCacheIdleTimeout from the cache, once none of their connections is
+// in use anymore. It must be called with c.mu held.
+func (c *tlsTransportCache) evictIdle() {
+	if TransportCacheIdleTimeout <= 0 {
+		return
+	}
+	for key, t := range c.transports {
+		if c.now().Sub(t.conns.lastUsed()) < TransportCacheIdleTimeout {
+			continue
+		}
+		// the connections left open are in use, for quiet watches say.
+		t.transport.CloseIdleConnections()
+		if t.conns.openConns() > 0 {
+			continue
+		}
+		klog.V(4).Infof("Evicting idle transport %s from the transport cache", t.conns.id)
+		delete(c.transports, key)
+		t.conns.evict()
+	}
+}
+
 // tlsConfigKey returns a unique key for tls.Config objects returned from TLSConfigFor
 func tlsConfigKey(c *Config) (tlsCacheKey, bool, error) {
 	// Make sure ca/key/cert content is loaded
@@ -140,10 +239,17 @@ func tlsConfigKey(c *Config) (tlsCacheKey, bool, error) {
 
 	k := tlsCacheKey{
 		insecure:           c.TLS.Insecure,
-		caData:             string(c.TLS.CAData),
 		serverName:         c.TLS.ServerName,
 		nextProtos:         strings.Join(c.TLS.NextProtos, ","),
 		disableCompression: c.DisableCompression,
+		readIdleTimeout:    c.HTTP2ReadIdleTimeout,
+		pingTimeout:        c.HTTP2PingTimeout,
+	}
+
+	if c.reloadsCAFile() {
+		k.caFile = c.TLS.CAFile
+	} else {
+		k.caData = string(c.TLS.CAData)
 	}
 
 	if c.TLS.ReloadTLSFiles {
@@ -156,3 +262,74 @@ func tlsConfigKey(c *Config) (tlsCacheKey, bool, error) {
 
 	return k, true, nil
 }
+
+// setTransportDefaults applies utilnet.SetTransportDefaults to t. If the
+// config sets the HTTP/2 health check timeouts, HTTP/2 is configured here
+// instead so that the timeouts can be applied to the HTTP/2 transport.
+func setTransportDefaults(t *http.Transport, config *Config) *http.Transport {
+	if !config.hasHTTP2HealthCheck() {
+		return utilnet.SetTransportDefaults(t)
+	}
+
+	t = utilnet.SetOldTransportDefaults(t)
+	// Allow clients to disable http2 if needed.
+	if s := os.Getenv("DISABLE_HTTP2"); len(s) > 0 {
+		klog.Info("HTTP2 has been explicitly disabled")
+		return t
+	}
+	if !allowsHTTP2(t) {
+		return t
+	}
+	t2, err := http2.ConfigureTransports(t)
+	if err != nil {
+		klog.Warningf("Transport failed http2 configuration: %v", err)
+		return t
+	}
+	t2.ReadIdleTimeout = http2Timeout(config.HTTP2ReadIdleTimeout, "HTTP2_READ_IDLE_TIMEOUT_SECONDS", 30*time.Second)
+	t2.PingTimeout = http2Timeout(config.HTTP2PingTimeout, "HTTP2_PING_TIMEOUT_SECONDS", 15*time.Second)
+	return t
+}
+
+// hasHTTP2HealthCheck returns whether the config overrides the HTTP/2 health
+// check timeouts.
+func (c *Config) hasHTTP2HealthCheck() bool {
+	return c.HTTP2ReadIdleTimeout != 0 || c.HTTP2PingTimeout != 0
+}
+
+// http2Timeout returns timeout if it is set, and otherwise the number of
+// seconds in the named environment variable, or def if that is not set
+// either. Negative timeouts are returned as zero, which disables them.
+func http2Timeout(timeout time.Duration, env string, def time.Duration) time.Duration {
+	if timeout == 0 {
+		timeout = def
+		if s := os.Getenv(env); len(s) > 0 {
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				klog.Warningf("Illegal %s(%q): %v. Default value %v is used", env, s, err, def)
+			} else {
+				timeout = time.Duration(i) * time.Second
+			}
+		}
+	}
+	if timeout < 0 {
+		return 0
+	}
+	return timeout
+}
+
+// allowsHTTP2 returns whether the transport TLS config permits negotiating
+// HTTP/2.
+func allowsHTTP2(t *http.Transport) bool {
+	if t.TLSClientConfig == nil || len(t.TLSClientConfig.NextProtos) == 0 {
+		// the transport expressed no NextProto preference, allow
+		return true
+	}
+	for _, p := range t.TLSClientConfig.NextProtos {
+		if p == http2.NextProtoTLS {
+			// the transport explicitly allowed http/2
+			return true
+		}
+	}
+	// the transport explicitly set NextProtos and excluded http/2
+	return false
+}
@@ -19,9 +19,23 @@ package transport
 import (
 	"context"
 	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/metrics"
 )
 
 func TestTLSConfigKey(t *testing.T) {
@@ -134,8 +148,12 @@ func TestTLSConfigKey(t *testing.T) {
 				GetCert: getCert,
 			},
 		},
-		"http2, http1.1": {TLS: TLSConfig{NextProtos: []string{"h2", "http/1.1"}}},
-		"http1.1-only":   {TLS: TLSConfig{NextProtos: []string{"http/1.1"}}},
+		"http2, http1.1":             {TLS: TLSConfig{NextProtos: []string{"h2", "http/1.1"}}},
+		"http1.1-only":               {TLS: TLSConfig{NextProtos: []string{"http/1.1"}}},
+		"read idle timeout 1":        {HTTP2ReadIdleTimeout: time.Second},
+		"read idle timeout 2":        {HTTP2ReadIdleTimeout: 2 * time.Second},
+		"ping timeout":               {HTTP2PingTimeout: time.Second},
+		"read idle and ping timeout": {HTTP2ReadIdleTimeout: time.Second, HTTP2PingTimeout: time.Second},
 	}
 	for nameA, valueA := range uniqueConfigurations {
 		for nameB, valueB := range uniqueConfigurations {
@@ -170,3 +188,342 @@ func TestTLSConfigKey(t *testing.T) {
 		}
 	}
 }
+
+// freezingProxy forwards TCP connections to a backend until it is frozen,
+// after which it silently drops everything, like a load balancer that lost
+// track of the connections.
+type freezingProxy struct {
+	listener net.Listener
+	backend  string
+
+	freezeOnce sync.Once
+	frozen     chan struct{}
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newFreezingProxy(t *testing.T, backend string) *freezingProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &freezingProxy{listener: l, backend: backend, frozen: make(chan struct{})}
+	go p.serve()
+	return p
+}
+
+func (p *freezingProxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		backend, err := net.Dial("tcp", p.backend)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		p.mu.Lock()
+		p.conns = append(p.conns, conn, backend)
+		p.mu.Unlock()
+		go p.forward(conn, backend)
+		go p.forward(backend, conn)
+	}
+}
+
+func (p *freezingProxy) forward(dst, src net.Conn) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		select {
+		case <-p.frozen:
+			// keep the connection open, but never deliver anything again.
+			io.Copy(ioutil.Discard, src)
+			return
+		default:
+		}
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *freezingProxy) freeze() {
+	p.freezeOnce.Do(func() { close(p.frozen) })
+}
+
+func (p *freezingProxy) close() {
+	p.freeze()
+	p.listener.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+}
+
+// closeTestServer stops s without calling s.Close(), which initializes
+// http.DefaultTransport that other tests in this package expect to be pristine.
+func closeTestServer(s *httptest.Server) {
+	s.Config.Close()
+}
+
+func TestHTTP2HealthCheck(t *testing.T) {
+	received := make(chan struct{})
+	stop := make(chan struct{})
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		close(received)
+		// behave like a watch that never sends an event.
+		select {
+		case <-stop:
+		case <-req.Context().Done():
+		}
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer closeTestServer(server)
+	defer close(stop)
+
+	proxy := newFreezingProxy(t, server.Listener.Addr().String())
+	defer proxy.close()
+
+	config := &Config{
+		TLS: TLSConfig{
+			CAData:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}),
+			ServerName: "example.com",
+		},
+		HTTP2ReadIdleTimeout: 100 * time.Millisecond,
+		HTTP2PingTimeout:     100 * time.Millisecond,
+	}
+	rt, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &url.URL{Scheme: "https", Host: proxy.listener.Addr().String(), Path: "/watch"}
+	resp, err := (&http.Client{Transport: rt}).Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expected an HTTP/2 response, got: %s", resp.Proto)
+	}
+	<-received
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(ioutil.Discard, resp.Body)
+		readErr <- err
+	}()
+
+	// the connection is healthy, so it must stay open across health checks.
+	select {
+	case err := <-readErr:
+		t.Fatalf("Unexpected end of a healthy response body: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	proxy.freeze()
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Errorf("Expected an error reading from a dead connection")
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("Dead connection was not detected")
+	}
+}
+
+type fakeTransportCreateCalls struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (f *fakeTransportCreateCalls) Increment(result string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[result]++
+}
+
+type fakeTransportConnections struct {
+	mu   sync.Mutex
+	open map[string]int
+}
+
+func (f *fakeTransportConnections) Set(transport string, open int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.open[transport] = open
+}
+
+func TestTransportCacheEviction(t *testing.T) {
+	createCalls := &fakeTransportCreateCalls{calls: map[string]int{}}
+	connections := &fakeTransportConnections{open: map[string]int{}}
+	defer func(calls metrics.TransportCreateCallsMetric, conns metrics.TransportConnectionsMetric) {
+		metrics.TransportCreateCalls, metrics.TransportConnections = calls, conns
+	}(metrics.TransportCreateCalls, metrics.TransportConnections)
+	metrics.TransportCreateCalls, metrics.TransportConnections = createCalls, connections
+	defer func(timeout time.Duration) { TransportCacheIdleTimeout = timeout }(TransportCacheIdleTimeout)
+	TransportCacheIdleTimeout = 10 * time.Minute
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer closeTestServer(server)
+
+	fakeClock := clock.NewFakeClock(time.Now())
+	cache := &tlsTransportCache{transports: map[tlsCacheKey]*cachedTransport{}, now: fakeClock.Now}
+	config := &Config{TLS: TLSConfig{Insecure: true}}
+	rt, err := cache.get(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := cache.get(&Config{TLS: TLSConfig{Insecure: true}}); err != nil || cached != rt {
+		t.Fatalf("expected the cached transport, got %v, %v", cached, err)
+	}
+	if _, err := cache.get(&Config{TLS: TLSConfig{Insecure: true}, Dial: (&net.Dialer{}).DialContext}); err != nil {
+		t.Fatal(err)
+	}
+	expectedCalls := map[string]int{"miss": 1, "hit": 1, "uncacheable": 1}
+	if !reflect.DeepEqual(createCalls.calls, expectedCalls) {
+		t.Errorf("expected calls %v, got %v", expectedCalls, createCalls.calls)
+	}
+
+	resp, err := rt.RoundTrip(httptest.NewRequest("GET", server.URL, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	key, _, _ := tlsConfigKey(config)
+	conns := cache.transports[key].conns
+	connections.mu.Lock()
+	if connections.open[conns.id] != 1 {
+		t.Errorf("expected 1 open connection, got %v", connections.open)
+	}
+	connections.mu.Unlock()
+
+	// the transport is kept while it is used.
+	fakeClock.Step(TransportCacheIdleTimeout - time.Second)
+	if cached, err := cache.get(config); err != nil || cached != rt {
+		t.Fatalf("expected the cached transport, got %v, %v", cached, err)
+	}
+
+	// an idle transport is evicted, and its idle connections are closed.
+	fakeClock.Step(TransportCacheIdleTimeout)
+	if _, err := cache.get(&Config{TLS: TLSConfig{ServerName: "other"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.transports[key]; ok || len(cache.transports) != 1 {
+		t.Fatalf("expected the idle transport to be evicted, got %d transports", len(cache.transports))
+	}
+	if open := atomic.LoadInt64(&conns.open); open != 0 {
+		t.Errorf("expected the idle connection to be closed, got %d open connections", open)
+	}
+	connections.mu.Lock()
+	if connections.open[conns.id] != 0 {
+		t.Errorf("expected no open connections, got %v", connections.open)
+	}
+	connections.mu.Unlock()
+	if cached, err := cache.get(config); err != nil || cached == rt {
+		t.Fatalf("expected a new transport, got %v", err)
+	}
+}
+
+func TestTransportCacheEvictionDisabledByDefault(t *testing.T) {
+	if TransportCacheIdleTimeout != 0 {
+		t.Fatalf("expected eviction to be disabled by default, got an idle timeout of %v", TransportCacheIdleTimeout)
+	}
+	fakeClock := clock.NewFakeClock(time.Now())
+	cache := &tlsTransportCache{transports: map[tlsCacheKey]*cachedTransport{}, now: fakeClock.Now}
+	config := &Config{TLS: TLSConfig{Insecure: true}}
+	rt, err := cache.get(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeClock.Step(24 * time.Hour)
+	if cached, err := cache.get(config); err != nil || cached != rt {
+		t.Fatalf("expected the cached transport, got %v, %v", cached, err)
+	}
+}
+
+func TestTransportCacheEvictionKeepsConnectionsInUse(t *testing.T) {
+	defer func(timeout time.Duration) { TransportCacheIdleTimeout = timeout }(TransportCacheIdleTimeout)
+	TransportCacheIdleTimeout = 10 * time.Minute
+	release := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// a watch with no events.
+		<-release
+	}))
+	defer closeTestServer(server)
+
+	fakeClock := clock.NewFakeClock(time.Now())
+	cache := &tlsTransportCache{transports: map[tlsCacheKey]*cachedTransport{}, now: fakeClock.Now}
+	config := &Config{TLS: TLSConfig{Insecure: true}}
+	rt, err := cache.get(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, _ := tlsConfigKey(config)
+	stopCh := cache.transports[key].stopCh
+
+	resp, err := rt.RoundTrip(httptest.NewRequest("GET", server.URL, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the transport is kept while the watch is open.
+	fakeClock.Step(2 * TransportCacheIdleTimeout)
+	if got, err := cache.get(config); err != nil || got != rt {
+		t.Fatalf("expected the cached transport, got %v, %v", got, err)
+	}
+
+	// once the watch ended and the transport was idle, it is evicted.
+	close(release)
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	fakeClock.Step(2 * TransportCacheIdleTimeout)
+	// the connection is returned to the idle pool asynchronously.
+	err = wait.PollImmediate(time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		if _, err := cache.get(&Config{TLS: TLSConfig{ServerName: "other"}}); err != nil {
+			return false, err
+		}
+		_, ok := cache.transports[key]
+		return !ok, nil
+	})
+	if err != nil {
+		t.Fatalf("expected the idle transport to be evicted: %v", err)
+	}
+
+	// the certificates are reloaded while the transport is still used.
+	runtime.GC()
+	select {
+	case <-stopCh:
+		t.Fatalf("expected the evicted transport to keep reloading its certificates while it is used")
+	default:
+	}
+	runtime.KeepAlive(rt)
+
+	// and no longer once it is released.
+	rt, resp = nil, nil
+	err = wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		runtime.GC()
+		select {
+		case <-stopCh:
+			return true, nil
+		default:
+			return false, nil
+		}
+	})
+	if err != nil {
+		t.Errorf("expected the stop channel of the released transport to be closed")
+	}
+}
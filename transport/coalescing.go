@@ -0,0 +1,295 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// coalescingRoundTripper sends concurrent identical GET requests to the server
+// once, and hands every caller a copy of the response.
+type coalescingRoundTripper struct {
+	rt http.RoundTripper
+	// maxBytes is the size of the largest response body that is shared.
+	maxBytes int64
+
+	lock  sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// coalescedCall is a request in flight that callers wait for.
+type coalescedCall struct {
+	done chan struct{}
+	// resp and body are the response and its body, set once done is closed.
+	resp *http.Response
+	body []byte
+	err  error
+	// tooLarge is set when the body of the response exceeds the limit of the
+	// round tripper. One of the callers then takes stream, and the others
+	// send their requests on their own.
+	tooLarge bool
+	// stream is the response of a call whose body is too large, until a
+	// caller takes it. It is guarded by the lock of the round tripper.
+	stream *http.Response
+
+	// waiters is the number of callers waiting for the call, guarded by the
+	// lock of the round tripper. The request is canceled once all of them
+	// gave up.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// defaultMaxCoalescedBytes is the size of the largest response body shared by
+// coalesced requests, unless configured otherwise.
+const defaultMaxCoalescedBytes = 10 << 20
+
+// NewCoalescingRoundTripper returns a round tripper that coalesces concurrent
+// GET requests for the same URL with the same headers into a single request to
+// rt. Headers that differ for every request, like the trace context and the
+// User-Agent, are not compared. Every caller gets its own copy of the response
+// and its body, which is read into memory up to maxBytes, or 10MiB if
+// maxBytes is not positive. If the body is larger, one of the callers gets
+// the response as it streams, and the others send their requests again on
+// their own. Requests with a body, watches, followed logs and
+// upgrade requests are always sent on their own. Since requests are only
+// coalesced with those sent through the same round tripper, they share the
+// same credentials if the round tripper is placed in front of the
+// authentication round trippers.
+func NewCoalescingRoundTripper(maxBytes int64, rt http.RoundTripper) http.RoundTripper {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxCoalescedBytes
+	}
+	return &coalescingRoundTripper{rt: rt, maxBytes: maxBytes, calls: map[string]*coalescedCall{}}
+}
+
+func (rt *coalescingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isCoalescable(req) {
+		return rt.rt.RoundTrip(req)
+	}
+	key := coalescingKey(req)
+
+	rt.lock.Lock()
+	call, ok := rt.calls[key]
+	if !ok {
+		// the request is sent on behalf of all callers, so it must not be
+		// canceled when the first of them gives up.
+		ctx, cancel := context.WithCancel(detachedContext{req.Context()})
+		call = &coalescedCall{done: make(chan struct{}), cancel: cancel}
+		rt.calls[key] = call
+		go rt.do(key, call, req.WithContext(ctx))
+	}
+	call.waiters++
+	rt.lock.Unlock()
+
+	select {
+	case <-call.done:
+	case <-req.Context().Done():
+		rt.lock.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// nobody is waiting for the response anymore, new callers
+			// have to start over.
+			rt.forget(key, call)
+			call.cancel()
+			if call.stream != nil {
+				call.stream.Body.Close()
+				call.stream = nil
+			}
+		}
+		rt.lock.Unlock()
+		return nil, req.Context().Err()
+	}
+
+	if call.err != nil {
+		return nil, call.err
+	}
+	if call.tooLarge {
+		rt.lock.Lock()
+		stream := call.stream
+		call.stream = nil
+		rt.lock.Unlock()
+		if stream == nil {
+			return rt.rt.RoundTrip(req)
+		}
+		stream.Request = req
+		return stream, nil
+	}
+	resp := *call.resp
+	resp.Header = call.resp.Header.Clone()
+	resp.Trailer = call.resp.Trailer.Clone()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(call.body))
+	resp.Request = req
+	return &resp, nil
+}
+
+func (rt *coalescingRoundTripper) do(key string, call *coalescedCall, req *http.Request) {
+	defer close(call.done)
+
+	resp, err := rt.rt.RoundTrip(req)
+
+	// new callers must not join a call whose response has been read.
+	rt.lock.Lock()
+	rt.forget(key, call)
+	rt.lock.Unlock()
+
+	if err != nil {
+		call.cancel()
+		call.err = err
+		return
+	}
+	var body []byte
+	if resp.ContentLength <= rt.maxBytes {
+		body, err = ioutil.ReadAll(io.LimitReader(resp.Body, rt.maxBytes+1))
+		if err != nil {
+			resp.Body.Close()
+			call.cancel()
+			call.err = err
+			return
+		}
+		if int64(len(body)) <= rt.maxBytes {
+			resp.Body.Close()
+			call.cancel()
+			call.resp, call.body = resp, body
+			return
+		}
+	}
+
+	// the rest of the body is streamed to a single caller, the request is
+	// canceled once it closes the body.
+	call.tooLarge = true
+	resp.Body = &streamedBody{
+		Reader: io.MultiReader(bytes.NewReader(body), resp.Body),
+		body:   resp.Body,
+		cancel: call.cancel,
+	}
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	if call.waiters == 0 {
+		resp.Body.Close()
+		return
+	}
+	call.stream = resp
+}
+
+// streamedBody is the body of a response that is too large to be shared.
+type streamedBody struct {
+	io.Reader
+	body   io.Closer
+	cancel context.CancelFunc
+}
+
+func (b *streamedBody) Close() error {
+	defer b.cancel()
+	return b.body.Close()
+}
+
+// forget removes call from the calls in flight, unless it was replaced
+// already. The lock must be held.
+func (rt *coalescingRoundTripper) forget(key string, call *coalescedCall) {
+	if rt.calls[key] == call {
+		delete(rt.calls, key)
+	}
+}
+
+func (rt *coalescingRoundTripper) CancelRequest(req *http.Request) {
+	tryCancelRequest(rt.WrappedRoundTripper(), req)
+}
+
+func (rt *coalescingRoundTripper) WrappedRoundTripper() http.RoundTripper { return rt.rt }
+
+// isCoalescable returns whether req may share a response with identical
+// requests.
+func isCoalescable(req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	if len(req.Header.Get("Upgrade")) > 0 {
+		return false
+	}
+	query := req.URL.Query()
+	for _, streaming := range []string{"watch", "follow"} {
+		switch query.Get(streaming) {
+		case "true", "1":
+			return false
+		}
+	}
+	return true
+}
+
+// perRequestHeaders differ for every request without changing the response,
+// they are left out of the coalescing key.
+var perRequestHeaders = map[string]bool{
+	"Traceparent": true,
+	"Tracestate":  true,
+	"User-Agent":  true,
+}
+
+// coalescingKey identifies the requests identical to req.
+func coalescingKey(req *http.Request) string {
+	var key strings.Builder
+	key.WriteString(req.URL.String())
+	key.WriteString("\n")
+	key.WriteString(req.Host)
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		if !perRequestHeaders[http.CanonicalHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			key.WriteString("\n")
+			key.WriteString(name)
+			key.WriteString(": ")
+			key.WriteString(value)
+		}
+	}
+	// the impersonation of the context is only added to the headers by
+	// round trippers that come after this one.
+	if impersonate, ok := ImpersonationFrom(req.Context()); ok {
+		fmt.Fprintf(&key, "\n%#v", impersonate)
+	}
+	return key.String()
+}
+
+// detachedContext carries the values of a context, but not its deadline and
+// cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+var _ utilnet.RoundTripperWrapper = &coalescingRoundTripper{}
@@ -0,0 +1,271 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// blockingServer answers requests once release is closed.
+type blockingServer struct {
+	*httptest.Server
+	release  chan struct{}
+	requests int32
+}
+
+func newBlockingServer() *blockingServer {
+	s := &blockingServer{release: make(chan struct{})}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.requests, 1)
+		select {
+		case <-s.release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Write([]byte("response for " + r.URL.String()))
+	}))
+	return s
+}
+
+func (s *blockingServer) count() int32 { return atomic.LoadInt32(&s.requests) }
+
+// waitForWaiters waits until n callers wait for the call of req.
+func waitForWaiters(t *testing.T, rt *coalescingRoundTripper, req *http.Request, n int) {
+	key := coalescingKey(req)
+	err := wait.PollImmediate(time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		rt.lock.Lock()
+		defer rt.lock.Unlock()
+		call, ok := rt.calls[key]
+		return ok && call.waiters == n, nil
+	})
+	if err != nil {
+		t.Fatalf("expected %d waiters: %v", n, err)
+	}
+}
+
+func TestCoalescingRoundTripper(t *testing.T) {
+	server := newBlockingServer()
+	defer closeTestServer(server.Server)
+	rt := NewCoalescingRoundTripper(0, &http.Transport{}).(*coalescingRoundTripper)
+
+	const callers = 5
+	req, _ := http.NewRequest("GET", server.URL+"/api/v1/namespaces/default/pods/foo", nil)
+	req.Header.Set("Accept", "application/json")
+
+	var wg sync.WaitGroup
+	bodies := make([]string, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := rt.RoundTrip(req.Clone(context.Background()))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			if resp.Header.Get("X-Path") != "/api/v1/namespaces/default/pods/foo" {
+				t.Errorf("unexpected headers %v", resp.Header)
+			}
+			// modifying the response must not affect the other callers.
+			resp.Header.Set("X-Path", "modified")
+			data, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			bodies[i] = string(data)
+		}(i)
+	}
+	waitForWaiters(t, rt, req, callers)
+	close(server.release)
+	wg.Wait()
+
+	if server.count() != 1 {
+		t.Errorf("expected a single request to the server, got %d", server.count())
+	}
+	for i, body := range bodies {
+		if body != "response for /api/v1/namespaces/default/pods/foo" {
+			t.Errorf("caller %d: unexpected body %q", i, body)
+		}
+	}
+
+	// once the response is delivered, new requests are sent again.
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if server.count() != 2 {
+		t.Errorf("expected a new request to the server, got %d", server.count())
+	}
+}
+
+func TestCoalescingRoundTripperNotCoalesced(t *testing.T) {
+	testCases := map[string]func(*http.Request){
+		"different headers": func(req *http.Request) { req.Header.Set("Authorization", "Bearer other") },
+		"watch":             func(req *http.Request) { req.URL.RawQuery = "watch=true" },
+		"follow":            func(req *http.Request) { req.URL.RawQuery = "follow=1" },
+		"upgrade":           func(req *http.Request) { req.Header.Set("Upgrade", "SPDY/3.1") },
+		"post":              func(req *http.Request) { req.Method = "POST" },
+		"body": func(req *http.Request) {
+			req.Body = ioutil.NopCloser(strings.NewReader("body"))
+		},
+		"impersonation": func(req *http.Request) {
+			*req = *req.WithContext(WithImpersonation(req.Context(), ImpersonationConfig{UserName: "other"}))
+		},
+	}
+	for name, modify := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := newBlockingServer()
+			defer closeTestServer(server.Server)
+			rt := NewCoalescingRoundTripper(0, &http.Transport{}).(*coalescingRoundTripper)
+
+			first, _ := http.NewRequest("GET", server.URL+"/api", nil)
+			first.Header.Set("Authorization", "Bearer token")
+			second := first.Clone(context.Background())
+			modify(second)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				if resp, err := rt.RoundTrip(first); err == nil {
+					resp.Body.Close()
+				}
+			}()
+			waitForWaiters(t, rt, first, 1)
+			go func() {
+				if resp, err := rt.RoundTrip(second); err == nil {
+					resp.Body.Close()
+				}
+			}()
+
+			err := wait.PollImmediate(time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+				return server.count() == 2, nil
+			})
+			if err != nil {
+				t.Errorf("expected both requests to reach the server, got %d", server.count())
+			}
+			close(server.release)
+			<-done
+		})
+	}
+}
+
+func TestCoalescingRoundTripperCancel(t *testing.T) {
+	server := newBlockingServer()
+	defer closeTestServer(server.Server)
+	rt := NewCoalescingRoundTripper(0, &http.Transport{}).(*coalescingRoundTripper)
+	req, _ := http.NewRequest("GET", server.URL+"/api", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		_, err := rt.RoundTrip(req.WithContext(ctx))
+		canceled <- err
+	}()
+	waitForWaiters(t, rt, req, 1)
+	result := make(chan error)
+	go func() {
+		resp, err := rt.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		result <- err
+	}()
+	waitForWaiters(t, rt, req, 2)
+
+	// the first caller giving up does not cancel the request of the second.
+	cancel()
+	if err := <-canceled; err != context.Canceled {
+		t.Errorf("expected the first caller to be canceled, got %v", err)
+	}
+	close(server.release)
+	if err := <-result; err != nil {
+		t.Errorf("unexpected error for the second caller: %v", err)
+	}
+	if server.count() != 1 {
+		t.Errorf("expected a single request to the server, got %d", server.count())
+	}
+}
+
+func TestCoalescingRoundTripperPerRequestHeaders(t *testing.T) {
+	first, _ := http.NewRequest("GET", "https://example.com/api", nil)
+	first.Header.Set("Accept", "application/json")
+	second := first.Clone(context.Background())
+	first.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	first.Header.Set("User-Agent", "kubectl (attribution)")
+	second.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-00f067aa0ba902b7-01")
+	second.Header.Set("User-Agent", "kubectl")
+
+	if coalescingKey(first) != coalescingKey(second) {
+		t.Errorf("expected requests that only differ in per-request headers to be coalesced")
+	}
+}
+
+func TestCoalescingRoundTripperTooLarge(t *testing.T) {
+	for _, callers := range []int{1, 3} {
+		t.Run(fmt.Sprintf("%d callers", callers), func(t *testing.T) {
+			server := newBlockingServer()
+			defer closeTestServer(server.Server)
+			// the bodies of the server are larger than the limit.
+			rt := NewCoalescingRoundTripper(4, &http.Transport{}).(*coalescingRoundTripper)
+			req, _ := http.NewRequest("GET", server.URL+"/api", nil)
+
+			var wg sync.WaitGroup
+			for i := 0; i < callers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					resp, err := rt.RoundTrip(req.Clone(context.Background()))
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					defer resp.Body.Close()
+					data, err := ioutil.ReadAll(resp.Body)
+					if err != nil {
+						t.Error(err)
+					}
+					if string(data) != "response for /api" {
+						t.Errorf("unexpected body %q", data)
+					}
+				}()
+			}
+			waitForWaiters(t, rt, req, callers)
+			close(server.release)
+			wg.Wait()
+
+			// the shared request, whose response is streamed to one of the
+			// callers, and one for every other caller.
+			if server.count() != int32(callers) {
+				t.Errorf("expected %d requests to the server, got %d", callers, server.count())
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/tools/metrics"
+	"k8s.io/klog/v2"
+)
+
+// compressingRoundTripper gzips the bodies of large requests, for servers
+// that accept compressed request bodies.
+type compressingRoundTripper struct {
+	threshold int
+	rt        http.RoundTripper
+
+	lock sync.Mutex
+	// unsupported holds the hosts that rejected a compressed request body.
+	unsupported map[string]bool
+}
+
+// NewCompressingRoundTripper returns a round tripper that sends the bodies of
+// POST, PUT and PATCH requests of at least threshold bytes gzipped, with a
+// 'Content-Encoding: gzip' header. Support for compressed bodies is learned
+// per server: if the server rejects a compressed body with a 415 response, the
+// request is sent once more uncompressed, and if that is not rejected the same
+// way, bodies are no longer compressed for that server. Other errors, like a
+// 400 for an invalid object, are returned as is. Since every request of a
+// server that does not accept compressed bodies is sent twice until this is
+// learned, it should only be used with servers known to accept them.
+func NewCompressingRoundTripper(threshold int, rt http.RoundTripper) http.RoundTripper {
+	return &compressingRoundTripper{threshold: threshold, rt: rt, unsupported: map[string]bool{}}
+}
+
+func (rt *compressingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !rt.shouldCompress(req) {
+		return rt.rt.RoundTrip(req)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	uncompressed := withBody(req, body)
+
+	compressed, err := gzipBody(body)
+	if err != nil || len(compressed) >= len(body) {
+		return rt.rt.RoundTrip(uncompressed)
+	}
+	metrics.RequestCompression.Observe(req.Context(), req.URL.Host, len(body), len(compressed))
+	compressedReq := withBody(req, compressed)
+	compressedReq.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := rt.rt.RoundTrip(compressedReq)
+	if err != nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+		return resp, err
+	}
+
+	// the server may not understand compressed bodies, try once more
+	// without compression.
+	readAndCloseBody(resp)
+	metrics.RequestCompressionRejected.Increment(req.Context(), strconv.Itoa(http.StatusUnsupportedMediaType), req.Method, req.URL.Host)
+	resp, err = rt.rt.RoundTrip(uncompressed)
+	if err == nil && resp.StatusCode != http.StatusUnsupportedMediaType {
+		klog.V(2).Infof("Server %s rejected a compressed request body, disabling request compression", req.URL.Host)
+		rt.lock.Lock()
+		rt.unsupported[req.URL.Host] = true
+		rt.lock.Unlock()
+	}
+	return resp, err
+}
+
+// shouldCompress returns whether the body of req is compressed.
+func (rt *compressingRoundTripper) shouldCompress(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return false
+	}
+	if req.Body == nil || req.Body == http.NoBody || len(req.Header.Get("Content-Encoding")) > 0 {
+		return false
+	}
+	// the length of streamed bodies is not known upfront.
+	if req.ContentLength < int64(rt.threshold) {
+		return false
+	}
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return !rt.unsupported[req.URL.Host]
+}
+
+// withBody returns a copy of req that sends body.
+func withBody(req *http.Request, body []byte) *http.Request {
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return req
+}
+
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readAndCloseBody drains a small response body so that the connection can be
+// reused, and closes it.
+func readAndCloseBody(resp *http.Response) {
+	io.CopyN(ioutil.Discard, resp.Body, 4096)
+	resp.Body.Close()
+}
+
+func (rt *compressingRoundTripper) CancelRequest(req *http.Request) {
+	tryCancelRequest(rt.WrappedRoundTripper(), req)
+}
+
+func (rt *compressingRoundTripper) WrappedRoundTripper() http.RoundTripper { return rt.rt }
+
+var _ utilnet.RoundTripperWrapper = &compressingRoundTripper{}
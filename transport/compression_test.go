@@ -0,0 +1,159 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// compressionServer records the requests it receives, and decompresses their
+// bodies if it supports compression.
+type compressionServer struct {
+	*httptest.Server
+	lock      sync.Mutex
+	encodings []string
+	bodies    []string
+}
+
+func newCompressionServer(t *testing.T, supported bool) *compressionServer {
+	s := &compressionServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.Header.Get("Content-Encoding")
+		var body io.Reader = r.Body
+		if encoding == "gzip" {
+			if !supported {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			body = gz
+		}
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Error(err)
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.encodings = append(s.encodings, encoding)
+		s.bodies = append(s.bodies, string(data))
+	}))
+	return s
+}
+
+func (s *compressionServer) expect(t *testing.T, encodings []string, body string) {
+	t.Helper()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if strings.Join(s.encodings, ",") != strings.Join(encodings, ",") {
+		t.Errorf("expected encodings %q, got %q", encodings, s.encodings)
+	}
+	for i, received := range s.bodies {
+		if received != body {
+			t.Errorf("request %d: unexpected body of %d bytes", i, len(received))
+		}
+	}
+	s.encodings, s.bodies = nil, nil
+}
+
+func TestCompressingRoundTripper(t *testing.T) {
+	server := newCompressionServer(t, true)
+	defer closeTestServer(server.Server)
+	rt := NewCompressingRoundTripper(1024, &http.Transport{})
+	large := strings.Repeat(`{"kind":"ConfigMap"}`, 100)
+
+	send := func(method, body string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL, bytes.NewReader([]byte(body)))
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status %d", resp.StatusCode)
+		}
+	}
+
+	send("POST", large)
+	server.expect(t, []string{"gzip"}, large)
+	send("PATCH", large)
+	server.expect(t, []string{"gzip"}, large)
+	send("POST", "{}")
+	server.expect(t, []string{""}, "{}")
+	send("DELETE", large)
+	server.expect(t, []string{""}, large)
+}
+
+func TestCompressingRoundTripperUnsupported(t *testing.T) {
+	server := newCompressionServer(t, false)
+	defer closeTestServer(server.Server)
+	rt := NewCompressingRoundTripper(1024, &http.Transport{})
+	large := strings.Repeat(`{"kind":"ConfigMap"}`, 100)
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("PUT", server.URL, bytes.NewReader([]byte(large)))
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected the uncompressed request to succeed, got %d", resp.StatusCode)
+		}
+	}
+	// the rejected request is retried uncompressed, and later requests are
+	// no longer compressed.
+	server.expect(t, []string{"", ""}, large)
+}
+
+func TestCompressingRoundTripperBadRequest(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		// an invalid object, regardless of the encoding of the body.
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer closeTestServer(server)
+	rt := NewCompressingRoundTripper(1024, &http.Transport{})
+	large := strings.Repeat(`{"kind":"ConfigMap"}`, 100)
+
+	req, _ := http.NewRequest("POST", server.URL, bytes.NewReader([]byte(large)))
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected the error of the server, got %d", resp.StatusCode)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected the request to be sent once, got %d", n)
+	}
+}
@@ -22,6 +22,9 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"time"
+
+	"k8s.io/client-go/util/flowcontrol"
 )
 
 // Config holds various options for establishing a transport.
@@ -73,15 +76,47 @@ type Config struct {
 	// Proxy is the proxy func to be used for all requests made by this
 	// transport. If Proxy is nil, http.ProxyFromEnvironment is used. If Proxy
 	// returns a nil *URL, no proxy is used.
-	//
-	// socks5 proxying does not currently support spdy streaming endpoints.
 	Proxy func(*http.Request) (*url.URL, error)
+
+	// HTTP2ReadIdleTimeout is the time after which a health check using a
+	// ping frame is carried out if no frame is received on an HTTP/2
+	// connection. If zero, the value of the HTTP2_READ_IDLE_TIMEOUT_SECONDS
+	// environment variable is used, which defaults to 30 seconds. A negative
+	// value disables the health check.
+	HTTP2ReadIdleTimeout time.Duration
+
+	// HTTP2PingTimeout is the time after which an HTTP/2 connection is
+	// closed if no response to a health check ping is received. If zero,
+	// the value of the HTTP2_PING_TIMEOUT_SECONDS environment variable is
+	// used, which defaults to 15 seconds.
+	HTTP2PingTimeout time.Duration
+
+	// CoalesceRequests enables coalescing of concurrent identical GET
+	// requests into a single request to the server, whose response is
+	// copied for every caller. Watches, followed logs, upgrades and
+	// requests with a body are never coalesced.
+	CoalesceRequests bool
+
+	// MaxCoalescedResponseBytes is the size of the largest response body that
+	// is read into memory to be shared by coalesced requests. A larger
+	// response is streamed to one of the callers, and the requests of the
+	// others are sent again on their own. Defaults to 10MiB.
+	MaxCoalescedResponseBytes int64
+
+	// InFlightLimiter, if set, limits the number of requests in flight.
+	InFlightLimiter flowcontrol.InFlightLimiter
+
+	// RequestCompressionThreshold, if greater than zero, is the size in bytes
+	// from which request bodies are sent gzipped, if the server accepts it.
+	RequestCompressionThreshold int
 }
 
 // ImpersonationConfig has all the available impersonation options
 type ImpersonationConfig struct {
 	// UserName matches user.Info.GetName()
 	UserName string
+	// UID matches user.Info.GetUID()
+	UID string
 	// Groups matches user.Info.GetGroups()
 	Groups []string
 	// Extra matches user.Info.GetExtra()
@@ -93,6 +128,11 @@ func (c *Config) HasCA() bool {
 	return len(c.TLS.CAData) > 0 || len(c.TLS.CAFile) > 0
 }
 
+// reloadsCAFile returns whether the CAs are reloaded from the CA file.
+func (c *Config) reloadsCAFile() bool {
+	return c.TLS.ReloadCAFile && len(c.TLS.CAFile) > 0
+}
+
 // HasBasicAuth returns whether the configuration has basic authentication or not.
 func (c *Config) HasBasicAuth() bool {
 	return len(c.Username) != 0
@@ -127,6 +167,7 @@ type TLSConfig struct {
 	CertFile       string // Path of the PEM-encoded client certificate.
 	KeyFile        string // Path of the PEM-encoded client key.
 	ReloadTLSFiles bool   // Set to indicate that the original config provided files, and that they should be reloaded
+	ReloadCAFile   bool   // Set to reload CAFile when it changes. The CAs are then always read from CAFile, and CAData is ignored.
 
 	Insecure   bool   // Server should be accessed without verifying the certificate. For testing only.
 	ServerName string // Override for the server name passed to the server for SNI and used to verify certificates.
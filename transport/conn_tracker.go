@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/tools/metrics"
+)
+
+// connTracker counts the open connections of a cached transport, and records
+// when the transport was last used.
+type connTracker struct {
+	// id identifies the transport in metrics, without revealing its
+	// credentials.
+	id  string
+	now func() time.Time
+
+	open int64
+	// used is the time of the last use in Unix nanoseconds.
+	used int64
+	// evicted is set once the transport is evicted from the cache, its
+	// connections are no longer reported then.
+	evicted int32
+}
+
+func newConnTracker(key tlsCacheKey, now func() time.Time) *connTracker {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s, certFile:%s, keyFile:%s, nextProtos:%s", key, key.certFile, key.keyFile, key.nextProtos)
+	t := &connTracker{id: fmt.Sprintf("%08x", h.Sum32()), now: now}
+	t.touch()
+	return t
+}
+
+// touch records that the transport is used.
+func (t *connTracker) touch() {
+	atomic.StoreInt64(&t.used, t.now().UnixNano())
+}
+
+// lastUsed returns when the transport was last handed out, or opened or closed
+// one of its connections.
+func (t *connTracker) lastUsed() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&t.used))
+}
+
+// openConns returns the number of open connections.
+func (t *connTracker) openConns() int64 {
+	return atomic.LoadInt64(&t.open)
+}
+
+// dial wraps dial to track the connections it opens.
+func (t *connTracker) dial(dial utilnet.DialFunc) utilnet.DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		t.touch()
+		t.report(atomic.AddInt64(&t.open, 1))
+		return &trackedConn{Conn: conn, tracker: t}, nil
+	}
+}
+
+func (t *connTracker) report(open int64) {
+	if atomic.LoadInt32(&t.evicted) == 0 {
+		metrics.TransportConnections.Set(t.id, int(open))
+	}
+}
+
+// evict stops reporting the connections of the transport, which is no longer
+// cached.
+func (t *connTracker) evict() {
+	atomic.StoreInt32(&t.evicted, 1)
+	metrics.TransportConnections.Set(t.id, 0)
+}
+
+type trackedConn struct {
+	net.Conn
+	tracker *connTracker
+	once    sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.tracker.touch()
+		c.tracker.report(atomic.AddInt64(&c.tracker.open, -1))
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+type impersonationKey struct{}
+
+// WithImpersonation returns a copy of ctx that makes the requests sent with it
+// impersonate the user of impersonate, in place of the impersonation of the
+// client config. This allows a single client to act on behalf of many users.
+// A client that is configured to impersonate a user fails requests that
+// impersonate a different user.
+func WithImpersonation(ctx context.Context, impersonate ImpersonationConfig) context.Context {
+	return context.WithValue(ctx, impersonationKey{}, impersonate)
+}
+
+// ImpersonationFrom returns the impersonation set on ctx with
+// WithImpersonation, if any.
+func ImpersonationFrom(ctx context.Context) (ImpersonationConfig, bool) {
+	impersonate, ok := ctx.Value(impersonationKey{}).(ImpersonationConfig)
+	return impersonate, ok
+}
+
+// IsZero returns whether c impersonates nobody.
+func (c ImpersonationConfig) IsZero() bool {
+	return len(c.UserName) == 0 && len(c.UID) == 0 && len(c.Groups) == 0 && len(c.Extra) == 0
+}
+
+// ValidateImpersonation checks that the impersonation requested for a single
+// request can be sent by a client that is configured with the impersonation
+// configured. Impersonation cannot be chained, so a client that impersonates
+// a user can only send requests on behalf of that same user.
+func ValidateImpersonation(configured, requested ImpersonationConfig) error {
+	if len(requested.UserName) == 0 {
+		return fmt.Errorf("impersonation requires a user name, got %#v", requested)
+	}
+	if configured.IsZero() || reflect.DeepEqual(configured, requested) {
+		return nil
+	}
+	return fmt.Errorf("cannot impersonate user %q, the client is configured to impersonate user %q", requested.UserName, configured.UserName)
+}
+
+// SetImpersonationHeaders sets the headers of header that impersonate the user
+// of impersonate.
+func SetImpersonationHeaders(header http.Header, impersonate ImpersonationConfig) {
+	header.Set(ImpersonateUserHeader, impersonate.UserName)
+	if len(impersonate.UID) > 0 {
+		header.Set(ImpersonateUIDHeader, impersonate.UID)
+	}
+	for _, group := range impersonate.Groups {
+		header.Add(ImpersonateGroupHeader, group)
+	}
+	for k, vv := range impersonate.Extra {
+		for _, v := range vv {
+			header.Add(ImpersonateUserExtraHeaderPrefix+headerKeyEscape(k), v)
+		}
+	}
+}
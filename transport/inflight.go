@@ -0,0 +1,134 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// headerStreamProtocolVersion is the header that upgrade requests for exec,
+// attach and port forwarding are sent with.
+const headerStreamProtocolVersion = "X-Stream-Protocol-Version"
+
+type inFlightRoundTripper struct {
+	limiter flowcontrol.InFlightLimiter
+	rt      http.RoundTripper
+}
+
+// NewInFlightRoundTripper returns a round tripper that takes a slot from
+// limiter for every request, and gives it back once the response body is
+// closed. Watches, followed logs and upgrade requests are long-running
+// requests, all others are short requests. Requests wait for a slot until
+// their context is done. It can be used as a WrapperFunc.
+func NewInFlightRoundTripper(limiter flowcontrol.InFlightLimiter, rt http.RoundTripper) http.RoundTripper {
+	return &inFlightRoundTripper{limiter: limiter, rt: rt}
+}
+
+func (rt *inFlightRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := rt.limiter.Acquire(req.Context(), isLongRunning(req))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rt.rt.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if resp.Body == nil {
+		release()
+		return resp, nil
+	}
+	body := &inFlightBody{ReadCloser: resp.Body, release: release}
+	if w, ok := resp.Body.(io.Writer); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		// the body of an upgraded connection is the connection itself, keep
+		// it writable.
+		resp.Body = &inFlightUpgradedBody{inFlightBody: body, Writer: w}
+		return resp, nil
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+func (rt *inFlightRoundTripper) CancelRequest(req *http.Request) {
+	tryCancelRequest(rt.WrappedRoundTripper(), req)
+}
+
+func (rt *inFlightRoundTripper) WrappedRoundTripper() http.RoundTripper { return rt.rt }
+
+// isLongRunning returns whether req is expected to stay in flight for long.
+func isLongRunning(req *http.Request) bool {
+	if len(req.Header.Get("Upgrade")) > 0 || len(req.Header.Get(headerStreamProtocolVersion)) > 0 {
+		return true
+	}
+	query := req.URL.Query()
+	for _, streaming := range []string{"watch", "follow"} {
+		switch query.Get(streaming) {
+		case "true", "1":
+			return true
+		}
+	}
+	return false
+}
+
+// inFlightBody gives back the slot of a request when it is closed, unless the
+// slot was handed over with HoldInFlight.
+type inFlightBody struct {
+	io.ReadCloser
+	release func()
+	held    int32
+}
+
+func (b *inFlightBody) Close() error {
+	err := b.ReadCloser.Close()
+	if atomic.LoadInt32(&b.held) == 0 {
+		b.release()
+	}
+	return err
+}
+
+// inFlightUpgradedBody is the inFlightBody of a connection upgraded by the
+// http.Transport, like a WebSocket connection.
+type inFlightUpgradedBody struct {
+	*inFlightBody
+	io.Writer
+}
+
+// HoldInFlight keeps the in-flight slot of the request of resp taken when the
+// response body is closed, and returns the function that gives it back. This
+// is meant for upgraded connections, which stay in flight after the body of
+// the upgrade response is closed. If the request did not take a slot, the
+// returned function does nothing.
+func HoldInFlight(resp *http.Response) func() {
+	var b *inFlightBody
+	switch body := resp.Body.(type) {
+	case *inFlightBody:
+		b = body
+	case *inFlightUpgradedBody:
+		b = body.inFlightBody
+	default:
+		return func() {}
+	}
+	atomic.StoreInt32(&b.held, 1)
+	return b.release
+}
+
+var _ utilnet.RoundTripperWrapper = &inFlightRoundTripper{}
@@ -0,0 +1,144 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func TestInFlightRoundTripper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer closeTestServer(server)
+	limiter := flowcontrol.NewMaxInFlightLimiter(1, 1)
+	rt := NewInFlightRoundTripper(limiter, &http.Transport{})
+
+	get := func(ctx context.Context, query string) (*http.Response, error) {
+		req, _ := http.NewRequest("GET", server.URL+"/api/v1/pods"+query, nil)
+		return rt.RoundTrip(req.WithContext(ctx))
+	}
+	expectInFlight := func(short, long int) {
+		t.Helper()
+		if s, l := limiter.InFlight(); s != short || l != long {
+			t.Errorf("expected %d short and %d long-running requests in flight, got %d and %d", short, long, s, l)
+		}
+	}
+
+	first, err := get(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the slot is held until the body is closed.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := get(ctx, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the second request to time out waiting, got %v", err)
+	}
+	watch, err := get(context.Background(), "?watch=true")
+	if err != nil {
+		t.Fatalf("expected the watch to use a separate limit: %v", err)
+	}
+	expectInFlight(1, 1)
+
+	first.Body.Close()
+	second, err := get(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Body.Close()
+	watch.Body.Close()
+	expectInFlight(0, 0)
+}
+
+func TestHoldInFlight(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer closeTestServer(server)
+	limiter := flowcontrol.NewMaxInFlightLimiter(0, 1)
+	rt := NewInFlightRoundTripper(limiter, &http.Transport{})
+
+	req, _ := http.NewRequest("POST", server.URL+"/api/v1/namespaces/default/pods/foo/exec", nil)
+	req.Header.Set(headerStreamProtocolVersion, "v4.channel.k8s.io")
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := HoldInFlight(resp)
+	resp.Body.Close()
+	if _, long := limiter.InFlight(); long != 1 {
+		t.Errorf("expected the held request to stay in flight, got %d", long)
+	}
+	release()
+	if _, long := limiter.InFlight(); long != 0 {
+		t.Errorf("expected the request to be released, got %d", long)
+	}
+
+	// responses of requests that took no slot can be held too.
+	HoldInFlight(&http.Response{Body: http.NoBody})()
+}
+
+func TestInFlightRoundTripperUpgrade(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		buf.Flush()
+		line, _ := buf.ReadString('\n')
+		conn.Write([]byte(line))
+	}))
+	defer closeTestServer(server)
+	limiter := flowcontrol.NewMaxInFlightLimiter(0, 1)
+	rt := NewInFlightRoundTripper(limiter, &http.Transport{})
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the upgraded connection can be written to.
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		t.Fatalf("expected the body of the upgraded connection to be writable, got %T", resp.Body)
+	}
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "ping\n" {
+		t.Errorf("expected the connection to echo, got %q, %v", line, err)
+	}
+	if _, long := limiter.InFlight(); long != 1 {
+		t.Errorf("expected the upgraded connection to be in flight, got %d", long)
+	}
+	conn.Close()
+	if _, long := limiter.InFlight(); long != 0 {
+		t.Errorf("expected the connection to be released, got %d", long)
+	}
+}
@@ -0,0 +1,361 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// RecorderMode selects whether a Recorder talks to a real server or
+// replays a cassette.
+type RecorderMode int
+
+const (
+	// RecorderModeRecord sends requests to the wrapped round tripper and
+	// records every request and response.
+	RecorderModeRecord RecorderMode = iota
+	// RecorderModeReplay never sends a request and answers from the
+	// interactions loaded from the cassette instead.
+	RecorderModeReplay
+)
+
+// RecorderMatch selects a request field that must be identical for a
+// recorded interaction to be replayed.
+type RecorderMatch int
+
+const (
+	// MatchMethod compares the HTTP method of requests.
+	MatchMethod RecorderMatch = iota
+	// MatchPath compares the URL path of requests.
+	MatchPath
+	// MatchQuery compares the query parameters of requests, regardless of
+	// their order.
+	MatchQuery
+	// MatchBody compares the body of requests.
+	MatchBody
+)
+
+// Recorder records the requests and responses passing through the round
+// trippers it wraps to a cassette file, and replays them later so that code
+// using a real client can be tested without a server. Response bodies are
+// recorded as the chunks the client read, so that watch streams are replayed
+// event by event. Credentials in headers are masked before recording.
+//
+// A Recorder is typically installed with config.Wrap(recorder.Wrap), and in
+// record mode Save must be called once the test is done.
+type Recorder struct {
+	path  string
+	mode  RecorderMode
+	match map[RecorderMatch]bool
+
+	mu           sync.Mutex
+	interactions []*recordedInteraction
+	// replayed marks the interactions that have been replayed already.
+	replayed []bool
+}
+
+// cassette is the format of a cassette file.
+type cassette struct {
+	Interactions []*recordedInteraction `json:"interactions"`
+}
+
+type recordedInteraction struct {
+	Request  recordedRequest   `json:"request"`
+	Response *recordedResponse `json:"response,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+type recordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+type recordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	// Chunks holds the response body in the pieces it was read by the client.
+	Chunks [][]byte `json:"chunks,omitempty"`
+	// EOF is set if the client read the body to the end. If it is not set,
+	// the client closed the body early, like when stopping a watch, and
+	// the replayed body blocks after the last chunk until it is closed.
+	EOF bool `json:"eof,omitempty"`
+}
+
+// NewRecorder returns a Recorder for the cassette at path. In replay mode
+// the cassette is loaded immediately. Requests are matched on the given
+// fields, or on method, path and query if none are given.
+func NewRecorder(path string, mode RecorderMode, match ...RecorderMatch) (*Recorder, error) {
+	if len(match) == 0 {
+		match = []RecorderMatch{MatchMethod, MatchPath, MatchQuery}
+	}
+	r := &Recorder{
+		path:  path,
+		mode:  mode,
+		match: make(map[RecorderMatch]bool, len(match)),
+	}
+	for _, m := range match {
+		r.match[m] = true
+	}
+
+	switch mode {
+	case RecorderModeRecord:
+	case RecorderModeReplay:
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette: %v", err)
+		}
+		var c cassette
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("failed to decode cassette %s: %v", path, err)
+		}
+		r.interactions = c.Interactions
+		r.replayed = make([]bool, len(c.Interactions))
+	default:
+		return nil, fmt.Errorf("unknown recorder mode %d", mode)
+	}
+	return r, nil
+}
+
+// Wrap returns a round tripper that records or replays requests sent through
+// rt. It can be used as a WrapperFunc.
+func (r *Recorder) Wrap(rt http.RoundTripper) http.RoundTripper {
+	return &recordingRoundTripper{recorder: r, rt: rt}
+}
+
+// Save writes the interactions recorded so far to the cassette. Bodies that
+// are still being read, like open watches, are saved as read so far.
+func (r *Recorder) Save() error {
+	if r.mode != RecorderModeRecord {
+		return nil
+	}
+	r.mu.Lock()
+	data, err := json.MarshalIndent(&cassette{Interactions: r.interactions}, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, data, 0600)
+}
+
+type recordingRoundTripper struct {
+	recorder *Recorder
+	rt       http.RoundTripper
+}
+
+var _ utilnet.RoundTripperWrapper = &recordingRoundTripper{}
+
+func (rt *recordingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	if rt.recorder.mode == RecorderModeReplay {
+		return rt.recorder.replay(req, body)
+	}
+	return rt.recorder.record(rt.rt, req, body)
+}
+
+func (rt *recordingRoundTripper) CancelRequest(req *http.Request) {
+	tryCancelRequest(rt.WrappedRoundTripper(), req)
+}
+
+func (rt *recordingRoundTripper) WrappedRoundTripper() http.RoundTripper { return rt.rt }
+
+func (r *Recorder) record(rt http.RoundTripper, req *http.Request, body []byte) (*http.Response, error) {
+	interaction := &recordedInteraction{
+		Request: recordedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: RedactHeader(req.Header),
+			Body:   body,
+		},
+	}
+
+	resp, err := rt.RoundTrip(req)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, interaction)
+	if err != nil {
+		interaction.Error = err.Error()
+		return nil, err
+	}
+	interaction.Response = &recordedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     RedactHeader(resp.Header),
+	}
+	resp.Body = &recordingBody{ReadCloser: resp.Body, recorder: r, response: interaction.Response}
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.interactions {
+		if r.replayed[i] || !r.matches(interaction, req, body) {
+			continue
+		}
+		r.replayed[i] = true
+
+		if interaction.Response == nil {
+			return nil, fmt.Errorf("%s", interaction.Error)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+			StatusCode:    interaction.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        interaction.Response.Header.Clone(),
+			Body:          newReplayBody(interaction.Response),
+			ContentLength: -1,
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("no recorded interaction for %s %s", req.Method, req.URL)
+}
+
+// matches returns whether interaction was recorded for a request equal to
+// req in the fields selected by the recorder.
+func (r *Recorder) matches(interaction *recordedInteraction, req *http.Request, body []byte) bool {
+	recorded := &interaction.Request
+	if r.match[MatchMethod] && recorded.Method != req.Method {
+		return false
+	}
+	if r.match[MatchBody] && !bytes.Equal(recorded.Body, body) {
+		return false
+	}
+	if !r.match[MatchPath] && !r.match[MatchQuery] {
+		return true
+	}
+	u, err := req.URL.Parse(recorded.URL)
+	if err != nil {
+		return false
+	}
+	if r.match[MatchPath] && u.Path != req.URL.Path {
+		return false
+	}
+	if r.match[MatchQuery] && !reflect.DeepEqual(u.Query(), req.URL.Query()) {
+		return false
+	}
+	return true
+}
+
+// recordingBody records the chunks read from a response body.
+type recordingBody struct {
+	io.ReadCloser
+	recorder *Recorder
+	response *recordedResponse
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.recorder.mu.Lock()
+	defer b.recorder.mu.Unlock()
+	if n > 0 {
+		b.response.Chunks = append(b.response.Chunks, append([]byte(nil), p[:n]...))
+	}
+	if err == io.EOF {
+		b.response.EOF = true
+	}
+	return n, err
+}
+
+// replayBody returns the recorded chunks of a response body one by one.
+type replayBody struct {
+	chunks [][]byte
+	eof    bool
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newReplayBody(resp *recordedResponse) *replayBody {
+	return &replayBody{
+		chunks: append([][]byte(nil), resp.Chunks...),
+		eof:    resp.EOF,
+		closed: make(chan struct{}),
+	}
+}
+
+func (b *replayBody) Read(p []byte) (int, error) {
+	if len(b.chunks) == 0 {
+		if !b.eof {
+			// the client stopped reading the original response before it
+			// ended, so pretend there is nothing more to read yet.
+			<-b.closed
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, b.chunks[0])
+	if n < len(b.chunks[0]) {
+		b.chunks[0] = b.chunks[0][n:]
+	} else {
+		b.chunks = b.chunks[1:]
+	}
+	return n, nil
+}
+
+func (b *replayBody) Close() error {
+	b.closeOnce.Do(func() { close(b.closed) })
+	return nil
+}
+
+// RedactHeader returns a copy of header with the credentials in it masked,
+// like they are when headers are logged at high verbosity.
+func RedactHeader(header http.Header) http.Header {
+	if header == nil {
+		return nil
+	}
+	redacted := make(http.Header, len(header))
+	for key, values := range header {
+		masked := make([]string, 0, len(values))
+		for _, value := range values {
+			switch {
+			case strings.EqualFold(key, "Proxy-Authorization"):
+				value = maskValue("Authorization", value)
+			case strings.EqualFold(key, "Cookie"), strings.EqualFold(key, "Set-Cookie"):
+				value = "<masked>"
+			default:
+				value = maskValue(key, value)
+			}
+			masked = append(masked, value)
+		}
+		redacted[key] = masked
+	}
+	return redacted
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestRecorderRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.json")
+
+	watchDone := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		switch {
+		case req.URL.Query().Get("watch") == "true":
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(w, "event %d\n", i)
+				w.(http.Flusher).Flush()
+			}
+			// keep the stream open until the client goes away.
+			select {
+			case <-req.Context().Done():
+			case <-watchDone:
+			}
+		case req.Method == "POST":
+			body, _ := ioutil.ReadAll(req.Body)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, "created %s", body)
+		default:
+			fmt.Fprintf(w, "list %s", req.URL.Query().Get("limit"))
+		}
+	}))
+	defer closeTestServer(server)
+	defer close(watchDone)
+
+	// send the same requests in record and replay mode.
+	run := func(t *testing.T, rt http.RoundTripper, host string) {
+		client := &http.Client{Transport: rt}
+		do := func(method, url, body string) *http.Response {
+			req, err := http.NewRequest(method, host+url, strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer secret-token")
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("%s %s failed: %v", method, url, err)
+			}
+			return resp
+		}
+		expectBody := func(resp *http.Response, expected string) {
+			defer resp.Body.Close()
+			data, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != expected {
+				t.Errorf("Expected body %q, got %q", expected, data)
+			}
+		}
+
+		expectBody(do("GET", "/api/v1/pods?limit=1&resourceVersion=0", ""), "list 1")
+		expectBody(do("GET", "/api/v1/pods?limit=2", ""), "list 2")
+		resp := do("POST", "/api/v1/pods", "foo")
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+		expectBody(resp, "created foo")
+
+		resp = do("GET", "/api/v1/pods?watch=true", "")
+		reader := bufio.NewReader(resp.Body)
+		for i := 0; i < 3; i++ {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := fmt.Sprintf("event %d\n", i); line != expected {
+				t.Errorf("Expected event %q, got %q", expected, line)
+			}
+		}
+		resp.Body.Close()
+	}
+
+	recorder, err := NewRecorder(path, RecorderModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("record", func(t *testing.T) {
+		run(t, recorder.Wrap(&http.Transport{}), server.URL)
+	})
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret-token", "session=secret"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("Expected %q to be redacted in the cassette:\n%s", secret, data)
+		}
+	}
+
+	replayer, err := NewRecorder(path, RecorderModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("replay", func(t *testing.T) {
+		// no server is needed, and the host is not matched.
+		run(t, replayer.Wrap(nil), "http://replay.invalid")
+	})
+
+	if _, err := (&http.Client{Transport: replayer.Wrap(nil)}).Get("http://replay.invalid/api/v1/pods?limit=1&resourceVersion=0"); err == nil {
+		t.Errorf("Expected an error replaying an interaction twice")
+	}
+}
+
+func TestRecorderMatch(t *testing.T) {
+	c := &cassette{}
+	for _, body := range []string{"a", "b"} {
+		c.Interactions = append(c.Interactions, &recordedInteraction{
+			Request:  recordedRequest{Method: "PUT", URL: "https://127.0.0.1:6443/api?x=1&y=2", Body: []byte(body)},
+			Response: &recordedResponse{StatusCode: http.StatusOK, Chunks: [][]byte{[]byte(body)}, EOF: true},
+		})
+	}
+
+	tests := []struct {
+		name     string
+		match    []RecorderMatch
+		method   string
+		url      string
+		body     string
+		expected string
+	}{
+		{name: "default match", method: "PUT", url: "/api?y=2&x=1", body: "b", expected: "a"},
+		{name: "body match", match: []RecorderMatch{MatchBody}, method: "PUT", url: "/other", body: "b", expected: "b"},
+		{name: "method mismatch", method: "POST", url: "/api?x=1&y=2", body: "a"},
+		{name: "path mismatch", method: "PUT", url: "/apis?x=1&y=2", body: "a"},
+		{name: "query mismatch", method: "PUT", url: "/api?x=1", body: "a"},
+		{name: "body mismatch", match: []RecorderMatch{MatchMethod, MatchBody}, method: "PUT", url: "/api?x=1&y=2", body: "c"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &Recorder{mode: RecorderModeReplay, interactions: c.Interactions, replayed: make([]bool, len(c.Interactions))}
+			if len(test.match) == 0 {
+				test.match = []RecorderMatch{MatchMethod, MatchPath, MatchQuery}
+			}
+			r.match = map[RecorderMatch]bool{}
+			for _, m := range test.match {
+				r.match[m] = true
+			}
+
+			req, _ := http.NewRequest(test.method, "http://localhost"+test.url, strings.NewReader(test.body))
+			resp, err := r.Wrap(nil).RoundTrip(req)
+			if len(test.expected) == 0 {
+				if err == nil {
+					t.Fatalf("Expected no match")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := ioutil.ReadAll(resp.Body)
+			if string(data) != test.expected {
+				t.Errorf("Expected body %q, got %q", test.expected, data)
+			}
+		})
+	}
+}
+
+func TestRecorderReplayOpenStream(t *testing.T) {
+	r := &Recorder{
+		mode:  RecorderModeReplay,
+		match: map[RecorderMatch]bool{MatchPath: true},
+		interactions: []*recordedInteraction{{
+			Request:  recordedRequest{Method: "GET", URL: "/watch"},
+			Response: &recordedResponse{StatusCode: http.StatusOK, Chunks: [][]byte{[]byte("event")}},
+		}},
+		replayed: make([]bool, 1),
+	}
+	req, _ := http.NewRequest("GET", "http://localhost/watch", nil)
+	resp, err := r.Wrap(nil).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(resp.Body)
+		read <- data
+	}()
+	select {
+	case data := <-read:
+		t.Fatalf("Expected the stream to stay open, got %q", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	resp.Body.Close()
+	select {
+	case data := <-read:
+		if string(data) != "event" {
+			t.Errorf("Expected %q, got %q", "event", data)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("Expected the stream to end when closed")
+	}
+}
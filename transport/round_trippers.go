@@ -56,11 +56,19 @@ func HTTPWrappersForConfig(config *Config, rt http.RoundTripper) (http.RoundTrip
 	if len(config.UserAgent) > 0 {
 		rt = NewUserAgentRoundTripper(config.UserAgent, rt)
 	}
-	if len(config.Impersonate.UserName) > 0 ||
-		len(config.Impersonate.Groups) > 0 ||
-		len(config.Impersonate.Extra) > 0 {
+	if !config.Impersonate.IsZero() {
 		rt = NewImpersonatingRoundTripper(config.Impersonate, rt)
 	}
+	if config.RequestCompressionThreshold > 0 {
+		rt = NewCompressingRoundTripper(config.RequestCompressionThreshold, rt)
+	}
+	if config.InFlightLimiter != nil {
+		rt = NewInFlightRoundTripper(config.InFlightLimiter, rt)
+	}
+	if config.CoalesceRequests {
+		// coalesced requests take a single in-flight slot.
+		rt = NewCoalescingRoundTripper(config.MaxCoalescedResponseBytes, rt)
+	}
 	return rt, nil
 }
 
@@ -199,6 +207,9 @@ const (
 	// ImpersonateUserHeader is used to impersonate a particular user during an API server request
 	ImpersonateUserHeader = "Impersonate-User"
 
+	// ImpersonateUIDHeader is used to impersonate a particular UID during an API server request
+	ImpersonateUIDHeader = "Impersonate-Uid"
+
 	// ImpersonateGroupHeader is used to impersonate a particular group during an API server request.
 	// It can be repeated multiplied times for multiple groups.
 	ImpersonateGroupHeader = "Impersonate-Group"
@@ -219,26 +230,26 @@ type impersonatingRoundTripper struct {
 }
 
 // NewImpersonatingRoundTripper will add an Act-As header to a request unless it has already been set.
+// The impersonation set on the context of a request with WithImpersonation takes precedence over
+// impersonate, if they don't conflict. A zero impersonate only adds the headers of the context.
 func NewImpersonatingRoundTripper(impersonate ImpersonationConfig, delegate http.RoundTripper) http.RoundTripper {
 	return &impersonatingRoundTripper{impersonate, delegate}
 }
 
 func (rt *impersonatingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	impersonate := rt.impersonate
+	if requested, ok := ImpersonationFrom(req.Context()); ok {
+		if err := ValidateImpersonation(rt.impersonate, requested); err != nil {
+			return nil, err
+		}
+		impersonate = requested
+	}
 	// use the user header as marker for the rest.
-	if len(req.Header.Get(ImpersonateUserHeader)) != 0 {
+	if len(req.Header.Get(ImpersonateUserHeader)) != 0 || impersonate.IsZero() {
 		return rt.delegate.RoundTrip(req)
 	}
 	req = utilnet.CloneRequest(req)
-	req.Header.Set(ImpersonateUserHeader, rt.impersonate.UserName)
-
-	for _, group := range rt.impersonate.Groups {
-		req.Header.Add(ImpersonateGroupHeader, group)
-	}
-	for k, vv := range rt.impersonate.Extra {
-		for _, v := range vv {
-			req.Header.Add(ImpersonateUserExtraHeaderPrefix+headerKeyEscape(k), v)
-		}
-	}
+	SetImpersonationHeaders(req.Header, impersonate)
 
 	return rt.delegate.RoundTrip(req)
 }
@@ -18,6 +18,7 @@ package transport
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -273,6 +274,87 @@ func TestImpersonationRoundTripper(t *testing.T) {
 	}
 }
 
+func TestImpersonationRoundTripperContext(t *testing.T) {
+	configured := ImpersonationConfig{UserName: "gateway", Groups: []string{"gateways"}}
+	requested := ImpersonationConfig{UserName: "user", UID: "1234", Groups: []string{"one"}, Extra: map[string][]string{"scope": {"a"}}}
+	tcs := []struct {
+		name        string
+		configured  ImpersonationConfig
+		ctx         context.Context
+		header      http.Header
+		expected    http.Header
+		expectedErr bool
+	}{
+		{
+			name:       "context",
+			configured: ImpersonationConfig{},
+			ctx:        WithImpersonation(context.Background(), requested),
+			expected: http.Header{
+				ImpersonateUserHeader:                      {"user"},
+				ImpersonateUIDHeader:                       {"1234"},
+				ImpersonateGroupHeader:                     {"one"},
+				ImpersonateUserExtraHeaderPrefix + "Scope": {"a"},
+			},
+		},
+		{
+			name:       "no impersonation",
+			configured: ImpersonationConfig{},
+			ctx:        context.Background(),
+			expected:   http.Header{},
+		},
+		{
+			name:       "same as config",
+			configured: configured,
+			ctx:        WithImpersonation(context.Background(), configured),
+			expected: http.Header{
+				ImpersonateUserHeader:  {"gateway"},
+				ImpersonateGroupHeader: {"gateways"},
+			},
+		},
+		{
+			name:        "conflicts with config",
+			configured:  configured,
+			ctx:         WithImpersonation(context.Background(), requested),
+			expectedErr: true,
+		},
+		{
+			name:        "no user",
+			configured:  ImpersonationConfig{},
+			ctx:         WithImpersonation(context.Background(), ImpersonationConfig{Groups: []string{"one"}}),
+			expectedErr: true,
+		},
+		{
+			name:       "headers already set",
+			configured: ImpersonationConfig{},
+			ctx:        WithImpersonation(context.Background(), requested),
+			header:     http.Header{ImpersonateUserHeader: {"user"}},
+			expected:   http.Header{ImpersonateUserHeader: {"user"}},
+		},
+	}
+
+	for _, tc := range tcs {
+		rt := &testRoundTripper{}
+		req, _ := http.NewRequestWithContext(tc.ctx, "GET", "https://server/api", nil)
+		if tc.header != nil {
+			req.Header = tc.header
+		}
+		_, err := NewImpersonatingRoundTripper(tc.configured, rt).RoundTrip(req)
+		if tc.expectedErr {
+			if err == nil || rt.Request != nil {
+				t.Errorf("%v: expected the request to fail, got %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(tc.expected, rt.Request.Header) {
+			t.Errorf("%v: expected headers %v, got %v", tc.name, tc.expected, rt.Request.Header)
+		}
+	}
+}
+
 func TestAuthProxyRoundTripper(t *testing.T) {
 	for n, tc := range map[string]struct {
 		username      string
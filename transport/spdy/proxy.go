@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spdy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// dialProxy connects to addr through the proxy of proxyURL, using dial to
+// connect to the proxy. Like http.Transport, it supports HTTP and HTTPS proxies
+// that accept CONNECT requests, with Basic credentials from the user info of
+// proxyURL, and SOCKS5 proxies. SOCKS5 proxies resolve the host of addr for
+// both the socks5 and the socks5h schemes, as they do for other requests.
+func dialProxy(ctx context.Context, proxyURL *url.URL, addr string, dial utilnet.DialFunc) (net.Conn, error) {
+	switch proxyURL.Scheme {
+	case "http", "https":
+		return dialConnectProxy(ctx, proxyURL, addr, dial)
+	case "socks5", "socks5h":
+		return dialSOCKS5Proxy(ctx, proxyURL, addr, dial)
+	default:
+		return nil, fmt.Errorf("proxy: unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+}
+
+// dialConnectProxy connects to addr through an HTTP CONNECT tunnel.
+func dialConnectProxy(ctx context.Context, proxyURL *url.URL, addr string, dial utilnet.DialFunc) (net.Conn, error) {
+	conn, err := dial(ctx, "tcp", canonicalAddr(proxyURL))
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	connectReq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if auth := proxyAuth(proxyURL); len(auth) > 0 {
+		connectReq.Header.Set("Proxy-Authorization", auth)
+	}
+	if err := connectReq.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// nothing follows the response before the client sends through the
+	// tunnel, so the connection can be used without the buffered reader.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), connectReq)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy: CONNECT to %s through %s failed: %s", addr, proxyURL.Host, resp.Status)
+	}
+	return conn, nil
+}
+
+// proxyAuth returns the value of the Proxy-Authorization header for the
+// credentials of proxyURL, if any.
+func proxyAuth(proxyURL *url.URL) string {
+	if proxyURL.User == nil {
+		return ""
+	}
+	password, _ := proxyURL.User.Password()
+	credentials := proxyURL.User.Username() + ":" + password
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+}
+
+// dialSOCKS5Proxy connects to addr through a SOCKS5 proxy, with the
+// credentials of proxyURL, if any.
+func dialSOCKS5Proxy(ctx context.Context, proxyURL *url.URL, addr string, dial utilnet.DialFunc) (net.Conn, error) {
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+	port := proxyURL.Port()
+	if len(port) == 0 {
+		port = "1080"
+	}
+	dialer, err := proxy.SOCKS5("tcp", net.JoinHostPort(proxyURL.Hostname(), port), auth, contextDialer(dial))
+	if err != nil {
+		return nil, err
+	}
+	return dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+}
+
+// contextDialer adapts a DialFunc to proxy.ContextDialer.
+type contextDialer utilnet.DialFunc
+
+func (d contextDialer) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+func (d contextDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spdy
+
+import (
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	restclient "k8s.io/client-go/rest"
+)
+
+// newUpgradeServer returns a TLS server that upgrades requests to SPDY.
+func newUpgradeServer(t *testing.T) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn := spdy.NewResponseUpgrader().UpgradeResponse(w, req, func(httpstream.Stream, <-chan struct{}) error { return nil })
+		if conn == nil {
+			return
+		}
+		<-conn.CloseChan()
+	}))
+}
+
+// tunnel copies between the connections until either is closed.
+func tunnel(a, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	io.Copy(b, a)
+	b.Close()
+}
+
+// connectProxy is an HTTP proxy that accepts CONNECT requests with the
+// Proxy-Authorization header auth.
+type connectProxy struct {
+	auth string
+
+	lock    sync.Mutex
+	targets []string
+}
+
+func (p *connectProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodConnect {
+		http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Header.Get("Proxy-Authorization") != p.auth {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+	p.lock.Lock()
+	p.targets = append(p.targets, req.Host)
+	p.lock.Unlock()
+
+	backend, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		backend.Close()
+		return
+	}
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		conn.Close()
+		backend.Close()
+		return
+	}
+	tunnel(conn, backend)
+}
+
+// socks5Proxy is a SOCKS5 proxy that requires the username and password
+// user and password, if user is set.
+type socks5Proxy struct {
+	listener       net.Listener
+	user, password string
+
+	lock    sync.Mutex
+	targets []string
+}
+
+func newSOCKS5Proxy(t *testing.T, user, password string) *socks5Proxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &socks5Proxy{listener: l, user: user, password: password}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *socks5Proxy) serve(conn net.Conn) {
+	defer conn.Close()
+	// greeting: version, methods.
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return
+	}
+	if len(p.user) == 0 {
+		conn.Write([]byte{5, 0})
+	} else {
+		conn.Write([]byte{5, 2})
+		// RFC 1929: version, user, password.
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		user := make([]byte, header[1])
+		if _, err := io.ReadFull(conn, user); err != nil {
+			return
+		}
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return
+		}
+		password := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, password); err != nil {
+			return
+		}
+		if string(user) != p.user || string(password) != p.password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	// request: version, command, reserved, address type, address, port.
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		conn.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	p.lock.Lock()
+	p.targets = append(p.targets, target)
+	p.lock.Unlock()
+
+	backend, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	tunnel(conn, backend)
+}
+
+// upgrade upgrades a request to server through the proxy of proxyURL.
+func upgrade(t *testing.T, server *httptest.Server, proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &restclient.Config{
+		Host:  server.URL,
+		Proxy: http.ProxyURL(u),
+		TLSClientConfig: restclient.TLSClientConfig{
+			// the certificate of httptest servers is valid for example.com.
+			ServerName: "example.com",
+			CAData:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}),
+		},
+	}
+	rt, upgrader, err := RoundTripperFor(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", server.URL+"/api/v1/namespaces/default/pods/foo/exec", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, _, err := Negotiate(upgrader, &http.Client{Transport: rt}, req, "v4.channel.k8s.io")
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestRoundTripperForConnectProxy(t *testing.T) {
+	server := newUpgradeServer(t)
+	defer server.Close()
+	p := &connectProxy{auth: "Basic dXNlcjpwQHNz"} // user:p@ss
+	proxyServer := httptest.NewServer(p)
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+
+	proxyURL.User = url.UserPassword("user", "p@ss")
+	if err := upgrade(t, server, proxyURL.String()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p.lock.Lock()
+	if len(p.targets) != 1 || p.targets[0] != server.Listener.Addr().String() {
+		t.Errorf("expected a tunnel to %s, got %v", server.Listener.Addr(), p.targets)
+	}
+	p.lock.Unlock()
+
+	proxyURL.User = url.UserPassword("user", "wrong")
+	err := upgrade(t, server, proxyURL.String())
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Errorf("expected the proxy to require authentication, got %v", err)
+	}
+}
+
+func TestRoundTripperForSOCKS5Proxy(t *testing.T) {
+	server := newUpgradeServer(t)
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	// the proxy resolves the name of the server.
+	server.URL = "https://localhost:" + port
+
+	for _, scheme := range []string{"socks5", "socks5h"} {
+		t.Run(scheme, func(t *testing.T) {
+			p := newSOCKS5Proxy(t, "user", "secret")
+			defer p.listener.Close()
+			proxyURL := &url.URL{Scheme: scheme, User: url.UserPassword("user", "secret"), Host: p.listener.Addr().String()}
+			if err := upgrade(t, server, proxyURL.String()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			p.lock.Lock()
+			if len(p.targets) != 1 || p.targets[0] != "localhost:"+port {
+				t.Errorf("expected a connection to localhost:%s, got %v", port, p.targets)
+			}
+			p.lock.Unlock()
+
+			proxyURL.User = url.UserPassword("user", "wrong")
+			if err := upgrade(t, server, proxyURL.String()); err == nil {
+				t.Errorf("expected the proxy to reject the credentials")
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spdy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// upgradeRoundTripper upgrades requests to SPDY/3.1 like spdy.SpdyRoundTripper,
+// and connects through the HTTP, HTTPS and SOCKS5 proxies of the config. As
+// spdy.SpdyRoundTripper, it is meant to be used for a single upgrade.
+type upgradeRoundTripper struct {
+	tlsConfig *tls.Config
+	proxier   func(*http.Request) (*url.URL, error)
+	dial      utilnet.DialFunc
+
+	followRedirects          bool
+	requireSameHostRedirects bool
+	pingPeriod               time.Duration
+
+	// conn is the connection of the upgraded request.
+	conn net.Conn
+}
+
+var _ utilnet.TLSClientConfigHolder = &upgradeRoundTripper{}
+var _ httpstream.UpgradeRoundTripper = &upgradeRoundTripper{}
+var _ utilnet.Dialer = &upgradeRoundTripper{}
+
+// TLSClientConfig implements pkg/util/net.TLSClientConfigHolder.
+func (rt *upgradeRoundTripper) TLSClientConfig() *tls.Config {
+	return rt.tlsConfig
+}
+
+// Dial implements k8s.io/apimachinery/pkg/util/net.Dialer, it connects to the
+// host of req and sends req.
+func (rt *upgradeRoundTripper) Dial(req *http.Request) (net.Conn, error) {
+	conn, err := rt.dialHost(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialHost connects to the host of req, through a proxy if one is configured
+// for req, and with TLS for https URLs.
+func (rt *upgradeRoundTripper) dialHost(req *http.Request) (net.Conn, error) {
+	proxyURL, err := rt.proxier(req)
+	if err != nil {
+		return nil, err
+	}
+	addr := canonicalAddr(req.URL)
+	var conn net.Conn
+	if proxyURL == nil {
+		conn, err = rt.dial(req.Context(), "tcp", addr)
+	} else {
+		conn, err = dialProxy(req.Context(), proxyURL, addr, rt.dial)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Scheme != "https" {
+		return conn, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConfig := rt.tlsConfig
+	switch {
+	case tlsConfig == nil:
+		tlsConfig = &tls.Config{ServerName: host}
+	case len(tlsConfig.ServerName) == 0:
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(req.Context()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// RoundTrip sends req as an upgrade request, and returns the response of the
+// server. NewConnection returns the upgraded connection.
+func (rt *upgradeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	header := utilnet.CloneHeader(req.Header)
+	header.Add(httpstream.HeaderConnection, httpstream.HeaderUpgrade)
+	header.Add(httpstream.HeaderUpgrade, spdy.HeaderSpdy31)
+
+	var (
+		conn        net.Conn
+		rawResponse []byte
+		err         error
+	)
+	if rt.followRedirects {
+		conn, rawResponse, err = utilnet.ConnectWithRedirects(req.Method, req.URL, header, req.Body, rt, rt.requireSameHostRedirects)
+	} else {
+		clone := utilnet.CloneRequest(req)
+		clone.Header = header
+		conn, err = rt.Dial(clone)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(io.MultiReader(bytes.NewReader(rawResponse), conn)), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	rt.conn = conn
+	return resp, nil
+}
+
+// NewConnection validates the upgrade response, and returns the upgraded
+// connection.
+func (rt *upgradeRoundTripper) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	connectionHeader := strings.ToLower(resp.Header.Get(httpstream.HeaderConnection))
+	upgradeHeader := strings.ToLower(resp.Header.Get(httpstream.HeaderUpgrade))
+	if resp.StatusCode == http.StatusSwitchingProtocols &&
+		strings.Contains(connectionHeader, strings.ToLower(httpstream.HeaderUpgrade)) &&
+		strings.Contains(upgradeHeader, strings.ToLower(spdy.HeaderSpdy31)) {
+		return spdy.NewClientConnectionWithPings(rt.conn, rt.pingPeriod)
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to upgrade connection: unable to read error from server response")
+	}
+	if obj, _, err := statusCodecs.UniversalDecoder().Decode(body, nil, &metav1.Status{}); err == nil {
+		if status, ok := obj.(*metav1.Status); ok {
+			return nil, &apierrors.StatusError{ErrStatus: *status}
+		}
+	}
+	return nil, fmt.Errorf("unable to upgrade connection: %s", strings.TrimSpace(string(body)))
+}
+
+// canonicalAddr returns the host:port of u, with the default port of the
+// scheme if u has none.
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if len(port) == 0 {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+var statusScheme = runtime.NewScheme()
+
+// statusCodecs decodes the Status of failed upgrades.
+var statusCodecs = serializer.NewCodecFactory(statusScheme)
+
+func init() {
+	statusScheme.AddUnversionedTypes(metav1.SchemeGroupVersion, &metav1.Status{})
+}
@@ -18,13 +18,14 @@ package spdy
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"time"
 
 	"k8s.io/apimachinery/pkg/util/httpstream"
-	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
 	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
 )
 
 // Upgrader validates a response from the server after a SPDY upgrade.
@@ -33,7 +34,10 @@ type Upgrader interface {
 	NewConnection(resp *http.Response) (httpstream.Connection, error)
 }
 
-// RoundTripperFor returns a round tripper and upgrader to use with SPDY.
+// RoundTripperFor returns a round tripper and upgrader to use with SPDY. The
+// connection goes through the proxy of the config, or of the environment if the
+// config has none, like other requests. HTTP and HTTPS proxies, with Basic
+// credentials from the proxy URL, and SOCKS5 proxies are supported.
 func RoundTripperFor(config *restclient.Config) (http.RoundTripper, Upgrader, error) {
 	tlsConfig, err := restclient.TLSConfigFor(config)
 	if err != nil {
@@ -43,13 +47,21 @@ func RoundTripperFor(config *restclient.Config) (http.RoundTripper, Upgrader, er
 	if config.Proxy != nil {
 		proxy = config.Proxy
 	}
-	upgradeRoundTripper := spdy.NewRoundTripperWithConfig(spdy.RoundTripperConfig{
-		TLS:                      tlsConfig,
-		FollowRedirects:          true,
-		RequireSameHostRedirects: false,
-		Proxier:                  proxy,
-		PingPeriod:               time.Second * 5,
-	})
+	dial := config.Dial
+	if dial == nil {
+		dial = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	upgradeRoundTripper := &upgradeRoundTripper{
+		tlsConfig:                tlsConfig,
+		proxier:                  proxy,
+		dial:                     dial,
+		followRedirects:          true,
+		requireSameHostRedirects: false,
+		pingPeriod:               time.Second * 5,
+	}
 	wrapper, err := restclient.HTTPWrappersForConfig(config, upgradeRoundTripper)
 	if err != nil {
 		return nil, nil, err
@@ -101,5 +113,11 @@ func Negotiate(upgrader Upgrader, client *http.Client, req *http.Request, protoc
 	if err != nil {
 		return nil, "", err
 	}
+	// the upgraded connection stays in flight until it is closed.
+	release := transport.HoldInFlight(resp)
+	go func() {
+		<-conn.CloseChan()
+		release()
+	}()
 	return conn, resp.Header.Get(httpstream.HeaderProtocolVersion), nil
 }
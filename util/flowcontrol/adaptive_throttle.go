@@ -0,0 +1,203 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flowcontrol
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	flowcontrolv1beta1 "k8s.io/api/flowcontrol/v1beta1"
+	"k8s.io/klog/v2"
+)
+
+// AdaptiveRateLimiter is a RateLimiter that adjusts its rate to the
+// responses the server sends to the requests it admitted.
+type AdaptiveRateLimiter interface {
+	RateLimiter
+	// Observe is called with the outcome of every request admitted by the
+	// limiter. resp is nil if the request failed with err.
+	Observe(resp *http.Response, err error)
+}
+
+const (
+	// adaptiveDecreaseFactor is what the rate is multiplied with when the
+	// server is overloaded.
+	adaptiveDecreaseFactor = 0.5
+	// adaptiveIncreaseFraction is the fraction of the maximum rate that the
+	// rate is raised by after every successful request.
+	adaptiveIncreaseFraction = 0.01
+)
+
+type adaptiveRateLimiter struct {
+	clock  Clock
+	minQPS float32
+	maxQPS float32
+
+	lock    sync.Mutex
+	qps     float32
+	limiter *rate.Limiter
+	// notBefore is the time before which no request is admitted, as asked
+	// by the server with a 'Retry-After' response header.
+	notBefore time.Time
+}
+
+// NewAdaptiveRateLimiter creates a token bucket rate limiter that starts at
+// maxQPS and adapts its rate to the load of the server, in an additive
+// increase, multiplicative decrease fashion: every 429 response, or response
+// with a 'Retry-After' header, halves the rate down to minQPS, and every
+// other response below 500 raises it again by 1% of maxQPS. While the
+// 'Retry-After' delay asked for by the server has not passed, no request is
+// admitted.
+// The API Priority and Fairness flow schema and priority level reported by
+// the server are logged when the rate is lowered.
+func NewAdaptiveRateLimiter(minQPS, maxQPS float32, burst int) AdaptiveRateLimiter {
+	return NewAdaptiveRateLimiterWithClock(minQPS, maxQPS, burst, realClock{})
+}
+
+// NewAdaptiveRateLimiterWithClock is identical to NewAdaptiveRateLimiter
+// but allows an injectable clock, for testing.
+func NewAdaptiveRateLimiterWithClock(minQPS, maxQPS float32, burst int, c Clock) AdaptiveRateLimiter {
+	if minQPS > maxQPS {
+		minQPS = maxQPS
+	}
+	return &adaptiveRateLimiter{
+		clock:   c,
+		minQPS:  minQPS,
+		maxQPS:  maxQPS,
+		qps:     maxQPS,
+		limiter: rate.NewLimiter(rate.Limit(maxQPS), burst),
+	}
+}
+
+func (a *adaptiveRateLimiter) TryAccept() bool {
+	now := a.clock.Now()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if now.Before(a.notBefore) {
+		return false
+	}
+	return a.limiter.AllowN(now, 1)
+}
+
+// Accept will block until a token becomes available
+func (a *adaptiveRateLimiter) Accept() {
+	now := a.clock.Now()
+	a.lock.Lock()
+	at := now
+	if at.Before(a.notBefore) {
+		// reserve the token for when the server wants to hear from us again.
+		at = a.notBefore
+	}
+	delay := at.Sub(now) + a.limiter.ReserveN(at, 1).DelayFrom(at)
+	a.lock.Unlock()
+	a.clock.Sleep(delay)
+}
+
+func (a *adaptiveRateLimiter) Stop() {
+}
+
+func (a *adaptiveRateLimiter) QPS() float32 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.qps
+}
+
+func (a *adaptiveRateLimiter) Wait(ctx context.Context) error {
+	a.lock.Lock()
+	wait := a.notBefore.Sub(a.clock.Now())
+	a.lock.Unlock()
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return a.limiter.Wait(ctx)
+}
+
+func (a *adaptiveRateLimiter) Observe(resp *http.Response, err error) {
+	if err != nil || resp == nil {
+		// the server did not tell us anything about its load.
+		return
+	}
+
+	retryAfter, hasRetryAfter := retryAfterSeconds(resp)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || hasRetryAfter:
+		a.decrease(resp, retryAfter)
+	case resp.StatusCode < http.StatusInternalServerError:
+		a.increase()
+	}
+}
+
+func (a *adaptiveRateLimiter) decrease(resp *http.Response, retryAfter time.Duration) {
+	now := a.clock.Now()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	old := a.qps
+	a.qps *= adaptiveDecreaseFactor
+	if a.qps < a.minQPS {
+		a.qps = a.minQPS
+	}
+	a.limiter.SetLimitAt(now, rate.Limit(a.qps))
+	if notBefore := now.Add(retryAfter); notBefore.After(a.notBefore) {
+		a.notBefore = notBefore
+	}
+
+	klog.V(2).Infof("Server responded with %d and Retry-After %v, lowering client-side rate limit from %v to %v QPS (flow schema %q, priority level %q)",
+		resp.StatusCode, retryAfter, old, a.qps,
+		resp.Header.Get(flowcontrolv1beta1.ResponseHeaderMatchedFlowSchemaUID),
+		resp.Header.Get(flowcontrolv1beta1.ResponseHeaderMatchedPriorityLevelConfigurationUID))
+}
+
+func (a *adaptiveRateLimiter) increase() {
+	now := a.clock.Now()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.qps >= a.maxQPS {
+		return
+	}
+	a.qps += a.maxQPS * adaptiveIncreaseFraction
+	if a.qps > a.maxQPS {
+		a.qps = a.maxQPS
+	}
+	a.limiter.SetLimitAt(now, rate.Limit(a.qps))
+}
+
+// retryAfterSeconds returns the value of the 'Retry-After' response header
+// if it is set to a number of seconds.
+func retryAfterSeconds(resp *http.Response) (time.Duration, bool) {
+	h := resp.Header.Get("Retry-After")
+	if len(h) == 0 {
+		return 0, false
+	}
+	i, err := strconv.Atoi(h)
+	if err != nil || i < 0 {
+		return 0, false
+	}
+	return time.Duration(i) * time.Second, true
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flowcontrol
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func responseWith(code int, retryAfter string) *http.Response {
+	resp := &http.Response{StatusCode: code, Header: http.Header{}}
+	if len(retryAfter) > 0 {
+		resp.Header.Set("Retry-After", retryAfter)
+	}
+	return resp
+}
+
+func TestAdaptiveRateLimiterAIMD(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	r := NewAdaptiveRateLimiterWithClock(10, 100, 1, fakeClock)
+
+	steps := []struct {
+		resp     *http.Response
+		err      error
+		expected float32
+	}{
+		{resp: responseWith(http.StatusOK, ""), expected: 100},
+		{resp: responseWith(http.StatusTooManyRequests, ""), expected: 50},
+		{resp: responseWith(http.StatusServiceUnavailable, "0"), expected: 25},
+		{resp: responseWith(http.StatusInternalServerError, ""), expected: 25},
+		{err: errors.New("connection refused"), expected: 25},
+		{resp: responseWith(http.StatusNotFound, ""), expected: 26},
+		{resp: responseWith(http.StatusOK, ""), expected: 27},
+		{resp: responseWith(http.StatusTooManyRequests, ""), expected: 13.5},
+		{resp: responseWith(http.StatusTooManyRequests, ""), expected: 10},
+	}
+	for i, step := range steps {
+		r.Observe(step.resp, step.err)
+		if qps := r.QPS(); qps != step.expected {
+			t.Errorf("%d: expected QPS %v, got %v", i, step.expected, qps)
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		r.Observe(responseWith(http.StatusOK, ""), nil)
+	}
+	if qps := r.QPS(); qps != 100 {
+		t.Errorf("expected QPS to recover to 100, got %v", qps)
+	}
+}
+
+func TestAdaptiveRateLimiterRetryAfter(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	r := NewAdaptiveRateLimiterWithClock(1, 1000, 10, fakeClock)
+	if !r.TryAccept() {
+		t.Fatalf("expected a token")
+	}
+
+	r.Observe(responseWith(http.StatusTooManyRequests, "2"), nil)
+	if r.TryAccept() {
+		t.Errorf("expected no token before Retry-After")
+	}
+	fakeClock.Step(time.Second)
+	if r.TryAccept() {
+		t.Errorf("expected no token before Retry-After")
+	}
+	fakeClock.Step(time.Second)
+	if !r.TryAccept() {
+		t.Errorf("expected a token after Retry-After")
+	}
+
+	r.Observe(responseWith(http.StatusTooManyRequests, "3"), nil)
+	start := fakeClock.Now()
+	r.Accept()
+	if waited := fakeClock.Now().Sub(start); waited < 3*time.Second {
+		t.Errorf("expected Accept to wait for Retry-After, waited %v", waited)
+	}
+}
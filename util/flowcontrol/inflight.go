@@ -0,0 +1,155 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flowcontrol
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/metrics"
+)
+
+// Kinds of requests, as reported by metrics.InFlightQueueDepth and
+// metrics.InFlightQueueWait.
+const (
+	shortRequests       = "short"
+	longRunningRequests = "long-running"
+)
+
+// InFlightLimiter limits the number of requests that are in flight at the
+// same time. Long-running requests, like watches, exec and port forwarding,
+// are limited separately from short requests, since they hold on to their
+// slot for much longer.
+type InFlightLimiter interface {
+	// Acquire waits until a slot for a request is available, or the context
+	// is done. On success the returned function must be called when the
+	// request is finished, calling it again has no effect.
+	Acquire(ctx context.Context, longRunning bool) (release func(), err error)
+	// InFlight returns the number of short and long-running requests in flight.
+	InFlight() (short, longRunning int)
+}
+
+// NewMaxInFlightLimiter returns an InFlightLimiter that allows up to maxShort
+// short requests and up to maxLongRunning long-running requests in flight.
+// Requests over the limit wait in a queue. A limit of zero means no limit for
+// that kind of request.
+//
+// The limiter can be shared by several clients, to limit the requests of a
+// process as a whole.
+func NewMaxInFlightLimiter(maxShort, maxLongRunning int) InFlightLimiter {
+	return &maxInFlightLimiter{
+		short:       newInFlightQueue(shortRequests, maxShort),
+		longRunning: newInFlightQueue(longRunningRequests, maxLongRunning),
+	}
+}
+
+type maxInFlightLimiter struct {
+	short       *inFlightQueue
+	longRunning *inFlightQueue
+}
+
+func (l *maxInFlightLimiter) Acquire(ctx context.Context, longRunning bool) (func(), error) {
+	if longRunning {
+		return l.longRunning.acquire(ctx)
+	}
+	return l.short.acquire(ctx)
+}
+
+func (l *maxInFlightLimiter) InFlight() (int, int) {
+	return l.short.inFlight(), l.longRunning.inFlight()
+}
+
+// inFlightQueue limits one kind of requests.
+type inFlightQueue struct {
+	kind string
+	// slots holds a value for every request in flight, it is nil if there
+	// is no limit.
+	slots chan struct{}
+
+	lock      sync.Mutex
+	waiting   int
+	unlimited int
+}
+
+func newInFlightQueue(kind string, max int) *inFlightQueue {
+	q := &inFlightQueue{kind: kind}
+	if max > 0 {
+		q.slots = make(chan struct{}, max)
+	}
+	return q
+}
+
+func (q *inFlightQueue) acquire(ctx context.Context) (func(), error) {
+	if q.slots == nil {
+		q.lock.Lock()
+		q.unlimited++
+		q.lock.Unlock()
+		return q.releaseFunc(), nil
+	}
+
+	select {
+	case q.slots <- struct{}{}:
+		return q.releaseFunc(), nil
+	default:
+	}
+
+	start := time.Now()
+	q.setWaiting(1)
+	defer func() {
+		q.setWaiting(-1)
+		metrics.InFlightQueueWait.Observe(q.kind, time.Since(start))
+	}()
+	select {
+	case q.slots <- struct{}{}:
+		return q.releaseFunc(), nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("client in-flight limit of %d %s requests: %w", cap(q.slots), q.kind, ctx.Err())
+	}
+}
+
+func (q *inFlightQueue) setWaiting(delta int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.waiting += delta
+	metrics.InFlightQueueDepth.Set(q.kind, q.waiting)
+}
+
+func (q *inFlightQueue) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if q.slots == nil {
+				q.lock.Lock()
+				q.unlimited--
+				q.lock.Unlock()
+				return
+			}
+			<-q.slots
+		})
+	}
+}
+
+func (q *inFlightQueue) inFlight() int {
+	if q.slots == nil {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		return q.unlimited
+	}
+	return len(q.slots)
+}
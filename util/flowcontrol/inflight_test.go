@@ -0,0 +1,101 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flowcontrol
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaxInFlightLimiter(t *testing.T) {
+	l := NewMaxInFlightLimiter(2, 1)
+
+	release1, err := l.Acquire(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release2, err := l.Acquire(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseLong, err := l.Acquire(context.Background(), true)
+	if err != nil {
+		t.Fatalf("long-running requests must be limited separately: %v", err)
+	}
+	if short, long := l.InFlight(); short != 2 || long != 1 {
+		t.Errorf("expected 2 short and 1 long-running request in flight, got %d and %d", short, long)
+	}
+
+	// requests over the limit wait until their deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		release, err := l.Acquire(context.Background(), false)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- release
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected the request to wait for a slot")
+	case <-time.After(10 * time.Millisecond):
+	}
+	release1()
+	// releasing a slot twice has no effect.
+	release1()
+	release3 := <-acquired
+
+	if short, long := l.InFlight(); short != 2 || long != 1 {
+		t.Errorf("expected 2 short and 1 long-running request in flight, got %d and %d", short, long)
+	}
+	release2()
+	release3()
+	releaseLong()
+	if short, long := l.InFlight(); short != 0 || long != 0 {
+		t.Errorf("expected no requests in flight, got %d and %d", short, long)
+	}
+}
+
+func TestMaxInFlightLimiterUnlimited(t *testing.T) {
+	l := NewMaxInFlightLimiter(1, 0)
+	var releases []func()
+	for i := 0; i < 10; i++ {
+		release, err := l.Acquire(context.Background(), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+	if _, long := l.InFlight(); long != 10 {
+		t.Errorf("expected 10 long-running requests in flight, got %d", long)
+	}
+	for _, release := range releases {
+		release()
+		release()
+	}
+	if _, long := l.InFlight(); long != 0 {
+		t.Errorf("expected no long-running requests in flight, got %d", long)
+	}
+}
@@ -22,6 +22,10 @@ import (
 	"time"
 
 	"golang.org/x/time/rate"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 type RateLimiter interface {
@@ -34,6 +38,15 @@ type RateLimiter interface {
 	NumRequeues(item interface{}) int
 }
 
+// ErrorRateLimiter is a RateLimiter that can take the error that caused an item to be requeued
+// into account when deciding how long the item should wait.
+type ErrorRateLimiter interface {
+	RateLimiter
+	// WhenWithError gets an item and the error from its last processing attempt and gets to decide
+	// how long that item should wait
+	WhenWithError(item interface{}, err error) time.Duration
+}
+
 // DefaultControllerRateLimiter is a no-arg constructor for a default rate limiter for a workqueue.  It has
 // both overall and per-item rate limiting.  The overall is a token bucket and the per-item is exponential
 func DefaultControllerRateLimiter() RateLimiter {
@@ -62,6 +75,99 @@ func (r *BucketRateLimiter) NumRequeues(item interface{}) int {
 func (r *BucketRateLimiter) Forget(item interface{}) {
 }
 
+// ItemBucketRateLimiter gives every item its own token bucket, so that a single item can't be
+// processed more often than qps on average, with bursts of up to burst, no matter how often it is
+// added. Unlike ItemExponentialFailureRateLimiter it limits successful attempts as well as failures,
+// so Forget does not reset an item's bucket; to limit every attempt, add items with AddRateLimited
+// instead of Add. Buckets that have refilled completely are indistinguishable from new ones and
+// are garbage collected.
+type ItemBucketRateLimiter struct {
+	limit rate.Limit
+	burst int
+	clock clock.Clock
+
+	bucketsLock sync.Mutex
+	buckets     map[interface{}]*itemBucket
+	nextGC      time.Time
+}
+
+// itemBucket is the token bucket of a single item.
+type itemBucket struct {
+	limiter *rate.Limiter
+	// fullAt is the time by which the bucket will have refilled completely.
+	fullAt time.Time
+}
+
+var _ RateLimiter = &ItemBucketRateLimiter{}
+
+// NewItemBucketRateLimiter constructs an ItemBucketRateLimiter that allows qps attempts per second
+// with bursts of up to burst for every item. qps must be greater than zero, and a burst of less than
+// one is raised to one, since an empty bucket never lets an item through.
+func NewItemBucketRateLimiter(qps rate.Limit, burst int) RateLimiter {
+	return newItemBucketRateLimiter(clock.RealClock{}, qps, burst)
+}
+
+func newItemBucketRateLimiter(clock clock.Clock, qps rate.Limit, burst int) *ItemBucketRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &ItemBucketRateLimiter{
+		limit:   qps,
+		burst:   burst,
+		clock:   clock,
+		buckets: map[interface{}]*itemBucket{},
+	}
+}
+
+func (r *ItemBucketRateLimiter) When(item interface{}) time.Duration {
+	r.bucketsLock.Lock()
+	defer r.bucketsLock.Unlock()
+
+	now := r.clock.Now()
+	r.gcLocked(now)
+
+	bucket, ok := r.buckets[item]
+	if !ok {
+		bucket = &itemBucket{limiter: rate.NewLimiter(r.limit, r.burst)}
+		r.buckets[item] = bucket
+	}
+	delay := bucket.limiter.ReserveN(now, 1).DelayFrom(now)
+	bucket.fullAt = now.Add(delay + r.refillDuration())
+
+	return delay
+}
+
+// refillDuration returns how long it takes an empty bucket to refill completely.
+func (r *ItemBucketRateLimiter) refillDuration() time.Duration {
+	if r.limit == rate.Inf || r.limit <= 0 {
+		return 0
+	}
+	return time.Duration(float64(r.burst) / float64(r.limit) * float64(time.Second))
+}
+
+// gcLocked drops the buckets that have refilled completely. It sweeps at most once per refill
+// duration, which bounds the number of idle buckets to the number of items seen in that window.
+func (r *ItemBucketRateLimiter) gcLocked(now time.Time) {
+	if now.Before(r.nextGC) {
+		return
+	}
+	for item, bucket := range r.buckets {
+		if !now.Before(bucket.fullAt) {
+			delete(r.buckets, item)
+		}
+	}
+	r.nextGC = now.Add(r.refillDuration())
+}
+
+// NumRequeues always returns 0, ItemBucketRateLimiter doesn't count failures.
+func (r *ItemBucketRateLimiter) NumRequeues(item interface{}) int {
+	return 0
+}
+
+// Forget is a no-op, an item's bucket is only dropped once it has refilled.
+func (r *ItemBucketRateLimiter) Forget(item interface{}) {
+}
+
 // ItemExponentialFailureRateLimiter does a simple baseDelay*2^<num-failures> limit
 // dealing with max failures and expiration are up to the caller
 type ItemExponentialFailureRateLimiter struct {
@@ -87,12 +193,22 @@ func DefaultItemBasedRateLimiter() RateLimiter {
 }
 
 func (r *ItemExponentialFailureRateLimiter) When(item interface{}) time.Duration {
+	return r.backoff(r.fail(item))
+}
+
+// fail records a failure of item, and returns the number of its previous
+// failures.
+func (r *ItemExponentialFailureRateLimiter) fail(item interface{}) int {
 	r.failuresLock.Lock()
 	defer r.failuresLock.Unlock()
 
 	exp := r.failures[item]
 	r.failures[item] = r.failures[item] + 1
+	return exp
+}
 
+// backoff returns baseDelay*2^exp capped at maxDelay.
+func (r *ItemExponentialFailureRateLimiter) backoff(exp int) time.Duration {
 	// The backoff is capped such that 'calculated' value never overflows.
 	backoff := float64(r.baseDelay.Nanoseconds()) * math.Pow(2, float64(exp))
 	if backoff > math.MaxInt64 {
@@ -188,6 +304,25 @@ func (r *MaxOfRateLimiter) When(item interface{}) time.Duration {
 	return ret
 }
 
+// WhenWithError returns the worst case response of every RateLimiter, passing err to those that
+// implement ErrorRateLimiter.
+func (r *MaxOfRateLimiter) WhenWithError(item interface{}, err error) time.Duration {
+	ret := time.Duration(0)
+	for _, limiter := range r.limiters {
+		var curr time.Duration
+		if errLimiter, ok := limiter.(ErrorRateLimiter); ok {
+			curr = errLimiter.WhenWithError(item, err)
+		} else {
+			curr = limiter.When(item)
+		}
+		if curr > ret {
+			ret = curr
+		}
+	}
+
+	return ret
+}
+
 func NewMaxOfRateLimiter(limiters ...RateLimiter) RateLimiter {
 	return &MaxOfRateLimiter{limiters: limiters}
 }
@@ -209,3 +344,122 @@ func (r *MaxOfRateLimiter) Forget(item interface{}) {
 		limiter.Forget(item)
 	}
 }
+
+// ErrorClass tells an ErrorClassifyingRateLimiter how to back off an item after a failure.
+type ErrorClass int
+
+const (
+	// ErrorClassDefault failures are retried with per-item exponential backoff.
+	ErrorClassDefault ErrorClass = iota
+	// ErrorClassConflict failures are expected to succeed as soon as the item is reprocessed
+	// against a fresh copy of the object, so they are retried quickly.
+	ErrorClassConflict
+	// ErrorClassThrottled failures mean the server is asking clients to slow down, so they are
+	// retried with a much steeper backoff.
+	ErrorClassThrottled
+)
+
+// ErrorClassifierFunc decides the ErrorClass of an error returned while processing an item.
+type ErrorClassifierFunc func(err error) ErrorClass
+
+// DefaultErrorClassifier classifies API conflicts as ErrorClassConflict and throttling, server
+// timeouts and errors that carry a Retry-After as ErrorClassThrottled.
+func DefaultErrorClassifier(err error) ErrorClass {
+	switch {
+	case err == nil:
+		return ErrorClassDefault
+	case apierrors.IsConflict(err):
+		return ErrorClassConflict
+	case apierrors.IsTooManyRequests(err), apierrors.IsServerTimeout(err), apierrors.IsTimeout(err):
+		return ErrorClassThrottled
+	}
+	if _, ok := apierrors.SuggestsClientDelay(err); ok {
+		return ErrorClassThrottled
+	}
+	return ErrorClassDefault
+}
+
+// ErrorClassifyingRateLimiter picks a per-item delay based on the class of the error that caused
+// the item to be requeued. ErrorClassConflict failures wait conflictDelay, ErrorClassThrottled
+// failures wait throttledBaseDelay*2^<num-failures> and anything else waits baseDelay*2^<num-failures>.
+// Every delay is jittered by up to jitterFactor of itself, and exponential delays are then capped at
+// maxDelay. If the server suggested a delay through Retry-After the item never waits less than that.
+type ErrorClassifyingRateLimiter struct {
+	// failures tracks the failures of items, and backs off ErrorClassDefault failures.
+	failures *ItemExponentialFailureRateLimiter
+	// throttled backs off ErrorClassThrottled failures.
+	throttled *ItemExponentialFailureRateLimiter
+
+	classifier    ErrorClassifierFunc
+	conflictDelay time.Duration
+	jitterFactor  float64
+}
+
+var _ ErrorRateLimiter = &ErrorClassifyingRateLimiter{}
+
+// NewErrorClassifyingRateLimiter constructs an ErrorClassifyingRateLimiter. If classifier is nil
+// DefaultErrorClassifier is used.
+func NewErrorClassifyingRateLimiter(classifier ErrorClassifierFunc, conflictDelay, baseDelay, throttledBaseDelay, maxDelay time.Duration, jitterFactor float64) ErrorRateLimiter {
+	if classifier == nil {
+		classifier = DefaultErrorClassifier
+	}
+	return &ErrorClassifyingRateLimiter{
+		failures:      NewItemExponentialFailureRateLimiter(baseDelay, maxDelay).(*ItemExponentialFailureRateLimiter),
+		throttled:     NewItemExponentialFailureRateLimiter(throttledBaseDelay, maxDelay).(*ItemExponentialFailureRateLimiter),
+		classifier:    classifier,
+		conflictDelay: conflictDelay,
+		jitterFactor:  jitterFactor,
+	}
+}
+
+// DefaultErrorClassifyingRateLimiter is a no-arg constructor for an ErrorClassifyingRateLimiter
+// that uses DefaultErrorClassifier.
+func DefaultErrorClassifyingRateLimiter() ErrorRateLimiter {
+	return NewErrorClassifyingRateLimiter(DefaultErrorClassifier, 5*time.Millisecond, 5*time.Millisecond, 1*time.Second, 1000*time.Second, 0.1)
+}
+
+// When treats the failure as ErrorClassDefault.
+func (r *ErrorClassifyingRateLimiter) When(item interface{}) time.Duration {
+	return r.WhenWithError(item, nil)
+}
+
+func (r *ErrorClassifyingRateLimiter) WhenWithError(item interface{}, err error) time.Duration {
+	exp := r.failures.fail(item)
+
+	class := ErrorClassDefault
+	if err != nil {
+		class = r.classifier(err)
+	}
+
+	var delay time.Duration
+	switch class {
+	case ErrorClassConflict:
+		delay = r.conflictDelay
+	case ErrorClassThrottled:
+		delay = r.throttled.backoff(exp)
+	default:
+		delay = r.failures.backoff(exp)
+	}
+	if r.jitterFactor > 0 {
+		delay = wait.Jitter(delay, r.jitterFactor)
+	}
+	// the jitter must not take exponential delays past their maximum.
+	if class != ErrorClassConflict && delay > r.failures.maxDelay {
+		delay = r.failures.maxDelay
+	}
+
+	if seconds, ok := apierrors.SuggestsClientDelay(err); ok {
+		if retryAfter := time.Duration(seconds) * time.Second; retryAfter > delay {
+			return retryAfter
+		}
+	}
+	return delay
+}
+
+func (r *ErrorClassifyingRateLimiter) NumRequeues(item interface{}) int {
+	return r.failures.NumRequeues(item)
+}
+
+func (r *ErrorClassifyingRateLimiter) Forget(item interface{}) {
+	r.failures.Forget(item)
+}
@@ -17,8 +17,15 @@ limitations under the License.
 package workqueue
 
 import (
+	"errors"
 	"testing"
 	"time"
+
+	"golang.org/x/time/rate"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
 )
 
 func TestItemExponentialFailureRateLimiter(t *testing.T) {
@@ -182,3 +189,226 @@ func TestMaxOfRateLimiter(t *testing.T) {
 	}
 
 }
+
+func TestDefaultErrorClassifier(t *testing.T) {
+	gr := schema.GroupResource{Resource: "pods"}
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{name: "nil", err: nil, want: ErrorClassDefault},
+		{name: "generic", err: errors.New("boom"), want: ErrorClassDefault},
+		{name: "not found", err: apierrors.NewNotFound(gr, "foo"), want: ErrorClassDefault},
+		{name: "conflict", err: apierrors.NewConflict(gr, "foo", errors.New("stale")), want: ErrorClassConflict},
+		{name: "too many requests", err: apierrors.NewTooManyRequests("slow down", 3), want: ErrorClassThrottled},
+		{name: "server timeout", err: apierrors.NewServerTimeout(gr, "create", 2), want: ErrorClassThrottled},
+		{name: "timeout", err: apierrors.NewTimeoutError("webhook timed out", 0), want: ErrorClassThrottled},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if e, a := test.want, DefaultErrorClassifier(test.err); e != a {
+				t.Errorf("expected %v, got %v", e, a)
+			}
+		})
+	}
+}
+
+func TestErrorClassifyingRateLimiter(t *testing.T) {
+	gr := schema.GroupResource{Resource: "pods"}
+	conflict := apierrors.NewConflict(gr, "foo", errors.New("stale"))
+	throttled := apierrors.NewTooManyRequests("slow down", 0)
+	retryAfter := apierrors.NewTooManyRequests("slow down", 30)
+
+	limiter := NewErrorClassifyingRateLimiter(nil, 1*time.Millisecond, 2*time.Millisecond, 1*time.Second, 10*time.Second, 0)
+
+	if e, a := 1*time.Millisecond, limiter.WhenWithError("one", conflict); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 1*time.Millisecond, limiter.WhenWithError("one", conflict); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 8*time.Millisecond, limiter.When("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 3, limiter.NumRequeues("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+
+	if e, a := 1*time.Second, limiter.WhenWithError("two", throttled); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 2*time.Second, limiter.WhenWithError("two", throttled); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	for i := 0; i < 10; i++ {
+		limiter.WhenWithError("two", throttled)
+	}
+	if e, a := 10*time.Second, limiter.WhenWithError("two", throttled); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+
+	// Retry-After is honored even above maxDelay.
+	if e, a := 30*time.Second, limiter.WhenWithError("three", retryAfter); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+
+	limiter.Forget("one")
+	if e, a := 0, limiter.NumRequeues("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 2*time.Millisecond, limiter.WhenWithError("one", errors.New("boom")); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+}
+
+func TestErrorClassifyingRateLimiterJitter(t *testing.T) {
+	limiter := NewErrorClassifyingRateLimiter(nil, 0, 100*time.Millisecond, 1*time.Second, 10*time.Second, 0.5)
+	for i := 0; i < 100; i++ {
+		limiter.Forget("one")
+		if a := limiter.When("one"); a < 100*time.Millisecond || a > 150*time.Millisecond {
+			t.Errorf("expected a delay in [100ms, 150ms], got %v", a)
+		}
+	}
+
+	// the jitter does not take delays past maxDelay.
+	limiter = NewErrorClassifyingRateLimiter(nil, 0, 10*time.Second, 1*time.Second, 10*time.Second, 0.5)
+	for i := 0; i < 100; i++ {
+		if a := limiter.When("two"); a > 10*time.Second {
+			t.Errorf("expected a delay of at most 10s, got %v", a)
+		}
+	}
+}
+
+func TestErrorClassifyingRateLimiterCustomClassifier(t *testing.T) {
+	errFast := errors.New("fast")
+	classifier := func(err error) ErrorClass {
+		if err == errFast {
+			return ErrorClassConflict
+		}
+		return ErrorClassDefault
+	}
+	limiter := NewErrorClassifyingRateLimiter(classifier, 1*time.Millisecond, 1*time.Second, 1*time.Second, 10*time.Second, 0)
+
+	if e, a := 1*time.Millisecond, limiter.WhenWithError("one", errFast); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 2*time.Second, limiter.WhenWithError("one", errors.New("slow")); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+}
+
+func TestMaxOfRateLimiterWithError(t *testing.T) {
+	limiter := NewMaxOfRateLimiter(
+		NewItemFastSlowRateLimiter(5*time.Millisecond, 3*time.Second, 3),
+		NewErrorClassifyingRateLimiter(nil, 1*time.Millisecond, 1*time.Millisecond, 1*time.Minute, 10*time.Minute, 0),
+	).(ErrorRateLimiter)
+
+	if e, a := 5*time.Millisecond, limiter.WhenWithError("one", errors.New("boom")); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 2*time.Minute, limiter.WhenWithError("one", apierrors.NewTooManyRequests("slow down", 0)); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+}
+
+func TestItemBucketRateLimiter(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	// 1 qps, bursts of 2.
+	limiter := newItemBucketRateLimiter(fakeClock, rate.Limit(1), 2)
+
+	if e, a := time.Duration(0), limiter.When("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := time.Duration(0), limiter.When("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 1*time.Second, limiter.When("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 2*time.Second, limiter.When("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+
+	// Other items have their own bucket.
+	if e, a := time.Duration(0), limiter.When("two"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+
+	// Forget doesn't reset the bucket.
+	limiter.Forget("one")
+	if e, a := 3*time.Second, limiter.When("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 0, limiter.NumRequeues("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+
+	fakeClock.Step(1 * time.Second)
+	if e, a := 3*time.Second, limiter.When("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+}
+
+func TestItemBucketRateLimiterZeroBurst(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	limiter := newItemBucketRateLimiter(fakeClock, rate.Limit(1), 0)
+
+	if e, a := time.Duration(0), limiter.When("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 1*time.Second, limiter.When("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+}
+
+func TestItemBucketRateLimiterGC(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	limiter := newItemBucketRateLimiter(fakeClock, rate.Limit(1), 2)
+
+	limiter.When("one")
+	limiter.When("two")
+	limiter.When("two")
+	limiter.When("two")
+	if e, a := 2, len(limiter.buckets); e != a {
+		t.Errorf("expected %v buckets, got %v", e, a)
+	}
+
+	// "one" has refilled after 2s, "two" needs 3s.
+	fakeClock.Step(2 * time.Second)
+	limiter.When("three")
+	if _, ok := limiter.buckets["one"]; ok {
+		t.Errorf("expected bucket of %q to be garbage collected", "one")
+	}
+	if _, ok := limiter.buckets["two"]; !ok {
+		t.Errorf("expected bucket of %q to be kept", "two")
+	}
+
+	fakeClock.Step(2 * time.Second)
+	limiter.When("three")
+	if e, a := 1, len(limiter.buckets); e != a {
+		t.Errorf("expected %v buckets, got %v", e, a)
+	}
+
+	// A garbage collected bucket starts full again.
+	if e, a := time.Duration(0), limiter.When("two"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+}
+
+func TestItemBucketRateLimiterMaxOf(t *testing.T) {
+	limiter := NewMaxOfRateLimiter(
+		NewItemExponentialFailureRateLimiter(1*time.Millisecond, 1*time.Second),
+		NewItemBucketRateLimiter(rate.Every(time.Minute), 1),
+	)
+
+	if e, a := 1*time.Millisecond, limiter.When("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if a := limiter.When("one"); a < 59*time.Second || a > time.Minute {
+		t.Errorf("expected about a minute, got %v", a)
+	}
+	if e, a := 2, limiter.NumRequeues("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+}
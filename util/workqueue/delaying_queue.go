@@ -91,6 +91,32 @@ type delayingType struct {
 	metrics retryMetrics
 }
 
+var _ BatchInterface = &delayingType{}
+var _ MetadataInterface = &delayingType{}
+
+// GetBatch gets a batch of items from the wrapped queue, if the queue
+// implements BatchInterface, and a single item otherwise.
+func (q *delayingType) GetBatch(max int, maxWait time.Duration) (items []interface{}, shutdown bool) {
+	return getBatch(q.Interface, max, maxWait)
+}
+
+// DoneBatch marks the items of a batch of the wrapped queue as done.
+func (q *delayingType) DoneBatch(items []interface{}) {
+	doneBatch(q.Interface, items)
+}
+
+// AddWithMetadata adds item to the wrapped queue with metadata, if the queue
+// implements MetadataInterface.
+func (q *delayingType) AddWithMetadata(item interface{}, metadata ItemMetadata) {
+	addWithMetadata(q.Interface, item, metadata)
+}
+
+// GetWithMetadata gets an item from the wrapped queue with its metadata, if
+// the queue implements MetadataInterface.
+func (q *delayingType) GetWithMetadata() (item interface{}, metadata ItemMetadata, shutdown bool) {
+	return getWithMetadata(q.Interface)
+}
+
 // waitFor holds the data to add and the time it should be added
 type waitFor struct {
 	data    t
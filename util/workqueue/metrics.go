@@ -30,6 +30,7 @@ type queueMetrics interface {
 	add(item t)
 	get(item t)
 	done(item t)
+	eventDone(metadata ItemMetadata)
 	updateUnfinishedWork()
 }
 
@@ -80,7 +81,11 @@ type defaultQueueMetrics struct {
 	// how long an item stays in a workqueue
 	latency HistogramMetric
 	// how long processing an item from a workqueue takes
-	workDuration         HistogramMetric
+	workDuration HistogramMetric
+	// how long it takes from the event that caused an item to be added to
+	// the item being done, per event type
+	eventLatencies       map[string]HistogramMetric
+	newEventLatency      func(eventType string) HistogramMetric
 	addTimes             map[t]time.Time
 	processingStartTimes map[t]time.Time
 
@@ -125,6 +130,19 @@ func (m *defaultQueueMetrics) done(item t) {
 	}
 }
 
+func (m *defaultQueueMetrics) eventDone(metadata ItemMetadata) {
+	if m == nil {
+		return
+	}
+
+	latency, exists := m.eventLatencies[metadata.EventType]
+	if !exists {
+		latency = m.newEventLatency(metadata.EventType)
+		m.eventLatencies[metadata.EventType] = latency
+	}
+	latency.Observe(m.sinceInSeconds(metadata.Timestamp))
+}
+
 func (m *defaultQueueMetrics) updateUnfinishedWork() {
 	// Note that a summary metric would be better for this, but prometheus
 	// doesn't seem to have non-hacky ways to reset the summary metrics.
@@ -143,10 +161,11 @@ func (m *defaultQueueMetrics) updateUnfinishedWork() {
 
 type noMetrics struct{}
 
-func (noMetrics) add(item t)            {}
-func (noMetrics) get(item t)            {}
-func (noMetrics) done(item t)           {}
-func (noMetrics) updateUnfinishedWork() {}
+func (noMetrics) add(item t)             {}
+func (noMetrics) get(item t)             {}
+func (noMetrics) done(item t)            {}
+func (noMetrics) eventDone(ItemMetadata) {}
+func (noMetrics) updateUnfinishedWork()  {}
 
 // Gets the time since the specified start in seconds.
 func (m *defaultQueueMetrics) sinceInSeconds(start time.Time) float64 {
@@ -180,6 +199,14 @@ type MetricsProvider interface {
 	NewRetriesMetric(name string) CounterMetric
 }
 
+// EventLatencyMetricsProvider is implemented by MetricsProviders that also
+// report the latency from the event that caused an item to be added with
+// AddWithMetadata to the item being done. (Separate from MetricsProvider to
+// preserve backwards compatibility.)
+type EventLatencyMetricsProvider interface {
+	NewEventLatencyMetric(name, eventType string) HistogramMetric
+}
+
 type noopMetricsProvider struct{}
 
 func (_ noopMetricsProvider) NewDepthMetric(name string) GaugeMetric {
@@ -231,6 +258,14 @@ func (f *queueMetricsFactory) newQueueMetrics(name string, clock clock.Clock) qu
 	if len(name) == 0 || mp == (noopMetricsProvider{}) {
 		return noMetrics{}
 	}
+	newEventLatency := func(eventType string) HistogramMetric {
+		return noopMetric{}
+	}
+	if elp, ok := mp.(EventLatencyMetricsProvider); ok {
+		newEventLatency = func(eventType string) HistogramMetric {
+			return elp.NewEventLatencyMetric(name, eventType)
+		}
+	}
 	return &defaultQueueMetrics{
 		clock:                   clock,
 		depth:                   mp.NewDepthMetric(name),
@@ -239,6 +274,8 @@ func (f *queueMetricsFactory) newQueueMetrics(name string, clock clock.Clock) qu
 		workDuration:            mp.NewWorkDurationMetric(name),
 		unfinishedWorkSeconds:   mp.NewUnfinishedWorkSecondsMetric(name),
 		longestRunningProcessor: mp.NewLongestRunningProcessorSecondsMetric(name),
+		eventLatencies:          map[string]HistogramMetric{},
+		newEventLatency:         newEventLatency,
 		addTimes:                map[t]time.Time{},
 		processingStartTimes:    map[t]time.Time{},
 	}
@@ -30,10 +30,11 @@ type testMetrics struct {
 	updateCalled chan<- struct{}
 }
 
-func (m *testMetrics) add(item t)            { m.added++ }
-func (m *testMetrics) get(item t)            { m.gotten++ }
-func (m *testMetrics) done(item t)           { m.finished++ }
-func (m *testMetrics) updateUnfinishedWork() { m.updateCalled <- struct{}{} }
+func (m *testMetrics) add(item t)             { m.added++ }
+func (m *testMetrics) get(item t)             { m.gotten++ }
+func (m *testMetrics) done(item t)            { m.finished++ }
+func (m *testMetrics) eventDone(ItemMetadata) {}
+func (m *testMetrics) updateUnfinishedWork()  { m.updateCalled <- struct{}{} }
 
 func TestMetricShutdown(t *testing.T) {
 	ch := make(chan struct{})
@@ -167,6 +168,17 @@ func (m *testMetricsProvider) NewRetriesMetric(name string) CounterMetric {
 	return &m.retries
 }
 
+type testEventLatencyMetricsProvider struct {
+	testMetricsProvider
+	eventLatencies map[string]*testMetric
+}
+
+func (m *testEventLatencyMetricsProvider) NewEventLatencyMetric(name, eventType string) HistogramMetric {
+	metric := &testMetric{}
+	m.eventLatencies[eventType] = metric
+	return metric
+}
+
 func TestMetrics(t *testing.T) {
 	mp := testMetricsProvider{}
 	t0 := time.Unix(0, 0)
@@ -276,3 +288,45 @@ func TestMetrics(t *testing.T) {
 		t.Errorf("expected %v, got %v", e, a)
 	}
 }
+
+func TestEventLatencyMetrics(t *testing.T) {
+	mp := testEventLatencyMetricsProvider{eventLatencies: map[string]*testMetric{}}
+	t0 := time.Unix(0, 0)
+	c := clock.NewFakeClock(t0)
+	mf := queueMetricsFactory{metricsProvider: &mp}
+	m := mf.newQueueMetrics("test", c)
+	q := newQueue(c, m, time.Millisecond)
+	defer q.ShutDown()
+
+	q.AddWithMetadata("foo", ItemMetadata{Timestamp: t0.Add(-time.Second), EventType: "update"})
+	q.AddWithMetadata("bar", ItemMetadata{EventType: "add"})
+	q.Add("baz")
+
+	c.Step(50 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		item, _ := q.Get()
+		q.Done(item)
+	}
+
+	if e, a := 2, len(mp.eventLatencies); e != a {
+		t.Fatalf("expected %v event types, got %v", e, a)
+	}
+	if e, a := 1.05, mp.eventLatencies["update"].observationValue(); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 0.05, mp.eventLatencies["add"].observationValue(); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+
+	// Items added without metadata are not reported, even when they were
+	// previously added with it.
+	q.AddWithMetadata("foo", ItemMetadata{EventType: "update"})
+	item, _ := q.Get()
+	q.Done(item)
+	q.Add("foo")
+	item, _ = q.Get()
+	q.Done(item)
+	if e, a := 2, mp.eventLatencies["update"].observationCount(); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+}
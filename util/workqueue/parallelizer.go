@@ -19,14 +19,36 @@ package workqueue
 import (
 	"context"
 	"sync"
+	"time"
 
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 )
 
 type DoWorkPieceFunc func(piece int)
 
+// DoWorkPieceWithErrorFunc processes a single piece of work for
+// ParallelizeUntilWithError. ctx is canceled once the remaining pieces
+// should not be processed anymore.
+type DoWorkPieceWithErrorFunc func(ctx context.Context, piece int) error
+
+// WorkerStats describes the work done by a single worker of
+// ParallelizeUntilWithError.
+type WorkerStats struct {
+	// Pieces is the number of pieces the worker processed.
+	Pieces int
+	// Errors is the number of pieces that returned an error.
+	Errors int
+	// Busy is the time the worker spent processing pieces.
+	Busy time.Duration
+	// Elapsed is the time from the worker starting to it exiting.
+	Elapsed time.Duration
+}
+
 type options struct {
-	chunkSize int
+	chunkSize   int
+	maxErrors   int
+	workerStats func([]WorkerStats)
 }
 
 type Options func(*options)
@@ -41,6 +63,27 @@ func WithChunkSize(c int) func(*options) {
 	}
 }
 
+// WithMaxErrors makes ParallelizeUntilWithError stop processing the remaining
+// pieces once n pieces have returned an error, and return at most the errors
+// of those n pieces. Use 1 to stop after the first error. By default every
+// piece is processed regardless of errors.
+// ParallelizeUntil ignores this option.
+func WithMaxErrors(n int) func(*options) {
+	return func(o *options) {
+		o.maxErrors = n
+	}
+}
+
+// WithWorkerStats makes ParallelizeUntilWithError call f, once all workers
+// have exited, with the statistics of every worker. It is meant for
+// profiling how evenly the work was spread. ParallelizeUntil ignores this
+// option.
+func WithWorkerStats(f func([]WorkerStats)) func(*options) {
+	return func(o *options) {
+		o.workerStats = f
+	}
+}
+
 // ParallelizeUntil is a framework that allows for parallelizing N
 // independent pieces of work until done or the context is canceled.
 func ParallelizeUntil(ctx context.Context, workers, pieces int, doWorkPiece DoWorkPieceFunc, opts ...Options) {
@@ -96,6 +139,108 @@ func ParallelizeUntil(ctx context.Context, workers, pieces int, doWorkPiece DoWo
 	wg.Wait()
 }
 
+// ParallelizeUntilWithError is like ParallelizeUntil, but doWorkPiece can
+// fail. The errors of all the pieces are returned as a
+// utilerrors.Aggregate, or nil if every piece succeeded. Processing of the
+// remaining pieces is canceled when ctx is canceled or, with WithMaxErrors,
+// once enough pieces have failed. If ctx is canceled before every piece is
+// processed, the errors include the error of ctx.
+func ParallelizeUntilWithError(ctx context.Context, workers, pieces int, doWorkPiece DoWorkPieceWithErrorFunc, opts ...Options) error {
+	if pieces == 0 {
+		return nil
+	}
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	chunkSize := o.chunkSize
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+
+	chunks := ceilDiv(pieces, chunkSize)
+	toProcess := make(chan int, chunks)
+	for i := 0; i < chunks; i++ {
+		toProcess <- i
+	}
+	close(toProcess)
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if chunks < workers {
+		workers = chunks
+	}
+
+	var errsLock sync.Mutex
+	var errs []error
+	stats := make([]WorkerStats, workers)
+
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(stats *WorkerStats) {
+			defer utilruntime.HandleCrash()
+			defer wg.Done()
+			workerStart := time.Now()
+			defer func() {
+				stats.Elapsed = time.Since(workerStart)
+			}()
+			for chunk := range toProcess {
+				start := chunk * chunkSize
+				end := start + chunkSize
+				if end > pieces {
+					end = pieces
+				}
+				for p := start; p < end; p++ {
+					select {
+					case <-ctx.Done():
+						return
+					default:
+					}
+
+					pieceStart := time.Now()
+					err := doWorkPiece(ctx, p)
+					stats.Busy += time.Since(pieceStart)
+					stats.Pieces++
+					if err == nil {
+						continue
+					}
+
+					stats.Errors++
+					errsLock.Lock()
+					// pieces still in flight when the limit is reached can
+					// fail too, their errors are dropped.
+					if o.maxErrors <= 0 || len(errs) < o.maxErrors {
+						errs = append(errs, err)
+						if o.maxErrors > 0 && len(errs) == o.maxErrors {
+							cancel()
+						}
+					}
+					errsLock.Unlock()
+				}
+			}
+		}(&stats[i])
+	}
+	wg.Wait()
+
+	if o.workerStats != nil {
+		o.workerStats(stats)
+	}
+	processed := 0
+	for _, s := range stats {
+		processed += s.Pieces
+	}
+	if processed < pieces && parent.Err() != nil {
+		// pieces were skipped, not only failed.
+		errs = append(errs, parent.Err())
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
 func ceilDiv(a, b int) int {
 	return (a + b - 1) / b
 }
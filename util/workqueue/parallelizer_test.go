@@ -18,11 +18,14 @@ package workqueue
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync/atomic"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
 type testCase struct {
@@ -109,3 +112,136 @@ func calPrime(p int) bool {
 	}
 	return true
 }
+
+func TestParallelizeUntilWithError(t *testing.T) {
+	for _, tc := range cases {
+		t.Run(tc.String(), func(t *testing.T) {
+			seen := make([]int32, tc.pieces)
+			var stats []WorkerStats
+			ctx := context.Background()
+			err := ParallelizeUntilWithError(ctx, tc.workers, tc.pieces, func(ctx context.Context, p int) error {
+				atomic.AddInt32(&seen[p], 1)
+				if p%100 == 0 {
+					return fmt.Errorf("piece %d failed", p)
+				}
+				return nil
+			}, WithChunkSize(tc.chunkSize), WithWorkerStats(func(s []WorkerStats) {
+				stats = s
+			}))
+
+			wantSeen := make([]int32, tc.pieces)
+			for i := 0; i < tc.pieces; i++ {
+				wantSeen[i] = 1
+			}
+			if diff := cmp.Diff(wantSeen, seen); diff != "" {
+				t.Errorf("bad number of visits (-want,+got):\n%s", diff)
+			}
+
+			agg, ok := err.(utilerrors.Aggregate)
+			if !ok {
+				t.Fatalf("expected an aggregate error, got %v", err)
+			}
+			if e, a := 10, len(agg.Errors()); e != a {
+				t.Errorf("expected %d errors, got %d", e, a)
+			}
+
+			pieces, errs := 0, 0
+			for _, s := range stats {
+				pieces += s.Pieces
+				errs += s.Errors
+				if s.Busy > s.Elapsed {
+					t.Errorf("worker busy for %v but only ran for %v", s.Busy, s.Elapsed)
+				}
+			}
+			if pieces != tc.pieces || errs != 10 {
+				t.Errorf("expected stats to add up to %d pieces and 10 errors, got %d and %d", tc.pieces, pieces, errs)
+			}
+		})
+	}
+}
+
+func TestParallelizeUntilWithErrorSucceeds(t *testing.T) {
+	err := ParallelizeUntilWithError(context.Background(), 10, 100, func(ctx context.Context, p int) error {
+		return nil
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParallelizeUntilWithErrorMaxErrors(t *testing.T) {
+	var processed int32
+	err := ParallelizeUntilWithError(context.Background(), 1, 1000, func(ctx context.Context, p int) error {
+		atomic.AddInt32(&processed, 1)
+		if p >= 10 {
+			return fmt.Errorf("piece %d failed", p)
+		}
+		return nil
+	}, WithMaxErrors(3))
+
+	if e, a := int32(13), atomic.LoadInt32(&processed); e != a {
+		t.Errorf("expected %d pieces to be processed, got %d", e, a)
+	}
+	agg, ok := err.(utilerrors.Aggregate)
+	if !ok {
+		t.Fatalf("expected an aggregate error, got %v", err)
+	}
+	if e, a := 3, len(agg.Errors()); e != a {
+		t.Errorf("expected %d errors, got %d", e, a)
+	}
+}
+
+func TestParallelizeUntilWithErrorMaxErrorsConcurrent(t *testing.T) {
+	const workers = 10
+	var started int32
+	allStarted := make(chan struct{})
+	// every worker fails its piece once all of them picked one up.
+	err := ParallelizeUntilWithError(context.Background(), workers, workers, func(ctx context.Context, p int) error {
+		if atomic.AddInt32(&started, 1) == workers {
+			close(allStarted)
+		}
+		<-allStarted
+		return fmt.Errorf("piece %d failed", p)
+	}, WithMaxErrors(3))
+
+	agg, ok := err.(utilerrors.Aggregate)
+	if !ok {
+		t.Fatalf("expected an aggregate error, got %v", err)
+	}
+	if e, a := 3, len(agg.Errors()); e != a {
+		t.Errorf("expected %d errors, got %d", e, a)
+	}
+}
+
+func TestParallelizeUntilWithErrorCancelsContext(t *testing.T) {
+	started := make(chan struct{})
+	err := ParallelizeUntilWithError(context.Background(), 2, 2, func(ctx context.Context, p int) error {
+		if p == 0 {
+			<-started
+			return fmt.Errorf("piece %d failed", p)
+		}
+		close(started)
+		// Blocks until the failure of the other piece cancels ctx.
+		<-ctx.Done()
+		return nil
+	}, WithMaxErrors(1))
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestParallelizeUntilWithErrorParentCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := ParallelizeUntilWithError(ctx, 1, 10, func(ctx context.Context, p int) error {
+		if p == 2 {
+			cancel()
+		}
+		return nil
+	})
+	// the pieces after the third are skipped.
+	agg, ok := err.(utilerrors.Aggregate)
+	if !ok || len(agg.Errors()) != 1 || !errors.Is(agg.Errors()[0], context.Canceled) {
+		t.Errorf("expected the error of the canceled context, got %v", err)
+	}
+}
@@ -32,6 +32,38 @@ type Interface interface {
 	ShuttingDown() bool
 }
 
+// BatchInterface is an Interface that can also hand out and finish items in
+// batches. (Separate from Interface to preserve backwards compatibility.)
+type BatchInterface interface {
+	Interface
+	GetBatch(max int, maxWait time.Duration) (items []interface{}, shutdown bool)
+	DoneBatch(items []interface{})
+}
+
+// MetadataInterface is an Interface that also carries metadata about the events
+// that caused items to be added. (Separate from Interface to preserve backwards
+// compatibility.)
+type MetadataInterface interface {
+	Interface
+	AddWithMetadata(item interface{}, metadata ItemMetadata)
+	GetWithMetadata() (item interface{}, metadata ItemMetadata, shutdown bool)
+}
+
+// ItemMetadata describes the event that caused an item to be added to a queue.
+// The queue carries it from AddWithMetadata to GetWithMetadata and uses it to
+// report how long it took from the event to the item being Done.
+type ItemMetadata struct {
+	// Timestamp is when the event happened. If it is zero, AddWithMetadata
+	// sets it to the current time.
+	Timestamp time.Time
+	// TraceContext is an opaque value, such as a span context, that lets the
+	// caller attribute the processing of the item to a trace.
+	TraceContext interface{}
+	// EventType is the kind of event, for example "add", "update" or
+	// "delete". Latency metrics are reported per event type.
+	EventType string
+}
+
 // New constructs a new work queue (see the package comment).
 func New() *Type {
 	return NewNamed("")
@@ -51,6 +83,8 @@ func newQueue(c clock.Clock, metrics queueMetrics, updatePeriod time.Duration) *
 		clock:                      c,
 		dirty:                      set{},
 		processing:                 set{},
+		metadata:                   map[t]ItemMetadata{},
+		processingMetadata:         map[t]ItemMetadata{},
 		cond:                       sync.NewCond(&sync.Mutex{}),
 		metrics:                    metrics,
 		unfinishedWorkUpdatePeriod: updatePeriod,
@@ -65,6 +99,9 @@ func newQueue(c clock.Clock, metrics queueMetrics, updatePeriod time.Duration) *
 	return t
 }
 
+var _ BatchInterface = &Type{}
+var _ MetadataInterface = &Type{}
+
 const defaultUnfinishedWorkUpdatePeriod = 500 * time.Millisecond
 
 // Type is a work queue (see the package comment).
@@ -83,6 +120,15 @@ type Type struct {
 	// it's in the dirty set, and if so, add it to the queue.
 	processing set
 
+	// metadata holds the metadata of the dirty items that were added with
+	// AddWithMetadata. When an item is added several times before it is
+	// processed, the metadata with the earliest timestamp is kept.
+	metadata map[t]ItemMetadata
+
+	// processingMetadata holds the metadata of the items in the processing
+	// set, until they are Done.
+	processingMetadata map[t]ItemMetadata
+
 	cond *sync.Cond
 
 	shuttingDown bool
@@ -112,8 +158,31 @@ func (s set) delete(item t) {
 
 // Add marks item as needing processing.
 func (q *Type) Add(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.addLocked(item)
+}
+
+// AddWithMetadata marks item as needing processing, and records metadata about
+// the event that caused it. If item is already waiting to be processed, only
+// the metadata with the earliest timestamp is kept.
+func (q *Type) AddWithMetadata(item interface{}, metadata ItemMetadata) {
 	q.cond.L.Lock()
 	defer q.cond.L.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if metadata.Timestamp.IsZero() {
+		metadata.Timestamp = q.clock.Now()
+	}
+	if existing, ok := q.metadata[item]; !ok || metadata.Timestamp.Before(existing.Timestamp) {
+		q.metadata[item] = metadata
+	}
+	q.addLocked(item)
+}
+
+// addLocked implements Add. The caller must hold q.cond.L.
+func (q *Type) addLocked(item interface{}) {
 	if q.shuttingDown {
 		return
 	}
@@ -155,6 +224,76 @@ func (q *Type) Get() (item interface{}, shutdown bool) {
 		return nil, true
 	}
 
+	return q.popLocked(), false
+}
+
+// GetWithMetadata is like Get, but also returns the metadata the item was
+// added with, if any.
+func (q *Type) GetWithMetadata() (item interface{}, metadata ItemMetadata, shutdown bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for len(q.queue) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if len(q.queue) == 0 {
+		// We must be shutting down.
+		return nil, ItemMetadata{}, true
+	}
+
+	item = q.popLocked()
+	return item, q.processingMetadata[item], false
+}
+
+// addWithMetadata adds item to q with metadata if q implements
+// MetadataInterface, and without it otherwise.
+func addWithMetadata(q Interface, item interface{}, metadata ItemMetadata) {
+	if mq, ok := q.(MetadataInterface); ok {
+		mq.AddWithMetadata(item, metadata)
+		return
+	}
+	q.Add(item)
+}
+
+// getWithMetadata gets an item from q with its metadata if q implements
+// MetadataInterface, and with empty metadata otherwise.
+func getWithMetadata(q Interface) (item interface{}, metadata ItemMetadata, shutdown bool) {
+	if mq, ok := q.(MetadataInterface); ok {
+		return mq.GetWithMetadata()
+	}
+	item, shutdown = q.Get()
+	return item, ItemMetadata{}, shutdown
+}
+
+// getBatch gets a batch of items from q if q implements BatchInterface, and a
+// single item otherwise.
+func getBatch(q Interface, max int, maxWait time.Duration) (items []interface{}, shutdown bool) {
+	if bq, ok := q.(BatchInterface); ok {
+		return bq.GetBatch(max, maxWait)
+	}
+	item, shutdown := q.Get()
+	if shutdown {
+		return nil, true
+	}
+	return []interface{}{item}, false
+}
+
+// doneBatch marks the items of a batch of q as done, at once if q implements
+// BatchInterface.
+func doneBatch(q Interface, items []interface{}) {
+	if bq, ok := q.(BatchInterface); ok {
+		bq.DoneBatch(items)
+		return
+	}
+	for _, item := range items {
+		q.Done(item)
+	}
+}
+
+// popLocked moves the item at the head of the queue into the processing set
+// and returns it. The caller must hold q.cond.L and ensure the queue is not
+// empty.
+func (q *Type) popLocked() interface{} {
+	var item t
 	item, q.queue = q.queue[0], q.queue[1:]
 
 	q.metrics.get(item)
@@ -162,7 +301,76 @@ func (q *Type) Get() (item interface{}, shutdown bool) {
 	q.processing.insert(item)
 	q.dirty.delete(item)
 
-	return item, false
+	if metadata, ok := q.metadata[item]; ok {
+		q.processingMetadata[item] = metadata
+		delete(q.metadata, item)
+	}
+
+	return item
+}
+
+// GetBatch blocks until it can return at least one item to be processed, then
+// keeps collecting distinct items until it has max of them or maxWait has
+// elapsed since the first one was taken. Every returned item is marked as
+// processing, exactly as if it had been returned by Get. If shutdown = true,
+// the caller should end their goroutine. You must call Done with every item,
+// or DoneBatch with the whole batch, when you have finished processing them.
+func (q *Type) GetBatch(max int, maxWait time.Duration) (items []interface{}, shutdown bool) {
+	if max < 1 {
+		max = 1
+	}
+
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for len(q.queue) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if len(q.queue) == 0 {
+		// We must be shutting down.
+		return nil, true
+	}
+
+	items = q.takeLocked(items, max)
+	if len(items) == max || maxWait <= 0 || q.shuttingDown {
+		return items, false
+	}
+
+	// Wait for more items to show up. The timer wakes us up through the
+	// condition variable, so we must only look at expired with the lock held.
+	expired := false
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	timer := q.clock.NewTimer(maxWait)
+	defer timer.Stop()
+	go func() {
+		select {
+		case <-timer.C():
+			q.cond.L.Lock()
+			defer q.cond.L.Unlock()
+			expired = true
+			q.cond.Broadcast()
+		case <-stopCh:
+		}
+	}()
+
+	for len(items) < max && !expired && !q.shuttingDown {
+		if len(q.queue) == 0 {
+			q.cond.Wait()
+			continue
+		}
+		items = q.takeLocked(items, max)
+	}
+	return items, false
+}
+
+// takeLocked moves items from the head of the queue into the processing set
+// and appends them to batch until batch holds max items or the queue is
+// empty. The caller must hold q.cond.L.
+func (q *Type) takeLocked(batch []interface{}, max int) []interface{} {
+	for len(batch) < max && len(q.queue) > 0 {
+		batch = append(batch, q.popLocked())
+	}
+	return batch
 }
 
 // Done marks item as done processing, and if it has been marked as dirty again
@@ -172,7 +380,26 @@ func (q *Type) Done(item interface{}) {
 	q.cond.L.Lock()
 	defer q.cond.L.Unlock()
 
+	q.doneLocked(item)
+}
+
+// DoneBatch marks every item in items as done processing, see Done.
+func (q *Type) DoneBatch(items []interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	for _, item := range items {
+		q.doneLocked(item)
+	}
+}
+
+// doneLocked implements Done. The caller must hold q.cond.L.
+func (q *Type) doneLocked(item interface{}) {
 	q.metrics.done(item)
+	if metadata, ok := q.processingMetadata[item]; ok {
+		q.metrics.eventDone(metadata)
+		delete(q.processingMetadata, item)
+	}
 
 	q.processing.delete(item)
 	if q.dirty.has(item) {
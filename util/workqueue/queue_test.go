@@ -17,10 +17,12 @@ limitations under the License.
 package workqueue_test
 
 import (
+	"reflect"
 	"sync"
 	"testing"
 	"time"
 
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/util/workqueue"
 )
 
@@ -159,3 +161,143 @@ func TestReinsert(t *testing.T) {
 		t.Errorf("Expected queue to be empty. Has %v items", a)
 	}
 }
+
+func TestGetBatch(t *testing.T) {
+	q := workqueue.New()
+	q.Add("foo")
+	q.Add("bar")
+	q.Add("baz")
+	q.Add("foo") // should not be returned twice.
+
+	items, shutdown := q.GetBatch(2, 0)
+	if shutdown {
+		t.Fatalf("Unexpected shutdown")
+	}
+	if e, a := []interface{}{"foo", "bar"}, items; !reflect.DeepEqual(e, a) {
+		t.Errorf("Expected %v, got %v", e, a)
+	}
+	if e, a := 1, q.Len(); e != a {
+		t.Errorf("Expected %v, got %v", e, a)
+	}
+
+	// Re-adding an item that is being processed must only mark it dirty.
+	q.Add("foo")
+	items, _ = q.GetBatch(10, 0)
+	if e, a := []interface{}{"baz"}, items; !reflect.DeepEqual(e, a) {
+		t.Errorf("Expected %v, got %v", e, a)
+	}
+
+	q.DoneBatch([]interface{}{"foo", "bar", "baz"})
+	if e, a := 1, q.Len(); e != a {
+		t.Errorf("Expected %v, got %v", e, a)
+	}
+	items, _ = q.GetBatch(10, 0)
+	if e, a := []interface{}{"foo"}, items; !reflect.DeepEqual(e, a) {
+		t.Errorf("Expected %v, got %v", e, a)
+	}
+	q.DoneBatch(items)
+	if a := q.Len(); a != 0 {
+		t.Errorf("Expected queue to be empty. Has %v items", a)
+	}
+}
+
+func TestGetBatchWaitsForMore(t *testing.T) {
+	q := workqueue.New()
+	q.Add("foo")
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Add("bar")
+	}()
+
+	items, _ := q.GetBatch(2, wait.ForeverTestTimeout)
+	if e, a := []interface{}{"foo", "bar"}, items; !reflect.DeepEqual(e, a) {
+		t.Errorf("Expected %v, got %v", e, a)
+	}
+	q.DoneBatch(items)
+}
+
+func TestGetBatchMaxWait(t *testing.T) {
+	q := workqueue.New()
+	q.Add("foo")
+
+	start := time.Now()
+	items, _ := q.GetBatch(2, 50*time.Millisecond)
+	if e, a := []interface{}{"foo"}, items; !reflect.DeepEqual(e, a) {
+		t.Errorf("Expected %v, got %v", e, a)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected GetBatch to wait at least 50ms, returned after %v", elapsed)
+	}
+	q.DoneBatch(items)
+}
+
+func TestGetBatchShutdown(t *testing.T) {
+	q := workqueue.New()
+	q.Add("foo")
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.ShutDown()
+	}()
+
+	// A batch in progress is returned as soon as the queue shuts down.
+	items, shutdown := q.GetBatch(2, wait.ForeverTestTimeout)
+	if shutdown {
+		t.Errorf("Unexpected shutdown with a non-empty batch")
+	}
+	if e, a := []interface{}{"foo"}, items; !reflect.DeepEqual(e, a) {
+		t.Errorf("Expected %v, got %v", e, a)
+	}
+
+	items, shutdown = q.GetBatch(2, wait.ForeverTestTimeout)
+	if !shutdown || len(items) != 0 {
+		t.Errorf("Expected shutdown with no items, got %v, %v", items, shutdown)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	q := workqueue.New()
+	t0 := time.Now()
+
+	q.AddWithMetadata("foo", workqueue.ItemMetadata{Timestamp: t0.Add(time.Second), EventType: "update", TraceContext: "late"})
+	q.AddWithMetadata("foo", workqueue.ItemMetadata{Timestamp: t0, EventType: "add", TraceContext: "early"})
+	q.AddWithMetadata("foo", workqueue.ItemMetadata{Timestamp: t0.Add(2 * time.Second), EventType: "delete"})
+	if e, a := 1, q.Len(); e != a {
+		t.Errorf("Expected %v, got %v", e, a)
+	}
+
+	item, metadata, _ := q.GetWithMetadata()
+	if item != "foo" {
+		t.Errorf("Expected %v, got %v", "foo", item)
+	}
+	expected := workqueue.ItemMetadata{Timestamp: t0, EventType: "add", TraceContext: "early"}
+	if !reflect.DeepEqual(expected, metadata) {
+		t.Errorf("Expected %v, got %v", expected, metadata)
+	}
+
+	// Add it back while processing, the new metadata belongs to the next
+	// round of processing.
+	q.AddWithMetadata("foo", workqueue.ItemMetadata{Timestamp: t0.Add(3 * time.Second), EventType: "update"})
+	q.Done(item)
+
+	item, metadata, _ = q.GetWithMetadata()
+	expected = workqueue.ItemMetadata{Timestamp: t0.Add(3 * time.Second), EventType: "update"}
+	if !reflect.DeepEqual(expected, metadata) {
+		t.Errorf("Expected %v, got %v", expected, metadata)
+	}
+	q.Done(item)
+
+	// Items added without metadata have none, and missing timestamps are
+	// filled in.
+	q.Add("bar")
+	q.AddWithMetadata("baz", workqueue.ItemMetadata{EventType: "add"})
+	_, metadata, _ = q.GetWithMetadata()
+	if !reflect.DeepEqual(workqueue.ItemMetadata{}, metadata) {
+		t.Errorf("Expected no metadata, got %v", metadata)
+	}
+	_, metadata, _ = q.GetWithMetadata()
+	if metadata.Timestamp.Before(t0) {
+		t.Errorf("Expected timestamp to be set to the time of the add, got %v", metadata.Timestamp)
+	}
+}
@@ -16,6 +16,8 @@ limitations under the License.
 
 package workqueue
 
+import "time"
+
 // RateLimitingInterface is an interface that rate limits items being added to the queue.
 type RateLimitingInterface interface {
 	DelayingInterface
@@ -32,6 +34,17 @@ type RateLimitingInterface interface {
 	NumRequeues(item interface{}) int
 }
 
+// ErrorRateLimitingInterface is a RateLimitingInterface that can also let the rate limiter take
+// errors into account. (Separate from RateLimitingInterface to preserve backwards compatibility.)
+type ErrorRateLimitingInterface interface {
+	RateLimitingInterface
+
+	// AddRateLimitedWithError adds an item to the workqueue after the rate limiter says it's ok, letting
+	// the rate limiter take the error from the item's last processing attempt into account. Rate limiters
+	// that don't implement ErrorRateLimiter behave as for AddRateLimited.
+	AddRateLimitedWithError(item interface{}, err error)
+}
+
 // NewRateLimitingQueue constructs a new workqueue with rateLimited queuing ability
 // Remember to call Forget!  If you don't, you may end up tracking failures forever.
 func NewRateLimitingQueue(rateLimiter RateLimiter) RateLimitingInterface {
@@ -55,11 +68,47 @@ type rateLimitingType struct {
 	rateLimiter RateLimiter
 }
 
+var _ ErrorRateLimitingInterface = &rateLimitingType{}
+var _ BatchInterface = &rateLimitingType{}
+var _ MetadataInterface = &rateLimitingType{}
+
+// GetBatch gets a batch of items from the wrapped queue, if the queue
+// implements BatchInterface, and a single item otherwise.
+func (q *rateLimitingType) GetBatch(max int, maxWait time.Duration) (items []interface{}, shutdown bool) {
+	return getBatch(q.DelayingInterface, max, maxWait)
+}
+
+// DoneBatch marks the items of a batch of the wrapped queue as done.
+func (q *rateLimitingType) DoneBatch(items []interface{}) {
+	doneBatch(q.DelayingInterface, items)
+}
+
+// AddWithMetadata adds item to the wrapped queue with metadata, if the queue
+// implements MetadataInterface.
+func (q *rateLimitingType) AddWithMetadata(item interface{}, metadata ItemMetadata) {
+	addWithMetadata(q.DelayingInterface, item, metadata)
+}
+
+// GetWithMetadata gets an item from the wrapped queue with its metadata, if
+// the queue implements MetadataInterface.
+func (q *rateLimitingType) GetWithMetadata() (item interface{}, metadata ItemMetadata, shutdown bool) {
+	return getWithMetadata(q.DelayingInterface)
+}
+
 // AddRateLimited AddAfter's the item based on the time when the rate limiter says it's ok
 func (q *rateLimitingType) AddRateLimited(item interface{}) {
 	q.DelayingInterface.AddAfter(item, q.rateLimiter.When(item))
 }
 
+// AddRateLimitedWithError AddAfter's the item based on the time when the rate limiter says it's ok given err
+func (q *rateLimitingType) AddRateLimitedWithError(item interface{}, err error) {
+	if errLimiter, ok := q.rateLimiter.(ErrorRateLimiter); ok {
+		q.DelayingInterface.AddAfter(item, errLimiter.WhenWithError(item, err))
+		return
+	}
+	q.DelayingInterface.AddAfter(item, q.rateLimiter.When(item))
+}
+
 func (q *rateLimitingType) NumRequeues(item interface{}) int {
 	return q.rateLimiter.NumRequeues(item)
 }
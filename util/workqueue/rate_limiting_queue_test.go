@@ -17,9 +17,12 @@ limitations under the License.
 package workqueue
 
 import (
+	"errors"
 	"testing"
 	"time"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/clock"
 )
 
@@ -73,3 +76,76 @@ func TestRateLimitingQueue(t *testing.T) {
 	}
 
 }
+
+func TestRateLimitingQueueWithError(t *testing.T) {
+	limiter := NewErrorClassifyingRateLimiter(nil, 1*time.Millisecond, 10*time.Millisecond, 1*time.Second, 10*time.Second, 0)
+	queue := NewRateLimitingQueue(limiter).(*rateLimitingType)
+	fakeClock := clock.NewFakeClock(time.Now())
+	delayingQueue := &delayingType{
+		Interface:       New(),
+		clock:           fakeClock,
+		heartbeat:       fakeClock.NewTicker(maxWait),
+		stopCh:          make(chan struct{}),
+		waitingForAddCh: make(chan *waitFor, 1000),
+		metrics:         newRetryMetrics(""),
+	}
+	queue.DelayingInterface = delayingQueue
+
+	queue.AddRateLimitedWithError("one", apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, "one", errors.New("stale")))
+	waitEntry := <-delayingQueue.waitingForAddCh
+	if e, a := 1*time.Millisecond, waitEntry.readyAt.Sub(fakeClock.Now()); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	queue.AddRateLimitedWithError("one", apierrors.NewTooManyRequests("slow down", 5))
+	waitEntry = <-delayingQueue.waitingForAddCh
+	if e, a := 5*time.Second, waitEntry.readyAt.Sub(fakeClock.Now()); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+	if e, a := 2, queue.NumRequeues("one"); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+
+	// Rate limiters that don't understand errors fall back to When.
+	queue.rateLimiter = NewItemExponentialFailureRateLimiter(1*time.Millisecond, 1*time.Second)
+	queue.AddRateLimitedWithError("two", apierrors.NewTooManyRequests("slow down", 5))
+	waitEntry = <-delayingQueue.waitingForAddCh
+	if e, a := 1*time.Millisecond, waitEntry.readyAt.Sub(fakeClock.Now()); e != a {
+		t.Errorf("expected %v, got %v", e, a)
+	}
+}
+
+func TestRateLimitingQueueMetadata(t *testing.T) {
+	queue := NewRateLimitingQueue(NewItemExponentialFailureRateLimiter(1*time.Millisecond, 1*time.Second)).(MetadataInterface)
+	defer queue.ShutDown()
+
+	queue.AddWithMetadata("one", ItemMetadata{EventType: "add"})
+	item, metadata, _ := queue.GetWithMetadata()
+	if item != "one" || metadata.EventType != "add" || metadata.Timestamp.IsZero() {
+		t.Errorf("expected one with the metadata it was added with, got %v with %+v", item, metadata)
+	}
+	queue.Done(item)
+}
+
+func TestRateLimitingQueueBatch(t *testing.T) {
+	queue := NewRateLimitingQueue(NewItemExponentialFailureRateLimiter(1*time.Millisecond, 1*time.Second)).(BatchInterface)
+	defer queue.ShutDown()
+
+	queue.Add("one")
+	queue.Add("two")
+	queue.Add("three")
+	items, shutdown := queue.GetBatch(2, 0)
+	if shutdown || len(items) != 2 || items[0] != "one" || items[1] != "two" {
+		t.Fatalf("expected a batch of one and two, got %v, %v", items, shutdown)
+	}
+	// an item of the batch added again is queued once the batch is done.
+	queue.Add("one")
+	queue.DoneBatch(items)
+	if e, a := 2, queue.Len(); e != a {
+		t.Errorf("expected %d queued items, got %d", e, a)
+	}
+	items, _ = queue.GetBatch(10, 0)
+	if len(items) != 2 || items[0] != "three" || items[1] != "one" {
+		t.Errorf("expected a batch of three and one, got %v", items)
+	}
+	queue.DoneBatch(items)
+}